@@ -0,0 +1,49 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+// CacheName 服务器模式下每个任务都会带上的缓存资源，读写的是任务的检查点
+const CacheName = "clams_checkpoint"
+
+// Load 读出检查点，本地模式或从未保存过时返回空
+func Load(ctx context.Context, mgr *service.Resources, key string) (string, error) {
+	if mgr == nil || !mgr.HasCache(CacheName) {
+		return "", nil
+	}
+
+	var (
+		value []byte
+		err   error
+	)
+	accessErr := mgr.AccessCache(ctx, CacheName, func(c service.Cache) {
+		value, err = c.Get(ctx, key)
+	})
+	if accessErr != nil {
+		return "", accessErr
+	}
+	if errors.Is(err, service.ErrKeyNotFound) {
+		return "", nil
+	}
+	return string(value), err
+}
+
+// Save 保存检查点，本地模式下什么都不做
+func Save(ctx context.Context, mgr *service.Resources, key string, value string) error {
+	if mgr == nil || !mgr.HasCache(CacheName) {
+		return nil
+	}
+
+	var err error
+	accessErr := mgr.AccessCache(ctx, CacheName, func(c service.Cache) {
+		err = c.Set(ctx, key, []byte(value), nil)
+	})
+	if accessErr != nil {
+		return accessErr
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// apiClient 调用任务api
+type apiClient struct {
+	cfg  config
+	http *http.Client
+}
+
+func newApiClient(cfg config) *apiClient {
+	return &apiClient{cfg: cfg, http: &http.Client{Timeout: 60 * time.Second}}
+}
+
+// do 发出请求，配置了命名空间时带上namespace参数，非2xx时把响应中的error作为错误返回
+func (cli *apiClient) do(method string, path string, contentType string, body io.Reader) ([]byte, error) {
+	target := strings.TrimRight(cli.cfg.Server, "/") + "/api/v1" + path
+	if cli.cfg.Namespace != "" {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		target += sep + "namespace=" + url.QueryEscape(cli.cfg.Namespace)
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if cli.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cli.cfg.Token)
+	}
+
+	resp, err := cli.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bytesArr, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errBody struct {
+			Error  string `json:"error"`
+			Fields []struct {
+				Field   string `json:"field"`
+				Message string `json:"message"`
+			} `json:"fields"`
+		}
+		if json.Unmarshal(bytesArr, &errBody) == nil && errBody.Error != "" {
+			msgs := []string{errBody.Error}
+			for _, f := range errBody.Fields {
+				msgs = append(msgs, f.Field+" "+f.Message)
+			}
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.Join(msgs, "; "))
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(bytesArr)))
+	}
+
+	return bytesArr, nil
+}
+
+// doJSON 发出请求并解析json响应
+func (cli *apiClient) doJSON(method string, path string, contentType string, body io.Reader, v any) error {
+	bytesArr, err := cli.do(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	if v == nil || len(bytesArr) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytesArr, v)
+}
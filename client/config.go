@@ -0,0 +1,46 @@
+package client
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config 客户端配置，namespace为空时由服务器决定
+type config struct {
+	Server    string `yaml:"server"`
+	Token     string `yaml:"token"`
+	Namespace string `yaml:"namespace"`
+}
+
+// loadConfig 读取配置文件，未指定时依次尝试 $CLAMS_CONFIG 和 ~/.clams.yml
+func loadConfig(path string) (config, error) {
+	cfg := config{Server: "http://localhost:80"}
+
+	if path == "" {
+		path = os.Getenv("CLAMS_CONFIG")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return cfg, nil
+		}
+		path = filepath.Join(home, ".clams.yml")
+	}
+
+	bytesArr, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := yaml.Unmarshal(bytesArr, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,643 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// taskCommand clams task 的一个子命令
+type taskCommand struct {
+	args  string
+	flags func(*command)
+	run   func(*command) error
+	// argsOptional 为true时args可以省略
+	argsOptional bool
+}
+
+// taskCommands clams task 的子命令
+var taskCommands = map[string]taskCommand{
+	"submit":    {args: "<file.yml>", flags: submitFlags, run: submitTask},
+	"batch":     {args: "<archive>", flags: batchFlags, run: submitBatch},
+	"list":      {flags: listFlags, run: listTasks},
+	"status":    {args: "<id>", run: statusTask},
+	"edit":      {args: "<id>", flags: editFlags, run: editTask},
+	"revisions": {args: "<id>", run: taskRevisions},
+	"cancel":    {args: "<id>", flags: cancelFlags, run: cancelTask, argsOptional: true},
+	"rerun":     {args: "<id>", run: rerunTask},
+	"logs":      {args: "<id>", run: taskLogs},
+	"export":    {flags: exportFlags, run: exportTasks},
+	"import":    {args: "<file.jsonl>", run: importTasks},
+}
+
+// command 一次子命令调用
+type command struct {
+	flags  *flag.FlagSet
+	cli    *apiClient
+	output string
+	out    io.Writer
+
+	scheduledAt  string
+	file         string
+	concurrency  multiFlag
+	params       multiFlag
+	labels       multiFlag
+	allOrNothing bool
+	state        string
+	window       string
+	deadline     string
+	overdue      bool
+	outFile      string
+	limit        int
+	offset       int
+}
+
+// multiFlag 可重复的参数
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// RunTask 执行 clams task <subcommand>，返回进程退出码
+func RunTask(args []string) int {
+	if len(args) == 0 {
+		taskUsage()
+		return 2
+	}
+	sub, ok := taskCommands[args[0]]
+	if !ok {
+		taskUsage()
+		return 2
+	}
+
+	cmd := &command{flags: flag.NewFlagSet("clams task "+args[0], flag.ExitOnError), out: os.Stdout}
+	cfgPath := cmd.flags.String("config", "", "client config, default $CLAMS_CONFIG or ~/.clams.yml")
+	cmd.flags.StringVar(&cmd.output, "o", "table", "output format, table or json")
+	namespace := cmd.flags.String("namespace", "", "namespace of the tasks, default namespace in the config")
+	if sub.flags != nil {
+		sub.flags(cmd)
+	}
+	cmd.flags.Usage = func() {
+		fmt.Fprintf(cmd.flags.Output(), "usage: clams task %s [options] %s\n", args[0], sub.args)
+		cmd.flags.PrintDefaults()
+	}
+	cmd.flags.Parse(args[1:])
+
+	if cmd.output != "table" && cmd.output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", cmd.output)
+		return 2
+	}
+	if sub.args != "" && cmd.flags.NArg() != 1 && !(sub.argsOptional && cmd.flags.NArg() == 0) {
+		cmd.flags.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *namespace != "" {
+		cfg.Namespace = *namespace
+	}
+	cmd.cli = newApiClient(cfg)
+
+	if err := sub.run(cmd); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func taskUsage() {
+	fmt.Fprintln(os.Stderr, "usage: clams task <submit|batch|list|status|edit|revisions|cancel|rerun|logs|export|import> [options]")
+}
+
+func submitFlags(cmd *command) {
+	cmd.flags.StringVar(&cmd.scheduledAt, "scheduled-at", "", "schedule time, like 2023-12-31 00:00:00")
+	cmd.flags.Var(&cmd.concurrency, "concurrency", "concurrency group as key=limit, repeatable")
+	cmd.flags.Var(&cmd.params, "param", "task parameter as name=value, repeatable")
+	cmd.flags.Var(&cmd.labels, "label", "task label as key=value, repeatable")
+	cmd.flags.StringVar(&cmd.window, "window", "", "run only within cron fields, like 'TZ=Asia/Shanghai * 0-5 * * *'")
+	cmd.flags.StringVar(&cmd.deadline, "deadline", "", "mark overdue if not finished by then, like 2023-12-31 08:00:00")
+}
+
+func batchFlags(cmd *command) {
+	submitFlags(cmd)
+	cmd.flags.BoolVar(&cmd.allOrNothing, "all-or-nothing", false, "create nothing if any task is invalid")
+}
+
+func listFlags(cmd *command) {
+	cmd.flags.StringVar(&cmd.state, "state", "", "pending, running, done, error or cancelled")
+	cmd.flags.Var(&cmd.labels, "label", "only tasks with label key=value, repeatable")
+	cmd.flags.BoolVar(&cmd.overdue, "overdue", false, "only tasks marked overdue")
+	cmd.flags.IntVar(&cmd.limit, "limit", 50, "max tasks to list")
+	cmd.flags.IntVar(&cmd.offset, "offset", 0, "tasks to skip")
+}
+
+func editFlags(cmd *command) {
+	cmd.flags.StringVar(&cmd.file, "file", "", "new pipeline yaml")
+	cmd.flags.StringVar(&cmd.scheduledAt, "scheduled-at", "", "new schedule time, like 2023-12-31 00:00:00")
+	cmd.flags.Var(&cmd.concurrency, "concurrency", "replace concurrency groups with key=limit, repeatable")
+	cmd.flags.Var(&cmd.params, "param", "replace parameters with name=value, repeatable")
+	cmd.flags.Var(&cmd.labels, "label", "replace labels with key=value, repeatable")
+	cmd.flags.StringVar(&cmd.window, "window", "", "new run window as cron fields, - to run at any time")
+	cmd.flags.StringVar(&cmd.deadline, "deadline", "", "new deadline, like 2023-12-31 08:00:00, - for none")
+}
+
+func cancelFlags(cmd *command) {
+	cmd.flags.StringVar(&cmd.state, "state", "", "with -label, only pending or only running tasks")
+	cmd.flags.Var(&cmd.labels, "label", "instead of <id>, cancel all unfinished tasks with label key=value, repeatable")
+}
+
+func exportFlags(cmd *command) {
+	cmd.flags.StringVar(&cmd.state, "state", "", "only pending, running, done, error or cancelled tasks")
+	cmd.flags.Var(&cmd.labels, "label", "only tasks with label key=value, repeatable")
+	cmd.flags.StringVar(&cmd.outFile, "out", "", "write to the file instead of stdout")
+}
+
+// submitForm 把文件和调度参数写成multipart表单
+func (cmd *command) submitForm(path string) (*bytes.Buffer, string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return nil, "", err
+	}
+	part.Write(content)
+	if cmd.scheduledAt != "" {
+		form.WriteField("scheduled_at", cmd.scheduledAt)
+	}
+	for _, group := range cmd.concurrency {
+		form.WriteField("concurrency", group)
+	}
+	for _, param := range cmd.params {
+		form.WriteField("param", param)
+	}
+	for _, label := range cmd.labels {
+		form.WriteField("label", label)
+	}
+	if cmd.window != "" {
+		form.WriteField("window", cmd.window)
+	}
+	if cmd.deadline != "" {
+		form.WriteField("deadline", cmd.deadline)
+	}
+	if err := form.Close(); err != nil {
+		return nil, "", err
+	}
+	return body, form.FormDataContentType(), nil
+}
+
+// submitTask 上传任务描述
+func submitTask(cmd *command) error {
+	body, contentType, err := cmd.submitForm(cmd.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := cmd.cli.doJSON(http.MethodPost, "/tasks", contentType, body, &created); err != nil {
+		return err
+	}
+	if cmd.output == "json" {
+		return cmd.printJSON(created)
+	}
+	fmt.Fprintln(cmd.out, "submitted", created.ID)
+	return nil
+}
+
+// submitBatch 上传yaml文件的归档（zip、tar或tar.gz），一次创建多个任务
+func submitBatch(cmd *command) error {
+	body, contentType, err := cmd.submitForm(cmd.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var created struct {
+		IDs     []string `json:"ids"`
+		Results []struct {
+			Name   string `json:"name"`
+			ID     string `json:"id"`
+			Fields []struct {
+				Field   string `json:"field"`
+				Message string `json:"message"`
+			} `json:"fields"`
+		} `json:"results"`
+	}
+	path := "/tasks/batch?all_or_nothing=" + strconv.FormatBool(cmd.allOrNothing)
+	if err := cmd.cli.doJSON(http.MethodPost, path, contentType, body, &created); err != nil {
+		return err
+	}
+	if cmd.output == "json" {
+		return cmd.printJSON(created)
+	}
+
+	tw := tabwriter.NewWriter(cmd.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tID\tERROR")
+	for _, result := range created.Results {
+		problems := make([]string, 0, len(result.Fields))
+		for _, f := range result.Fields {
+			problems = append(problems, f.Field+" "+f.Message)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Name, result.ID, strings.Join(problems, "; "))
+	}
+	return tw.Flush()
+}
+
+// listTasks 列出任务
+func listTasks(cmd *command) error {
+	query := url.Values{}
+	if cmd.state != "" {
+		query.Set("state", cmd.state)
+	}
+	for _, label := range cmd.labels {
+		query.Add("label", label)
+	}
+	if cmd.overdue {
+		query.Set("overdue", "true")
+	}
+	query.Set("limit", strconv.Itoa(cmd.limit))
+	query.Set("offset", strconv.Itoa(cmd.offset))
+
+	var infos []common.TaskInfo
+	if err := cmd.cli.doJSON(http.MethodGet, "/tasks?"+query.Encode(), "", nil, &infos); err != nil {
+		return err
+	}
+	if cmd.output == "json" {
+		return cmd.printJSON(infos)
+	}
+
+	tw := tabwriter.NewWriter(cmd.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAMESPACE\tSTATE\tSCHEDULED_AT\tPERFORMED_AT\tFINISHED_AT\tLABELS\tERROR")
+	for _, info := range infos {
+		state := info.State
+		if info.OverdueAt != nil {
+			state += " (overdue)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", info.ID, info.Namespace, state,
+			formatTime(info.ScheduledAt), formatTime(info.PerformedAt), formatTime(info.FinishedAt),
+			formatPairs(info.Labels, ","), truncate(info.Error, 60))
+	}
+	return tw.Flush()
+}
+
+// statusTask 查看任务状态
+func statusTask(cmd *command) error {
+	var info common.TaskInfo
+	if err := cmd.cli.doJSON(http.MethodGet, "/tasks/"+url.PathEscape(cmd.flags.Arg(0))+"/status", "", nil, &info); err != nil {
+		return err
+	}
+	if cmd.output == "json" {
+		return cmd.printJSON(info)
+	}
+
+	tw := tabwriter.NewWriter(cmd.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "id:\t%s\n", info.ID)
+	fmt.Fprintf(tw, "namespace:\t%s\n", info.Namespace)
+	fmt.Fprintf(tw, "state:\t%s\n", info.State)
+	fmt.Fprintf(tw, "created_at:\t%s\n", formatTime(info.CreatedAt))
+	fmt.Fprintf(tw, "scheduled_at:\t%s\n", formatTime(info.ScheduledAt))
+	fmt.Fprintf(tw, "performed_at:\t%s\n", formatTime(info.PerformedAt))
+	fmt.Fprintf(tw, "finished_at:\t%s\n", formatTime(info.FinishedAt))
+	fmt.Fprintf(tw, "cancelled_at:\t%s\n", formatTime(info.CancelledAt))
+	if info.PerformedBy != "" {
+		fmt.Fprintf(tw, "performed_by:\t%s\n", info.PerformedBy)
+	}
+	if info.Window != "" {
+		fmt.Fprintf(tw, "window:\t%s\n", info.Window)
+	}
+	if info.Deadline != nil {
+		fmt.Fprintf(tw, "deadline:\t%s\n", formatTime(info.Deadline))
+	}
+	if info.OverdueAt != nil {
+		fmt.Fprintf(tw, "overdue_at:\t%s\n", formatTime(info.OverdueAt))
+	}
+	if info.Revision > 0 {
+		fmt.Fprintf(tw, "revision:\t%d\n", info.Revision)
+	}
+	if info.AnchorRevision != nil {
+		fmt.Fprintf(tw, "anchor_revision:\t%d\n", *info.AnchorRevision)
+	}
+	for _, group := range info.Concurrency {
+		fmt.Fprintf(tw, "concurrency:\t%s=%d\n", group.Key, group.Limit)
+	}
+	names := make([]string, 0, len(info.Params))
+	for name := range info.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(tw, "param:\t%s=%s\n", name, info.Params[name])
+	}
+	if len(info.Labels) > 0 {
+		fmt.Fprintf(tw, "labels:\t%s\n", formatPairs(info.Labels, " "))
+	}
+	if info.Error != "" {
+		fmt.Fprintf(tw, "error:\t%s\n", info.Error)
+	}
+	return tw.Flush()
+}
+
+// editTask 修改尚未开始的任务，只替换给出的选项
+func editTask(cmd *command) error {
+	patch := map[string]any{}
+	if cmd.file != "" {
+		content, err := os.ReadFile(cmd.file)
+		if err != nil {
+			return err
+		}
+		patch["description"] = string(content)
+	}
+	if cmd.scheduledAt != "" {
+		patch["scheduled_at"] = cmd.scheduledAt
+	}
+	if cmd.window == "-" {
+		patch["window"] = ""
+	} else if cmd.window != "" {
+		patch["window"] = cmd.window
+	}
+	if cmd.deadline == "-" {
+		patch["deadline"] = ""
+	} else if cmd.deadline != "" {
+		patch["deadline"] = cmd.deadline
+	}
+	if len(cmd.concurrency) > 0 {
+		groups := []common.ConcurrencyGroup{}
+		for _, value := range cmd.concurrency {
+			idx := strings.LastIndex(value, "=")
+			limit, err := strconv.Atoi(value[idx+1:])
+			if idx <= 0 || err != nil {
+				return fmt.Errorf("concurrency %q should be key=limit", value)
+			}
+			groups = append(groups, common.ConcurrencyGroup{Key: value[:idx], Limit: limit})
+		}
+		patch["concurrency"] = groups
+	}
+	for field, values := range map[string]multiFlag{"params": cmd.params, "labels": cmd.labels} {
+		if len(values) == 0 {
+			continue
+		}
+		pairs := map[string]string{}
+		for _, value := range values {
+			name, v, ok := strings.Cut(value, "=")
+			if !ok || name == "" {
+				return fmt.Errorf("%q should be name=value", value)
+			}
+			pairs[name] = v
+		}
+		patch[field] = pairs
+	}
+	if len(patch) == 0 {
+		cmd.flags.Usage()
+		return fmt.Errorf("nothing to change")
+	}
+
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	var info common.TaskInfo
+	path := "/tasks/" + url.PathEscape(cmd.flags.Arg(0))
+	if err := cmd.cli.doJSON(http.MethodPatch, path, "application/json", bytes.NewReader(body), &info); err != nil {
+		return err
+	}
+	if cmd.output == "json" {
+		return cmd.printJSON(info)
+	}
+	fmt.Fprintf(cmd.out, "edited %s, revision %d\n", info.ID, info.Revision)
+	return nil
+}
+
+// taskRevisions 列出任务被修改前的各个版本
+func taskRevisions(cmd *command) error {
+	var revisions []common.TaskRevision
+	if err := cmd.cli.doJSON(http.MethodGet, "/tasks/"+url.PathEscape(cmd.flags.Arg(0))+"/revisions", "", nil, &revisions); err != nil {
+		return err
+	}
+	if cmd.output == "json" {
+		return cmd.printJSON(revisions)
+	}
+
+	tw := tabwriter.NewWriter(cmd.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REVISION\tREPLACED_AT\tREPLACED_BY\tSCHEDULED_AT\tLABELS\tDESCRIPTION")
+	for _, rev := range revisions {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", rev.Revision, rev.ReplacedAt.Format(time.DateTime), rev.ReplacedBy,
+			formatTime(rev.ScheduledAt), formatPairs(rev.Labels, ","), truncate(rev.Description, 40))
+	}
+	return tw.Flush()
+}
+
+// cancelTask 取消任务，指定-label时取消带有这些标签的所有未结束任务
+func cancelTask(cmd *command) error {
+	if cmd.flags.NArg() == 0 {
+		if len(cmd.labels) == 0 {
+			cmd.flags.Usage()
+			return fmt.Errorf("either <id> or -label is required")
+		}
+		return cancelMatching(cmd)
+	}
+	if len(cmd.labels) > 0 {
+		return fmt.Errorf("<id> and -label can not be used together")
+	}
+
+	_, err := cmd.cli.do(http.MethodDelete, "/tasks/"+url.PathEscape(cmd.flags.Arg(0)), "", nil)
+	if err != nil {
+		return err
+	}
+	return cmd.done("cancelled")
+}
+
+// cancelMatching 按标签批量取消
+func cancelMatching(cmd *command) error {
+	query := url.Values{}
+	if cmd.state != "" {
+		query.Set("state", cmd.state)
+	}
+	for _, label := range cmd.labels {
+		query.Add("label", label)
+	}
+
+	var cancelled struct {
+		IDs []string `json:"ids"`
+	}
+	if err := cmd.cli.doJSON(http.MethodPost, "/tasks/cancel?"+query.Encode(), "", nil, &cancelled); err != nil {
+		return err
+	}
+	if cmd.output == "json" {
+		return cmd.printJSON(cancelled)
+	}
+	fmt.Fprintf(cmd.out, "cancelled %d tasks %s\n", len(cancelled.IDs), strings.Join(cancelled.IDs, " "))
+	return nil
+}
+
+// rerunTask 重新执行任务
+func rerunTask(cmd *command) error {
+	_, err := cmd.cli.do(http.MethodPost, "/tasks/"+url.PathEscape(cmd.flags.Arg(0))+"/rerun", "", nil)
+	if err != nil {
+		return err
+	}
+	return cmd.done("rerun")
+}
+
+// taskLogs 查看任务日志
+func taskLogs(cmd *command) error {
+	var lines []common.LogLine
+	if err := cmd.cli.doJSON(http.MethodGet, "/tasks/"+url.PathEscape(cmd.flags.Arg(0))+"/logs", "", nil, &lines); err != nil {
+		return err
+	}
+	if cmd.output == "json" {
+		return cmd.printJSON(lines)
+	}
+
+	for _, line := range lines {
+		fmt.Fprintf(cmd.out, "%s  %s\n", line.At.Format(time.DateTime), line.Message)
+	}
+	return nil
+}
+
+// exportTasks 导出任务为jsonl，每行一个任务的完整记录
+func exportTasks(cmd *command) error {
+	query := url.Values{}
+	if cmd.state != "" {
+		query.Set("state", cmd.state)
+	}
+	for _, label := range cmd.labels {
+		query.Add("label", label)
+	}
+
+	bytesArr, err := cmd.cli.do(http.MethodGet, "/tasks/export?"+query.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	if cmd.outFile == "" {
+		_, err = cmd.out.Write(bytesArr)
+		return err
+	}
+	if err := os.WriteFile(cmd.outFile, bytesArr, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d tasks to %s\n", bytes.Count(bytesArr, []byte("\n")), cmd.outFile)
+	return nil
+}
+
+// importChunk 每次请求导入的行数，与服务端一次批量提交的上限一致；每批在一个事务中导入
+const importChunk = 1000
+
+// importTasks 分批导入export导出的jsonl，任务分配新的id
+func importTasks(cmd *command) error {
+	content, err := os.ReadFile(cmd.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var lines [][]byte
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	var imported struct {
+		IDs []string `json:"ids"`
+	}
+	for start := 0; start < len(lines); start += importChunk {
+		end := start + importChunk
+		if end > len(lines) {
+			end = len(lines)
+		}
+		body := bytes.Join(lines[start:end], []byte("\n"))
+
+		var chunk struct {
+			IDs []string `json:"ids"`
+		}
+		if err := cmd.cli.doJSON(http.MethodPost, "/tasks/import", "application/x-ndjson", bytes.NewReader(body), &chunk); err != nil {
+			if start > 0 {
+				return fmt.Errorf("records %d-%d (%d imported before): %w", start+1, end, len(imported.IDs), err)
+			}
+			return fmt.Errorf("records %d-%d: %w", start+1, end, err)
+		}
+		imported.IDs = append(imported.IDs, chunk.IDs...)
+	}
+
+	if cmd.output == "json" {
+		return cmd.printJSON(imported)
+	}
+	fmt.Fprintf(cmd.out, "imported %d tasks %s\n", len(imported.IDs), strings.Join(imported.IDs, " "))
+	return nil
+}
+
+// done 输出没有响应体的操作结果
+func (cmd *command) done(action string) error {
+	if cmd.output == "json" {
+		return cmd.printJSON(map[string]string{"id": cmd.flags.Arg(0), "result": action})
+	}
+	fmt.Fprintln(cmd.out, action, cmd.flags.Arg(0))
+	return nil
+}
+
+func (cmd *command) printJSON(v any) error {
+	enc := json.NewEncoder(cmd.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.DateTime)
+}
+
+// formatPairs 按键排序写成key=value，以sep分隔
+func formatPairs(pairs map[string]string, sep string) string {
+	if len(pairs) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + pairs[k]
+	}
+	return strings.Join(keys, sep)
+}
+
+// truncate 保留前n个字符，按rune截断以免切开多字节的字符
+func truncate(str string, n int) string {
+	str = strings.ReplaceAll(str, "\n", " ")
+	runes := []rune(str)
+	if len(runes) <= n {
+		return str
+	}
+	return string(runes[:n]) + "..."
+}
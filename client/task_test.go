@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		str      string
+		n        int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"a longer error message", 8, "a longer..."},
+		{"line one\nline two", 20, "line one line two"},
+		{"数据回填任务失败了", 4, "数据回填..."},
+		{"表格store写入", 7, "表格store..."},
+	}
+	for _, c := range cases {
+		if got := truncate(c.str, c.n); got != c.expected {
+			t.Errorf("truncate(%q, %d) = %q, expected %q", c.str, c.n, got, c.expected)
+		}
+	}
+}
+
+// runCommand 以给定的参数执行子命令，返回输出
+func runCommand(t *testing.T, cfg config, args ...string) (string, error) {
+	t.Helper()
+	sub := taskCommands[args[0]]
+	out := &bytes.Buffer{}
+	cmd := &command{flags: flag.NewFlagSet(args[0], flag.ContinueOnError), out: out, output: "table"}
+	if sub.flags != nil {
+		sub.flags(cmd)
+	}
+	if err := cmd.flags.Parse(args[1:]); err != nil {
+		t.Fatal(err)
+	}
+	cmd.cli = newApiClient(cfg)
+	err := sub.run(cmd)
+	return out.String(), err
+}
+
+func TestSubmitListCancel(t *testing.T) {
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.Header.Get("Authorization") != "Bearer xxx" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/tasks":
+			r.ParseMultipartForm(1 << 20)
+			file, _, err := r.FormFile("file")
+			if err != nil || r.FormValue("label") != "team=数据" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]any{"error": "validation failed", "fields": []map[string]string{{"field": "file", "message": "is required"}}})
+				return
+			}
+			file.Close()
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"7"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/tasks":
+			json.NewEncoder(w).Encode([]common.TaskInfo{{ID: "7", Namespace: "team-a", State: common.StateError,
+				Labels: map[string]string{"team": "数据"}, Error: strings.Repeat("写入失败", 20)}})
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/tasks/7":
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+	cfg := config{Server: srv.URL, Token: "xxx", Namespace: "team-a"}
+
+	script := filepath.Join(t.TempDir(), "script.yml")
+	os.WriteFile(script, []byte("input: {}\n"), 0o644)
+
+	out, err := runCommand(t, cfg, "submit", "-label", "team=数据", script)
+	if err != nil || out != "submitted 7\n" {
+		t.Fatalf("submit should print the new id, got %q %v", out, err)
+	}
+	if ns := requests[0].URL.Query().Get("namespace"); ns != "team-a" {
+		t.Errorf("namespace from the config should be sent, got %q", ns)
+	}
+	if _, err := runCommand(t, cfg, "submit", script); err == nil || !strings.Contains(err.Error(), "file is required") {
+		t.Errorf("field errors should be reported, got %v", err)
+	}
+
+	out, err = runCommand(t, cfg, "list", "-state", "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := requests[2].URL.Query(); q.Get("state") != "error" || q.Get("limit") != "50" {
+		t.Errorf("filters should be sent, got %v", q)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "team=数据") || !strings.Contains(lines[1], strings.Repeat("写入失败", 15)+"...") {
+		t.Errorf("list should print a row per task with whole characters, got %q", out)
+	}
+
+	if out, err := runCommand(t, cfg, "cancel", "7"); err != nil || out != "cancelled 7\n" {
+		t.Errorf("cancel should succeed, got %q %v", out, err)
+	}
+	if _, err := runCommand(t, cfg, "cancel", "8"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing task should be an error, got %v", err)
+	}
+}
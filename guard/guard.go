@@ -0,0 +1,47 @@
+package guard
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+// CacheName 服务器模式下每个任务都会带上的缓存资源，插件的panic经它报告给任务
+const CacheName = "clams_guard"
+
+// PanicKey 报告panic时写入的键
+const PanicKey = "panic"
+
+// PanicError panic转成的错误，带上调用栈
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Recover 在插件的Process/WriteBatch中defer调用，把panic转为错误，服务器模式下还会让任务失败
+func Recover(ctx context.Context, mgr *service.Resources, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+	*err = panicErr
+	Report(ctx, mgr, panicErr)
+}
+
+// Report 把错误报告给任务，本地模式下什么都不做
+func Report(ctx context.Context, mgr *service.Resources, err error) {
+	if mgr == nil || !mgr.HasCache(CacheName) {
+		return
+	}
+	mgr.AccessCache(ctx, CacheName, func(c service.Cache) {
+		c.Set(ctx, PanicKey, []byte(err.Error()), nil)
+	})
+}
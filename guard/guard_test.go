@@ -0,0 +1,45 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+func process(mgr *service.Resources) (err error) {
+	defer Recover(context.Background(), mgr, &err)
+
+	var row any = "not a map"
+	_ = row.(map[string]any)
+	return nil
+}
+
+func TestRecover(t *testing.T) {
+	mgr := service.MockResources(service.MockResourcesOptAddCache(CacheName))
+
+	err := process(mgr)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("panic should become PanicError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "guard.process") {
+		t.Errorf("error should carry the stack, got %s", err)
+	}
+
+	var reported []byte
+	mgr.AccessCache(context.Background(), CacheName, func(c service.Cache) {
+		reported, _ = c.Get(context.Background(), PanicKey)
+	})
+	if string(reported) != err.Error() {
+		t.Errorf("panic should be reported to the task, got %q", reported)
+	}
+}
+
+func TestRecoverLocal(t *testing.T) {
+	if err := process(nil); err == nil {
+		t.Error("panic should become an error without a task")
+	}
+}
@@ -0,0 +1,84 @@
+package tablestorescanner
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/checkpoint"
+)
+
+// scanCheckpoint 并行扫描的进度
+type scanCheckpoint struct {
+	SessionId []byte `json:"session_id"`
+	Token     []byte `json:"token"`
+	Count     int    `json:"count"`
+	Finished  bool   `json:"finished"`
+}
+
+// scanPage 一页扫描结果，记录已确认的行数
+type scanPage struct {
+	rows       int
+	acked      int
+	checkpoint scanCheckpoint
+}
+
+// scanProgress 按页的顺序跟踪确认情况，连续确认完的页才保存为检查点
+type scanProgress struct {
+	lock  sync.Mutex
+	pages []*scanPage
+	mgr   *service.Resources
+	key   string
+}
+
+// load 读出检查点
+func (p *scanProgress) load(ctx context.Context) (*scanCheckpoint, error) {
+	value, err := checkpoint.Load(ctx, p.mgr, p.key)
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	var cp scanCheckpoint
+	if err := json.Unmarshal([]byte(value), &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// addPage 登记新的一页，空页在此直接推进
+func (p *scanProgress) addPage(ctx context.Context, rows int, cp scanCheckpoint) (*scanPage, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	page := &scanPage{rows: rows, checkpoint: cp}
+	p.pages = append(p.pages, page)
+	return page, p.advance(ctx)
+}
+
+// ack 确认一行
+func (p *scanProgress) ack(ctx context.Context, page *scanPage) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	page.acked += 1
+	return p.advance(ctx)
+}
+
+// advance 保存最后一个连续确认完的页
+func (p *scanProgress) advance(ctx context.Context) error {
+	var last *scanPage
+	for len(p.pages) > 0 && p.pages[0].acked >= p.pages[0].rows {
+		last = p.pages[0]
+		p.pages = p.pages[1:]
+	}
+	if last == nil {
+		return nil
+	}
+
+	bytesArr, err := json.Marshal(last.checkpoint)
+	if err != nil {
+		return err
+	}
+	return checkpoint.Save(ctx, p.mgr, p.key, string(bytesArr))
+}
@@ -0,0 +1,51 @@
+package tablestorescanner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/checkpoint"
+)
+
+func TestScanProgressSavesContiguousPages(t *testing.T) {
+	ctx := context.Background()
+	mgr := service.MockResources(service.MockResourcesOptAddCache(checkpoint.CacheName))
+	progress := &scanProgress{mgr: mgr, key: "ts"}
+
+	first, _ := progress.addPage(ctx, 2, scanCheckpoint{Token: []byte("a"), Count: 2})
+	second, _ := progress.addPage(ctx, 1, scanCheckpoint{Token: []byte("b"), Count: 3})
+
+	// 第二页先确认完，第一页未完时不保存
+	progress.ack(ctx, second)
+	progress.ack(ctx, first)
+	if cp, _ := progress.load(ctx); cp != nil {
+		t.Fatalf("checkpoint should wait for first page, got %+v", cp)
+	}
+
+	progress.ack(ctx, first)
+	cp, err := progress.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || string(cp.Token) != "b" || cp.Count != 3 {
+		t.Fatalf("checkpoint should be at second page, got %+v", cp)
+	}
+
+	// 空页直接推进
+	progress.addPage(ctx, 0, scanCheckpoint{Count: 3, Finished: true})
+	if cp, _ := progress.load(ctx); cp == nil || !cp.Finished {
+		t.Fatalf("empty last page should finish, got %+v", cp)
+	}
+}
+
+func TestScanProgressWithoutCheckpointCache(t *testing.T) {
+	progress := &scanProgress{mgr: service.MockResources(), key: "ts"}
+	page, err := progress.addPage(context.Background(), 1, scanCheckpoint{Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := progress.ack(context.Background(), page); err != nil {
+		t.Fatal(err)
+	}
+}
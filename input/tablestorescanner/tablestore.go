@@ -3,10 +3,12 @@ package tablestorescanner
 import (
 	"context"
 	"encoding/json"
+	"runtime/debug"
 
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
 	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/guard"
 )
 
 // 注册----------
@@ -28,9 +30,12 @@ var tablestoreConfigSpec = service.NewConfigSpec().
 		service.NewStringField("operator"),
 		service.NewAnyField("value")),
 	).
-	Field(service.NewIntField("limit").Default(0))
+	Field(service.NewIntField("limit").Default(0)).
+	Field(service.NewStringField("checkpoint_key").
+		Description("key of the checkpoint saved on acknowledgement in server mode").
+		Default("tablestorescanner"))
 
-func newTablestoreInput(conf *service.ParsedConfig) (service.Input, error) {
+func newTablestoreInput(conf *service.ParsedConfig, mgr *service.Resources) (service.Input, error) {
 	endPoint, err := conf.FieldString("end_point")
 	if err != nil {
 		return nil, err
@@ -71,6 +76,10 @@ func newTablestoreInput(conf *service.ParsedConfig) (service.Input, error) {
 	if err != nil {
 		return nil, err
 	}
+	checkpointKey, err := conf.FieldString("checkpoint_key")
+	if err != nil {
+		return nil, err
+	}
 
 	// filters
 	filters, err := conf.FieldObjectList("filters")
@@ -106,6 +115,7 @@ func newTablestoreInput(conf *service.ParsedConfig) (service.Input, error) {
 		lt:              lt,
 		limit:           limit,
 		filters:         filterArr,
+		progress:        &scanProgress{mgr: mgr, key: checkpointKey},
 	}), nil
 }
 
@@ -114,7 +124,7 @@ func init() {
 		"tablestorescanner",
 		tablestoreConfigSpec,
 		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Input, error) {
-			return newTablestoreInput(conf)
+			return newTablestoreInput(conf, mgr)
 		},
 	)
 	if err != nil {
@@ -140,6 +150,11 @@ type tablestoreInput struct {
 
 	client     *tablestore.TableStoreClient
 	batchRowGt *batchRowGetter
+	progress   *scanProgress
+
+	// scan 从token开始扫描一页，fullRows 按主键取整行；Connect时设置，测试时可替换
+	scan     func(sessionId []byte, token []byte) (*tablestore.ParallelScanResponse, error)
+	fullRows func(rows []*tablestore.Row) ([]map[string]any, error)
 
 	rowOrErr chan rowOrError
 }
@@ -151,8 +166,9 @@ type tablestoreInputFilter struct {
 }
 
 type rowOrError struct {
-	row map[string]any
-	err error
+	row  map[string]any
+	page *scanPage
+	err  error
 }
 
 func (ts *tablestoreInput) Connect(ctx context.Context) error {
@@ -164,14 +180,24 @@ func (ts *tablestoreInput) Connect(ctx context.Context) error {
 		columnsToGet: []string{},
 		columnsToDrp: []string{},
 	}
+	ts.scan = ts.parallelScan
+	ts.fullRows = ts.batchRowGt.getFullRows
 
-	computeSplitsResp, err := ts.sessionId()
+	// 有检查点时从检查点继续
+	cp, err := ts.progress.load(ctx)
 	if err != nil {
 		return err
 	}
+	if cp == nil {
+		computeSplitsResp, err := ts.sessionId()
+		if err != nil {
+			return err
+		}
+		cp = &scanCheckpoint{SessionId: computeSplitsResp.SessionId}
+	}
 
 	ts.rowOrErr = make(chan rowOrError)
-	go ts.startLoop(computeSplitsResp.SessionId)
+	go ts.startLoop(*cp)
 
 	return nil
 }
@@ -191,7 +217,10 @@ func (ts *tablestoreInput) Read(ctx context.Context) (*service.Message, service.
 	}
 
 	return service.NewMessage(bytes), func(ctx context.Context, err error) error {
-		return nil
+		if err != nil {
+			return nil
+		}
+		return ts.progress.ack(ctx, rowOrErr.page)
 	}, nil
 }
 
@@ -261,12 +290,14 @@ func (ts *tablestoreInput) makeFilter(column string, operator string, value any)
 	}
 }
 
-func (ts *tablestoreInput) startLoop(sessionId []byte) {
-	searchQueries := ts.makeFilters()
-
+// parallelScan 从token开始扫描一页
+func (ts *tablestoreInput) parallelScan(sessionId []byte, token []byte) (*tablestore.ParallelScanResponse, error) {
 	query := search.NewScanQuery().
-		SetQuery(&search.BoolQuery{MustQueries: searchQueries}).
+		SetQuery(&search.BoolQuery{MustQueries: ts.makeFilters()}).
 		SetLimit(1000)
+	if token != nil {
+		query.SetToken(token)
+	}
 
 	req := &tablestore.ParallelScanRequest{}
 	req.SetTableName(ts.table).
@@ -275,41 +306,71 @@ func (ts *tablestoreInput) startLoop(sessionId []byte) {
 		SetScanQuery(query).
 		SetTimeoutMs(30000).
 		SetSessionId(sessionId)
+	return ts.client.ParallelScan(req)
+}
 
-	batchRowGt := &batchRowGetter{
-		client:       ts.client,
-		tableName:    ts.table,
-		columnsToGet: []string{},
-		columnsToDrp: []string{},
+func (ts *tablestoreInput) startLoop(cp scanCheckpoint) {
+	defer close(ts.rowOrErr)
+
+	// 这个goroutine不在任务的recover之内，panic在这里转为错误
+	defer func() {
+		if r := recover(); r != nil {
+			ts.fail(&guard.PanicError{Value: r, Stack: debug.Stack()})
+		}
+	}()
+
+	if cp.Finished {
+		return
 	}
 
-	count := 0
+	count, token := cp.Count, cp.Token
 
-scanloop:
 	for {
-		resp, err := ts.client.ParallelScan(req)
+		resp, err := ts.scan(cp.SessionId, token)
 		if err != nil {
-			ts.rowOrErr <- rowOrError{nil, err}
+			ts.fail(err)
 			break
 		}
 
-		maps, err := batchRowGt.getFullRows(resp.Rows)
+		// 取不到整行时不登记这一页，检查点停在上一页，重跑时从这一页继续
+		maps, err := ts.fullRows(resp.Rows)
 		if err != nil {
-			ts.rowOrErr <- rowOrError{nil, err}
+			ts.fail(err)
+			break
 		}
+
+		// 达到limit时只发出所需的行
+		finished := resp.NextToken == nil
+		if ts.limit != 0 && count+len(maps) >= ts.limit {
+			maps = maps[:ts.limit-count]
+			finished = true
+		}
+		count += len(maps)
+
+		page, err := ts.progress.addPage(context.Background(), len(maps), scanCheckpoint{
+			SessionId: cp.SessionId,
+			Token:     resp.NextToken,
+			Count:     count,
+			Finished:  finished,
+		})
+		if err != nil {
+			ts.fail(err)
+			break
+		}
+
 		for _, m := range maps {
-			ts.rowOrErr <- rowOrError{m, nil}
-			count += 1
-			if ts.limit != 0 && count >= ts.limit {
-				break scanloop
-			}
+			ts.rowOrErr <- rowOrError{m, page, nil}
 		}
 
-		if resp.NextToken == nil {
+		if finished {
 			break
 		}
-		req.SetScanQuery(query.SetToken(resp.NextToken))
+		token = resp.NextToken
 	}
+}
 
-	close(ts.rowOrErr)
+// fail 发出错误并报告给任务，服务器模式下任务因此失败，而不是当作已经读完
+func (ts *tablestoreInput) fail(err error) {
+	guard.Report(context.Background(), ts.progress.mgr, err)
+	ts.rowOrErr <- rowOrError{nil, nil, err}
 }
@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
 	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/checkpoint"
+	"github.com/turnon/clams/guard"
 )
 
 func TestTablestoreInputut(t *testing.T) {
@@ -20,7 +24,7 @@ func TestTablestoreInputut(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	tsInput, err := newTablestoreInput(tsConf)
+	tsInput, err := newTablestoreInput(tsConf, service.MockResources())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -56,3 +60,112 @@ func TestTablestoreInputut(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// fakeRows 主键为uuid的行
+func fakeRows(uuids ...string) []*tablestore.Row {
+	rows := make([]*tablestore.Row, 0, len(uuids))
+	for _, uuid := range uuids {
+		rows = append(rows, &tablestore.Row{PrimaryKey: &tablestore.PrimaryKey{
+			PrimaryKeys: []*tablestore.PrimaryKeyColumn{{ColumnName: "uuid", Value: uuid}},
+		}})
+	}
+	return rows
+}
+
+// fakeInput 扫描两页：a、b 和 c，取第二页整行时按failing返回错误
+func fakeInput(mgr *service.Resources, failing func() error) *tablestoreInput {
+	ts := &tablestoreInput{
+		rowOrErr: make(chan rowOrError),
+		progress: &scanProgress{mgr: mgr, key: "ts"},
+	}
+	ts.scan = func(sessionId, token []byte) (*tablestore.ParallelScanResponse, error) {
+		if token == nil {
+			return &tablestore.ParallelScanResponse{Rows: fakeRows("a", "b"), NextToken: []byte("p2")}, nil
+		}
+		return &tablestore.ParallelScanResponse{Rows: fakeRows("c")}, nil
+	}
+	ts.fullRows = func(rows []*tablestore.Row) ([]map[string]any, error) {
+		maps := make([]map[string]any, 0, len(rows))
+		for _, row := range rows {
+			uuid := row.PrimaryKey.PrimaryKeys[0].Value.(string)
+			if uuid == "c" {
+				if err := failing(); err != nil {
+					return nil, err
+				}
+			}
+			maps = append(maps, map[string]any{"uuid": uuid})
+		}
+		return maps, nil
+	}
+	return ts
+}
+
+// drain 读完所有行并逐行确认，返回读到的uuid和出现的错误
+func drain(t *testing.T, ts *tablestoreInput, cp scanCheckpoint) ([]string, error) {
+	go ts.startLoop(cp)
+
+	var uuids []string
+	var readErr error
+	for {
+		msg, ack, err := ts.Read(context.Background())
+		if errors.Is(err, service.ErrEndOfInput) {
+			return uuids, readErr
+		}
+		if err != nil {
+			readErr = err
+			continue
+		}
+		structed, err := msg.AsStructured()
+		if err != nil {
+			t.Fatal(err)
+		}
+		uuids = append(uuids, structed.(map[string]any)["uuid"].(string))
+		if err := ack(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResumeAfterFullRowsFailed(t *testing.T) {
+	ctx := context.Background()
+	mgr := service.MockResources(service.MockResourcesOptAddCache(checkpoint.CacheName))
+	fetchErr := errors.New("get rows failed")
+
+	uuids, err := drain(t, fakeInput(mgr, func() error { return fetchErr }), scanCheckpoint{})
+	if !errors.Is(err, fetchErr) || len(uuids) != 2 {
+		t.Fatalf("first scan should stop at the failed page, got %v %v", uuids, err)
+	}
+	cp, err := (&scanProgress{mgr: mgr, key: "ts"}).load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || string(cp.Token) != "p2" || cp.Count != 2 || cp.Finished {
+		t.Fatalf("checkpoint should stay before the failed page, got %+v", cp)
+	}
+
+	uuids, err = drain(t, fakeInput(mgr, func() error { return nil }), *cp)
+	if err != nil || len(uuids) != 1 || uuids[0] != "c" {
+		t.Fatalf("resumed scan should return the failed page, got %v %v", uuids, err)
+	}
+	if cp, _ := (&scanProgress{mgr: mgr, key: "ts"}).load(ctx); cp == nil || !cp.Finished || cp.Count != 3 {
+		t.Errorf("checkpoint should be finished after resuming, got %+v", cp)
+	}
+}
+
+func TestScanLoopPanic(t *testing.T) {
+	mgr := service.MockResources(service.MockResourcesOptAddCache(checkpoint.CacheName), service.MockResourcesOptAddCache(guard.CacheName))
+
+	uuids, err := drain(t, fakeInput(mgr, func() error { panic("bad row") }), scanCheckpoint{})
+	var panicErr *guard.PanicError
+	if !errors.As(err, &panicErr) || len(uuids) != 2 {
+		t.Fatalf("panic in the scan loop should become an error, got %v %v", uuids, err)
+	}
+
+	var reported []byte
+	mgr.AccessCache(context.Background(), guard.CacheName, func(c service.Cache) {
+		reported, _ = c.Get(context.Background(), guard.PanicKey)
+	})
+	if !strings.Contains(string(reported), "bad row") {
+		t.Errorf("panic should be reported to the task, got %q", reported)
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/benthosdev/benthos/v4/public/service"
 	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/secret"
 	"github.com/turnon/clams/util"
 )
 
@@ -20,19 +21,29 @@ func Run(anchors string, path string) {
 		logFatal(err)
 	}
 
-	builder := service.NewStreamBuilder()
+	secrets, err := secret.NewResolver(secret.Config{})
+	if err != nil {
+		logFatal(err)
+	}
 
-	if err = builder.SetYAML(ymlStr); err != nil {
+	resolved, err := secrets.Resolve(ymlStr, "")
+	if err != nil {
 		logFatal(err)
 	}
 
+	builder := service.NewStreamBuilder()
+
+	if err = builder.SetYAML(resolved.Text); err != nil {
+		logFatal(resolved.ScrubErr(err))
+	}
+
 	stream, err := builder.Build()
 	if err != nil {
-		logFatal(err)
+		logFatal(resolved.ScrubErr(err))
 	}
 
 	if err = stream.Run(context.Background()); err != nil {
-		logFatal(err)
+		logFatal(resolved.ScrubErr(err))
 	}
 }
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 
 	_ "github.com/benthosdev/benthos/v4/public/components/io"
@@ -10,18 +11,27 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/turnon/clams/client"
 	_ "github.com/turnon/clams/input"
 	"github.com/turnon/clams/local"
 	_ "github.com/turnon/clams/output"
 	_ "github.com/turnon/clams/processor"
+	"github.com/turnon/clams/secret"
 	"github.com/turnon/clams/server"
 )
 
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "task" || os.Args[1] == "tasks") {
+		os.Exit(client.RunTask(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == server.IsolatedTaskCommand {
+		os.Exit(server.RunIsolatedTask(os.Args[2:]))
+	}
 
 	serverCfgFile := flag.String("server", "", "server config")
 	localCfgFile := flag.String("local", "", "run locally")
 	ymlAnchor := flag.String("anchor", "", "anchor definition")
+	sealSecrets := flag.String("seal-secrets", "", "encrypt a yaml of secrets with key in CLAMS_SECRET_KEY")
 	debug := flag.Bool("debug", false, "make log level DEBUG")
 	flag.Parse()
 
@@ -31,13 +41,22 @@ func main() {
 	}
 	log.Debug().Int("pid", os.Getpid()).Send()
 
-	anchors := loadAnchors(*ymlAnchor)
+	if *sealSecrets != "" {
+		sealed, err := secret.SealFile("", *sealSecrets)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		fmt.Println(sealed)
+		return
+	}
 
 	if *serverCfgFile != "" {
-		server.Run(anchors, *serverCfgFile)
+		server.Run(*ymlAnchor, *serverCfgFile)
 		return
 	}
 
+	anchors := loadAnchors(*ymlAnchor)
+
 	if *localCfgFile != "" {
 		local.Run(anchors, *localCfgFile)
 		return
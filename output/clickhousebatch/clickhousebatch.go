@@ -11,6 +11,7 @@ import (
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/guard"
 	"github.com/turnon/clams/util"
 )
 
@@ -66,6 +67,7 @@ func init() {
 			ckb := &clickhousebatch{
 				connect: ckbConnect,
 				table:   ckbTable,
+				mgr:     mgr,
 			}
 			return ckb, batchPolicy, batchPolicy.Count, nil
 		},
@@ -155,6 +157,7 @@ type clickhousebatch struct {
 	table   clickhousebatchTable
 	conn    driver.Conn
 	lock    sync.Mutex
+	mgr     *service.Resources
 }
 
 type clickhousebatchConnect struct {
@@ -266,7 +269,9 @@ func (ckb *clickhousebatch) createTable(ctx context.Context) error {
 	return nil
 }
 
-func (ckb *clickhousebatch) WriteBatch(ctx context.Context, msgs service.MessageBatch) error {
+func (ckb *clickhousebatch) WriteBatch(ctx context.Context, msgs service.MessageBatch) (err error) {
+	defer guard.Recover(ctx, ckb.mgr, &err)
+
 	ckb.lock.Lock()
 	defer func() {
 		ckb.lock.Unlock()
@@ -12,6 +12,7 @@ import (
 	"github.com/benthosdev/benthos/v4/public/service"
 	"github.com/go-sql-driver/mysql"
 	"github.com/rs/xid"
+	"github.com/turnon/clams/guard"
 )
 
 func init() {
@@ -40,7 +41,7 @@ func init() {
 				return nil, bp, 0, err
 			}
 
-			bo, err := newMysqlloaddata(conf)
+			bo, err := newMysqlloaddata(conf, mgr)
 			if err != nil {
 				return nil, bp, 0, err
 			}
@@ -53,7 +54,7 @@ func init() {
 	}
 }
 
-func newMysqlloaddata(conf *service.ParsedConfig) (service.BatchOutput, error) {
+func newMysqlloaddata(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchOutput, error) {
 	connect, err := newMysqlloaddataConnect(conf)
 	if err != nil {
 		return nil, err
@@ -78,6 +79,7 @@ func newMysqlloaddata(conf *service.ParsedConfig) (service.BatchOutput, error) {
 		table:    table,
 		fromCols: fromCols,
 		toCols:   toCols,
+		mgr:      mgr,
 	}, nil
 }
 
@@ -134,6 +136,7 @@ type mysqlloaddata struct {
 	connect mysqlloaddataConnect
 	db      *sql.DB
 	lock    sync.Mutex
+	mgr     *service.Resources
 
 	table         string
 	localFilePath string
@@ -181,7 +184,9 @@ func (loaddata *mysqlloaddata) Connect(ctx context.Context) error {
 	return nil
 }
 
-func (loaddata *mysqlloaddata) WriteBatch(ctx context.Context, msgs service.MessageBatch) error {
+func (loaddata *mysqlloaddata) WriteBatch(ctx context.Context, msgs service.MessageBatch) (err error) {
+	defer guard.Recover(ctx, loaddata.mgr, &err)
+
 	loaddata.lock.Lock()
 	defer loaddata.lock.Unlock()
 
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/guard"
 )
 
 func init() {
@@ -18,7 +19,7 @@ func init() {
 			if err != nil {
 				return nil, 1, err
 			}
-			return &stdoutvertical{meta: meta}, 1, nil
+			return &stdoutvertical{meta: meta, mgr: mgr}, 1, nil
 		},
 	)
 	if err != nil {
@@ -31,13 +32,16 @@ func init() {
 type stdoutvertical struct {
 	count uint64
 	meta  bool
+	mgr   *service.Resources
 }
 
 func (stdver *stdoutvertical) Connect(ctx context.Context) error {
 	return nil
 }
 
-func (stdver *stdoutvertical) Write(ctx context.Context, msg *service.Message) error {
+func (stdver *stdoutvertical) Write(ctx context.Context, msg *service.Message) (err error) {
+	defer guard.Recover(ctx, stdver.mgr, &err)
+
 	structed, err := msg.AsStructured()
 	if err != nil {
 		return err
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/guard"
 )
 
 func init() {
@@ -34,7 +35,7 @@ func init() {
 			}
 			jsonField2colArr = append(jsonField2colArr, jsonField2col{name: name, keep: keep})
 		}
-		return &json2cols{fields: jsonField2colArr}, nil
+		return &json2cols{fields: jsonField2colArr, mgr: mgr}, nil
 	}
 
 	err := service.RegisterProcessor("json2cols", configSpec, constructor)
@@ -47,6 +48,7 @@ func init() {
 
 type json2cols struct {
 	fields []jsonField2col
+	mgr    *service.Resources
 }
 
 type jsonField2col struct {
@@ -54,7 +56,9 @@ type jsonField2col struct {
 	keep bool
 }
 
-func (js2cols *json2cols) Process(ctx context.Context, msg *service.Message) (service.MessageBatch, error) {
+func (js2cols *json2cols) Process(ctx context.Context, msg *service.Message) (batch service.MessageBatch, err error) {
+	defer guard.Recover(ctx, js2cols.mgr, &err)
+
 	msgAsMap, err := js2cols.msgToMap(msg)
 	if err != nil {
 		return nil, err
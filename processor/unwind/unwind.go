@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 
 	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/guard"
 )
 
 func init() {
@@ -16,7 +17,7 @@ func init() {
 		if err != nil {
 			return nil, err
 		}
-		return &unwindProcessor{field: field}, nil
+		return &unwindProcessor{field: field, mgr: mgr}, nil
 	}
 
 	err := service.RegisterProcessor("unwind", configSpec, constructor)
@@ -29,9 +30,12 @@ func init() {
 
 type unwindProcessor struct {
 	field string
+	mgr   *service.Resources
 }
 
-func (un *unwindProcessor) Process(ctx context.Context, msg *service.Message) (service.MessageBatch, error) {
+func (un *unwindProcessor) Process(ctx context.Context, msg *service.Message) (batch service.MessageBatch, err error) {
+	defer guard.Recover(ctx, un.mgr, &err)
+
 	structed, err := msg.AsStructured()
 	if err != nil {
 		return nil, err
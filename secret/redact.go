@@ -0,0 +1,25 @@
+package secret
+
+import "regexp"
+
+const redacted = "******"
+
+var (
+	// credentialPattern 匹配键名以密码类词结尾的yaml或json字段，键可在行首，也可在{、逗号或空白之后，access_key_id之类的标识不算，值为引用时不抹去
+	credentialPattern = regexp.MustCompile(`(?im)((?:^|[{,\s])\s*-?\s*"?[\w.-]*(?:password|passwd|pwd|secret|token|access_key|api_key|apikey|credential|private_key)s?"?\s*[:=]\s*)(\$\{secret:[^}\n]*\}|"[^"\n]*"|'[^'\n]*'|[^\s#,}\n][^#,}\n]*)`)
+
+	// dsnPattern 匹配url中的用户密码
+	dsnPattern = regexp.MustCompile(`(://[^:/@\s]+:)[^@\s]+@`)
+)
+
+// Redact 抹去文本中看起来像凭证的内容
+func Redact(text string) string {
+	text = credentialPattern.ReplaceAllStringFunc(text, func(line string) string {
+		groups := credentialPattern.FindStringSubmatch(line)
+		if refPattern.MatchString(groups[2]) {
+			return line
+		}
+		return groups[1] + redacted
+	})
+	return dsnPattern.ReplaceAllString(text, "${1}"+redacted+"@")
+}
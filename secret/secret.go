@@ -0,0 +1,241 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NamespacePattern 命名空间名，只含小写字母、数字和夹在中间的单个-，
+// 保证 <namespace>/<name> 对应的环境变量名不会与其他命名空间的或不带前缀的密钥重合
+const NamespacePattern = `[a-z0-9]+(?:-[a-z0-9]+)*`
+
+// refPattern 匹配 ${secret:name} 和命名空间的 ${secret:namespace/name}
+var refPattern = regexp.MustCompile(`\$\{secret:((?:` + NamespacePattern + `/)?[A-Za-z0-9_.-]+)\}`)
+
+// separatorPattern 连续的 _ . -，在环境变量名中会变成命名空间的分隔符__
+var separatorPattern = regexp.MustCompile(`[_.-]{2}`)
+
+// Config 密钥来源配置，按环境变量、目录、加密文件的顺序查找
+type Config struct {
+	EnvPrefix string `yaml:"env_prefix"`
+	Dir       string `yaml:"dir"`
+	Store     string `yaml:"store"`
+	KeyEnv    string `yaml:"key_env"`
+}
+
+// Resolver 解析任务描述中的密钥引用
+type Resolver struct {
+	envPrefix string
+	keyEnv    string
+	dir       string
+	store     map[string]string
+}
+
+// NewResolver 创建Resolver，配置了加密文件时会立即解密
+func NewResolver(cfg Config) (*Resolver, error) {
+	r := &Resolver{envPrefix: cfg.EnvPrefix, keyEnv: cfg.KeyEnv, dir: cfg.Dir}
+	if r.envPrefix == "" {
+		r.envPrefix = "CLAMS_SECRET_"
+	}
+	if r.keyEnv == "" {
+		r.keyEnv = "CLAMS_SECRET_KEY"
+	}
+
+	if cfg.Store != "" {
+		key, err := loadKey(r.keyEnv)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := os.ReadFile(cfg.Store)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := Open(key, string(sealed))
+		if err != nil {
+			return nil, fmt.Errorf("open secret store %s: %w", cfg.Store, err)
+		}
+		if err := yaml.Unmarshal(plain, &r.store); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Resolved 解析后的描述，记住了替换进去的值以便从日志和错误中抹去
+type Resolved struct {
+	Text      string
+	values    []string
+	secretEnv func(name string) bool
+}
+
+// Foreign 描述中引用的不属于该命名空间的密钥；namespace为空时只能引用不带前缀的密钥，
+// 否则只能引用 <namespace>/ 开头的密钥；名字中有连续的 _ . - 的不带前缀的密钥可能冒用命名空间的环境变量，也算在内
+func Foreign(desc, namespace string) []string {
+	var foreign []string
+	for _, match := range refPattern.FindAllStringSubmatch(desc, -1) {
+		name := match[1]
+		owner, _, scoped := strings.Cut(name, "/")
+		if scoped && owner == namespace || !scoped && namespace == "" && !separatorPattern.MatchString(name) {
+			continue
+		}
+		foreign = append(foreign, name)
+	}
+	return foreign
+}
+
+// Resolve 替换描述中所有的密钥引用，引用了其他命名空间的密钥时不替换
+func (r *Resolver) Resolve(desc, namespace string) (Resolved, error) {
+	resolved := Resolved{secretEnv: r.SecretEnv}
+	if foreign := Foreign(desc, namespace); len(foreign) > 0 {
+		return resolved, fmt.Errorf("secret of another namespace: %s", strings.Join(foreign, ", "))
+	}
+	var missing []string
+
+	resolved.Text = refPattern.ReplaceAllStringFunc(desc, func(ref string) string {
+		name := refPattern.FindStringSubmatch(ref)[1]
+		value, ok := r.Lookup(name)
+		if !ok {
+			missing = append(missing, name)
+			return ref
+		}
+		if value != "" {
+			resolved.values = append(resolved.values, value)
+		}
+		return value
+	})
+
+	if len(missing) > 0 {
+		return resolved, fmt.Errorf("secret not found: %s", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// Lookup 查找密钥，命名空间的密钥在dir下位于该命名空间的子目录中
+func (r *Resolver) Lookup(name string) (string, bool) {
+	if value, ok := os.LookupEnv(r.envName(name)); ok {
+		return value, true
+	}
+
+	if r.dir != "" {
+		if bytesArr, err := os.ReadFile(filepath.Join(r.dir, name)); err == nil {
+			return strings.TrimRight(string(bytesArr), "\r\n"), true
+		}
+	}
+
+	value, ok := r.store[name]
+	return value, ok
+}
+
+// SecretEnv 环境变量是否保存着密钥或解密用的主密钥
+func (r *Resolver) SecretEnv(name string) bool {
+	return strings.HasPrefix(name, r.envPrefix) || name == r.keyEnv
+}
+
+// envName 密钥对应的环境变量名，命名空间和名字之间为__
+func (r *Resolver) envName(name string) string {
+	upper := strings.ToUpper(name)
+	upper = strings.NewReplacer("/", "__", ".", "_", "-", "_").Replace(upper)
+	return r.envPrefix + upper
+}
+
+// LookupEnv 读取环境变量，保存密钥的环境变量对任务不可见
+func (resolved Resolved) LookupEnv(name string) (string, bool) {
+	if resolved.secretEnv != nil && resolved.secretEnv(name) {
+		return "", false
+	}
+	return os.LookupEnv(name)
+}
+
+// Scrub 抹去文本中出现的密钥值
+func (resolved Resolved) Scrub(text string) string {
+	for _, value := range resolved.values {
+		text = strings.ReplaceAll(text, value, redacted)
+	}
+	return Redact(text)
+}
+
+// loadKey 从环境变量读取base64编码的256位密钥
+func loadKey(keyEnv string) ([]byte, error) {
+	if keyEnv == "" {
+		keyEnv = "CLAMS_SECRET_KEY"
+	}
+	encoded := os.Getenv(keyEnv)
+	if encoded == "" {
+		return nil, errors.New("secret key not set in " + keyEnv)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// Seal 用AES-GCM加密，结果为base64编码
+func Seal(key []byte, plain []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open 解密Seal的结果
+func Open(key []byte, sealed string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesArr, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sealed))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytesArr) < gcm.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	nonce, data := bytesArr[:gcm.NonceSize()], bytesArr[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealFile 用环境变量中的密钥加密文件，供 -seal-secrets 使用
+func SealFile(keyEnv string, path string) (string, error) {
+	key, err := loadKey(keyEnv)
+	if err != nil {
+		return "", err
+	}
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var check map[string]string
+	if err := yaml.Unmarshal(plain, &check); err != nil {
+		return "", err
+	}
+	return Seal(key, plain)
+}
+
+// ScrubErr 抹去错误信息中的密钥值
+func (resolved Resolved) ScrubErr(err error) error {
+	return errors.New(resolved.Scrub(err.Error()))
+}
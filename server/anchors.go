@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/turnon/clams/tasklist/common"
+	"gopkg.in/yaml.v3"
+)
+
+// anchorSet 一个命名空间中文件的锚点与任务列表中的锚点的合集，由namespaces随任务列表的变化而更新
+type anchorSet struct {
+	namespace string
+	tasks     common.Tasklist
+
+	lock     sync.RWMutex
+	base     string
+	composed string
+	revision int
+}
+
+// current 返回合并后的锚点和版本
+func (set *anchorSet) current() (string, int) {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+	return set.composed, set.revision
+}
+
+// reload 从任务列表重新加载锚点
+func (set *anchorSet) reload(ctx context.Context) error {
+	set.lock.RLock()
+	base := set.base
+	set.lock.RUnlock()
+
+	return set.compose(ctx, base)
+}
+
+// setBase 替换文件中的锚点
+func (set *anchorSet) setBase(ctx context.Context, base string) error {
+	return set.compose(ctx, base)
+}
+
+// compose 合并文件锚点和任务列表中的锚点
+func (set *anchorSet) compose(ctx context.Context, base string) error {
+	anchors, err := set.tasks.ListAnchors(ctx, set.namespace)
+	if err != nil {
+		return err
+	}
+
+	composed, err := composeAnchors(base, anchors.Anchors)
+	if err != nil {
+		return err
+	}
+
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.base = base
+	set.composed = composed
+	set.revision = anchors.Revision
+	return nil
+}
+
+// with 返回加上（或替换）某个锚点后的合集，用于校验
+func (set *anchorSet) with(ctx context.Context, anchor common.Anchor) (string, error) {
+	anchors, err := set.tasks.ListAnchors(ctx, set.namespace)
+	if err != nil {
+		return "", err
+	}
+
+	merged := make([]common.Anchor, 0, len(anchors.Anchors)+1)
+	for _, a := range anchors.Anchors {
+		if a.Name != anchor.Name {
+			merged = append(merged, a)
+		}
+	}
+	merged = append(merged, anchor)
+
+	set.lock.RLock()
+	base := set.base
+	set.lock.RUnlock()
+
+	return composeAnchors(base, merged)
+}
+
+// composeAnchors 将任务列表中的锚点追加到文件锚点之后，同名的以任务列表为准
+func composeAnchors(base string, anchors []common.Anchor) (string, error) {
+	if len(anchors) == 0 {
+		return base, nil
+	}
+
+	names := make(map[string]struct{}, len(anchors))
+	for _, anchor := range anchors {
+		names[anchor.Name] = struct{}{}
+	}
+
+	sb := strings.Builder{}
+
+	if strings.TrimSpace(base) != "" {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(base), &doc); err != nil {
+			return "", err
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return "", errors.New("anchors should be a mapping")
+		}
+		root := doc.Content[0]
+		kept := make([]*yaml.Node, 0, len(root.Content))
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if _, overridden := names[root.Content[i].Value]; overridden {
+				continue
+			}
+			kept = append(kept, root.Content[i], root.Content[i+1])
+		}
+		root.Content = kept
+
+		if len(kept) > 0 {
+			bytesArr, err := yaml.Marshal(&doc)
+			if err != nil {
+				return "", err
+			}
+			sb.Write(bytesArr)
+		}
+	}
+
+	for _, anchor := range anchors {
+		sb.WriteString(anchor.Name)
+		sb.WriteString(": &")
+		sb.WriteString(anchor.Name)
+		sb.WriteString("\n")
+		for _, line := range strings.Split(strings.TrimRight(anchor.Content, "\n"), "\n") {
+			sb.WriteString("    ")
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+	}
+
+	composed := sb.String()
+	var check map[string]any
+	if err := yaml.Unmarshal([]byte(composed), &check); err != nil {
+		return "", err
+	}
+	return composed, nil
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/turnon/clams/tasklist/common"
+	"github.com/turnon/clams/util"
+)
+
+func TestComposeAnchorsOverride(t *testing.T) {
+	base := "a: &a\n    x: 1\nb: &b\n    y: 2\n"
+	anchors := []common.Anchor{{Name: "b", Content: "y: 3\n"}}
+
+	composed, err := composeAnchors(base, anchors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc, err := util.InterpolateYamlAnchor(composed, "out:\n    <<: [*a, *b]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc != "out:\n    x: 1\n    \"y\": 3\n" {
+		t.Fatalf("unexpected description: %q", desc)
+	}
+}
+
+func TestComposeAnchorsWithoutBase(t *testing.T) {
+	composed, err := composeAnchors("", []common.Anchor{{Name: "c", Content: "z: 1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if composed != "c: &c\n    z: 1\n" {
+		t.Fatalf("unexpected anchors: %q", composed)
+	}
+}
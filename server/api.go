@@ -2,27 +2,34 @@ package server
 
 import (
 	"context"
-	"io"
+	"errors"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/secret"
 	"github.com/turnon/clams/tasklist/common"
 )
 
 const mod = "api"
 
 type ApplicationInterface struct {
-	port  int
-	ch    chan struct{}
-	ctx   context.Context
-	tasks common.Tasklist
+	port       int
+	ch         chan struct{}
+	ctx        context.Context
+	tasks      common.Tasklist
+	namespaces *namespaces
+	team       *workteam
+
+	lock   sync.RWMutex
+	tokens map[string]string
 }
 
-func newApi(ctx context.Context, port int, tasks common.Tasklist) *ApplicationInterface {
-	api := &ApplicationInterface{ctx: ctx, port: port, tasks: tasks}
+func newApi(ctx context.Context, port int, tokens map[string]string, tasks common.Tasklist, nss *namespaces, team *workteam) *ApplicationInterface {
+	api := &ApplicationInterface{ctx: ctx, port: port, tokens: tokens, tasks: tasks, namespaces: nss, team: team}
 	api.start()
 	return api
 }
@@ -32,6 +39,13 @@ func (api *ApplicationInterface) wait() chan struct{} {
 	return api.ch
 }
 
+// setTokens 替换tokens
+func (api *ApplicationInterface) setTokens(tokens map[string]string) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	api.tokens = tokens
+}
+
 // logErr 输出日志
 func (api *ApplicationInterface) logErr(err error) {
 	log.Error().Str("mod", "api").Err(err).Send()
@@ -49,13 +63,21 @@ func (api *ApplicationInterface) start() {
 	router.Use(requestLogger())
 	router.Use(gin.Recovery())
 
+	router.GET("/api/v1/openapi.json", api.getOpenapi)
+	router.GET("/healthz", api.getHealthz)
+	router.GET("/readyz", api.getReadyz)
+	router.GET("/metrics", api.authenticate(), api.getMetrics)
+
 	path := router.Group("api")
+	path.Use(api.authenticate())
 
 	v1 := path.Group("/v1")
-	{
-		v1.POST("/tasks", api.postTasks)
-		v1.DELETE("/tasks/:id", api.deleteTasks)
-		v1.GET("/tasks/:id", api.getTasks)
+	for _, r := range api.routes() {
+		if r.audit != "" {
+			v1.Handle(r.method, r.path, api.audit(r.audit), r.handler)
+			continue
+		}
+		v1.Handle(r.method, r.path, r.handler)
 	}
 
 	httpSrv := &http.Server{
@@ -85,32 +107,42 @@ func (api *ApplicationInterface) start() {
 
 // postTasks 新建任务
 func (api *ApplicationInterface) postTasks(c *gin.Context) {
-	fileHeader, _ := c.FormFile("file")
-	file, _ := fileHeader.Open()
-	bytesArr, _ := io.ReadAll(file)
-	rawTask := common.RawTask{
-		Description: string(bytesArr),
-		ScheduledAt: c.PostForm("scheduled_at"),
+	var ns *namespace
+	req, err := bindTaskRequest(c)
+	if err == nil {
+		setAuditSummary(c, taskSummary(req))
+		err = req.validate()
+	}
+	if err == nil {
+		ns, err = api.target(c)
+	}
+	if err == nil {
+		err = ns.apply(&req)
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
 	}
 
-	err := api.tasks.Write(c.Request.Context(), rawTask)
+	rawTask := req.rawTask()
+	rawTask.Namespace = ns.name
+	id, err := api.tasks.Write(c.Request.Context(), rawTask)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		api.respondErr(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{})
+	setAuditTarget(c, id)
+	c.JSON(http.StatusCreated, taskCreated{ID: id})
 }
 
 // deleteTasks 删除任务
 func (api *ApplicationInterface) deleteTasks(c *gin.Context) {
-	id := c.Param("id")
-	err := api.tasks.Delete(c.Request.Context(), id)
+	ns, err := api.scope(c)
+	if err == nil {
+		err = api.tasks.Delete(c.Request.Context(), ns, c.Param("id"))
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		api.respondErr(c, err)
 		return
 	}
 	c.JSON(http.StatusNoContent, gin.H{})
@@ -119,17 +151,126 @@ func (api *ApplicationInterface) deleteTasks(c *gin.Context) {
 // getTasks 查看任务
 func (api *ApplicationInterface) getTasks(c *gin.Context) {
 	id := c.Param("id")
-	t, err := api.tasks.Peek(c.Request.Context(), id)
+	ns, err := api.scope(c)
+	var t common.RawTask
+	if err == nil {
+		t, err = api.tasks.Peek(c.Request.Context(), ns, id)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		api.respondErr(c, err)
 		return
 	}
 
 	c.Header("Content-Disposition", "attachment; filename="+id+".yml")
 	c.Header("Content-Type", "application/octet-stream")
-	c.Writer.Write([]byte(t.Description))
+	c.Writer.Write([]byte(secret.Redact(t.Description)))
+}
+
+// respondErr 按错误类型返回状态码
+func (api *ApplicationInterface) respondErr(c *gin.Context, err error) {
+	var fields validationErr
+	if errors.As(err, &fields) {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "validation failed", Fields: fields})
+		return
+	}
+
+	status := http.StatusInternalServerError
+	if errors.Is(err, common.ErrNotFound) {
+		status = http.StatusNotFound
+	} else if errors.Is(err, common.ErrConflict) {
+		status = http.StatusConflict
+	} else if errors.Is(err, errForbidden) {
+		status = http.StatusForbidden
+	}
+	c.JSON(status, errorResponse{Error: err.Error()})
+}
+
+// getWorkers 列出各服务器的worker，worker为所有命名空间共用，只对全局的调用者开放
+func (api *ApplicationInterface) getWorkers(c *gin.Context) {
+	if err := requireAdmin(c); err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	workers, err := api.tasks.Workers(c.Request.Context(), 3*workerHeartbeat)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, workers)
+}
+
+// listTasks 列出任务
+func (api *ApplicationInterface) listTasks(c *gin.Context) {
+	labels, err := labelFilter(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	ns, err := api.scope(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	overdue, _ := strconv.ParseBool(c.Query("overdue"))
+	opts := common.ListOptions{
+		Namespace: ns,
+		State:     c.Query("state"),
+		Labels:    labels,
+		Overdue:   overdue,
+		Limit:     limit,
+		Offset:    offset,
+	}
+
+	infos, err := api.tasks.List(c.Request.Context(), opts)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, infos)
+}
+
+// getTaskStatus 查看任务状态
+func (api *ApplicationInterface) getTaskStatus(c *gin.Context) {
+	ns, err := api.scope(c)
+	var info common.TaskInfo
+	if err == nil {
+		info, err = api.tasks.Status(c.Request.Context(), ns, c.Param("id"))
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// rerunTask 重新执行任务
+func (api *ApplicationInterface) rerunTask(c *gin.Context) {
+	ns, err := api.scope(c)
+	if err == nil {
+		err = api.tasks.Rerun(c.Request.Context(), ns, c.Param("id"))
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// getTaskLogs 查看任务日志
+func (api *ApplicationInterface) getTaskLogs(c *gin.Context) {
+	ns, err := api.scope(c)
+	var lines []common.LogLine
+	if err == nil {
+		lines, err = api.tasks.Logs(c.Request.Context(), ns, c.Param("id"))
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, lines)
 }
 
 func requestLogger() gin.HandlerFunc {
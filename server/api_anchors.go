@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/secret"
+	"github.com/turnon/clams/tasklist/common"
+	"gopkg.in/yaml.v3"
+)
+
+// anchorNamePattern 锚点名需能直接用于yaml的&和*
+var anchorNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// getAnchors 查看所有生效的锚点
+func (api *ApplicationInterface) getAnchors(c *gin.Context) {
+	ns, err := api.scope(c)
+	var set common.AnchorSet
+	if err == nil {
+		set, err = api.tasks.ListAnchors(c.Request.Context(), ns)
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	for i := range set.Anchors {
+		set.Anchors[i].Content = secret.Redact(set.Anchors[i].Content)
+	}
+	c.JSON(http.StatusOK, set)
+}
+
+// getAnchor 查看锚点的当前版本
+func (api *ApplicationInterface) getAnchor(c *gin.Context) {
+	ns, err := api.scope(c)
+	var history []common.Anchor
+	if err == nil {
+		history, err = api.tasks.AnchorHistory(c.Request.Context(), ns, c.Param("name"))
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	if history[0].Deleted {
+		api.respondErr(c, common.ErrNotFound)
+		return
+	}
+	history[0].Content = secret.Redact(history[0].Content)
+	c.JSON(http.StatusOK, history[0])
+}
+
+// getAnchorVersions 查看锚点的所有版本
+func (api *ApplicationInterface) getAnchorVersions(c *gin.Context) {
+	ns, err := api.scope(c)
+	var history []common.Anchor
+	if err == nil {
+		history, err = api.tasks.AnchorHistory(c.Request.Context(), ns, c.Param("name"))
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	for i := range history {
+		history[i].Content = secret.Redact(history[i].Content)
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// putAnchor 新增或修改锚点，请求体为锚点的yaml内容
+func (api *ApplicationInterface) putAnchor(c *gin.Context) {
+	name := c.Param("name")
+	if !anchorNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid anchor name"})
+		return
+	}
+	ns, err := api.target(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+
+	bytesArr, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content := string(bytesArr)
+	setAuditSummary(c, contentDigest(content))
+	var asMap map[string]any
+	if err := yaml.Unmarshal(bytesArr, &asMap); err != nil || len(asMap) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "anchor content should be a yaml mapping"})
+		return
+	}
+	if foreign := secret.Foreign(content, secretScope(ns.name)); len(foreign) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("secrets %s do not belong to namespace %s", strings.Join(foreign, ", "), ns.name)})
+		return
+	}
+	set, err := api.namespaces.anchorSet(c.Request.Context(), ns.name)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	if _, err := set.with(c.Request.Context(), common.Anchor{Name: name, Content: content}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	anchor, err := api.tasks.PutAnchor(c.Request.Context(), ns.name, name, content)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	setAuditSummary(c, contentDigest(content)+", version "+strconv.Itoa(anchor.Version))
+	anchor.Content = secret.Redact(anchor.Content)
+	c.JSON(http.StatusOK, anchor)
+}
+
+// deleteAnchor 删除锚点
+func (api *ApplicationInterface) deleteAnchor(c *gin.Context) {
+	ns, err := api.target(c)
+	if err == nil {
+		err = api.tasks.DeleteAnchor(c.Request.Context(), ns.name, c.Param("name"))
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusNoContent, gin.H{})
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// getMetrics 以prometheus的文本格式输出逾期未结束的任务数，以及正在运行的任务的benthos指标；
+// 和api一样要求token，命名空间的调用者只看到自己的命名空间
+func (api *ApplicationInterface) getMetrics(c *gin.Context) {
+	ns, err := api.scope(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+
+	var buf strings.Builder
+	buf.WriteString("# HELP clams_tasks_overdue Tasks past their deadline and not finished.\n")
+	buf.WriteString("# TYPE clams_tasks_overdue gauge\n")
+	for _, state := range []string{common.StatePending, common.StateRunning} {
+		n, err := api.tasks.Count(c.Request.Context(), common.ListOptions{Namespace: ns, State: state, Overdue: true})
+		if err != nil {
+			api.respondErr(c, err)
+			return
+		}
+		fmt.Fprintf(&buf, "clams_tasks_overdue{state=%q} %d\n", state, n)
+	}
+	if api.team != nil {
+		api.team.metrics.write(&buf, ns)
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(buf.String()))
+}
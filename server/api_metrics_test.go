@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	list := &overdueTasklist{counts: map[string]int{common.StatePending: 2, common.StateRunning: 1}}
+	nss, err := newNamespaces(context.Background(), "", map[string]namespaceConfig{"team-a": {Tokens: map[string]string{"alice": "ta"}}}, nil, &namespaceTasklist{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &ApplicationInterface{tasks: list, namespaces: nss, tokens: map[string]string{"prometheus": "scrape"}}
+	router := gin.New()
+	router.GET("/metrics", api.authenticate(), api.getMetrics)
+
+	scrape := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := scrape(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("metrics should require a token, got %d", w.Code)
+	}
+
+	w := scrape("scrape")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || list.namespace != "" || !strings.Contains(body, `clams_tasks_overdue{state="pending"} 2`) ||
+		!strings.Contains(body, `clams_tasks_overdue{state="running"} 1`) {
+		t.Errorf("overdue gauges should be exported, got %d %s", w.Code, body)
+	}
+
+	if w := scrape("ta"); w.Code != http.StatusOK || list.namespace != "team-a" {
+		t.Errorf("caller of a namespace should only see its namespace, got %d %q", w.Code, list.namespace)
+	}
+}
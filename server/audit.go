@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/secret"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// gin上下文中由handler补充的审计信息
+const (
+	auditTargetKey  = "audit_target"
+	auditSummaryKey = "audit_summary"
+)
+
+// auditBodyLimit 出错时最多保留这么多响应内容作为结果
+const auditBodyLimit = 4096
+
+// audit 在修改性的路由上记录调用者、来源、请求摘要和结果，记录失败不影响请求
+func (api *ApplicationInterface) audit(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		target := c.Param("id")
+		if target == "" {
+			target = c.Param("name")
+		}
+		if target == "" {
+			target = c.GetString(auditTargetKey)
+		}
+
+		entry := common.AuditEntry{
+			At:       time.Now(),
+			Caller:   c.GetString(callerKey),
+			ClientIP: c.ClientIP(),
+			Method:   c.Request.Method,
+			Path:     c.Request.URL.Path,
+			Action:   action,
+			Target:   target,
+			Summary:  secret.Redact(c.GetString(auditSummaryKey)),
+			Status:   writer.Status(),
+			Result:   secret.Redact(auditResult(writer.Status(), writer.body.Bytes())),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := api.tasks.Audit(ctx, entry); err != nil {
+			log.Error().Str("mod", mod).Str("action", action).Msgf("audit: %v", err)
+		}
+	}
+}
+
+// auditWriter 出错时留下响应内容
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(data []byte) (int, error) {
+	if w.Status() >= http.StatusBadRequest && w.body.Len() < auditBodyLimit {
+		rest := auditBodyLimit - w.body.Len()
+		if len(data) < rest {
+			rest = len(data)
+		}
+		w.body.Write(data[:rest])
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// auditResult 成功为ok，出错时为错误信息及各字段的错误
+func auditResult(status int, body []byte) string {
+	if status < http.StatusBadRequest {
+		return "ok"
+	}
+
+	var resp errorResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == "" {
+		return http.StatusText(status)
+	}
+	if len(resp.Fields) > 0 {
+		return resp.Error + ": " + validationErr(resp.Fields).Error()
+	}
+	return resp.Error
+}
+
+// setAuditTarget 记下新建对象的id
+func setAuditTarget(c *gin.Context, target string) {
+	c.Set(auditTargetKey, target)
+}
+
+// setAuditSummary 记下请求摘要
+func setAuditSummary(c *gin.Context, summary string) {
+	c.Set(auditSummaryKey, summary)
+}
+
+// contentDigest 内容的长度和sha256前缀，用于辨认上传的是哪个版本
+func contentDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return strconv.Itoa(len(content)) + " bytes sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// taskSummary 新建任务请求的摘要，参数只记名字
+func taskSummary(req taskRequest) string {
+	parts := []string{contentDigest(req.Description)}
+	if req.ScheduledAt != "" {
+		parts = append(parts, "scheduled_at="+req.ScheduledAt)
+	}
+	for _, group := range req.Concurrency {
+		parts = append(parts, "concurrency="+group.Key+"="+strconv.Itoa(group.Limit))
+	}
+	if len(req.Params) > 0 {
+		names := make([]string, 0, len(req.Params))
+		for name := range req.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts = append(parts, "params="+strings.Join(names, ","))
+	}
+	if len(req.Labels) > 0 {
+		parts = append(parts, "labels="+formatLabels(req.Labels))
+	}
+	if req.Deadline != "" {
+		parts = append(parts, "deadline="+req.Deadline)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// getAudit 查询审计记录
+func (api *ApplicationInterface) getAudit(c *gin.Context) {
+	if err := requireAdmin(c); err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	opts := common.AuditOptions{
+		Caller: c.Query("caller"),
+		Action: c.Query("action"),
+		Target: c.Query("target"),
+		Limit:  limit,
+		Offset: offset,
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			api.respondErr(c, validationErr{{Field: "since", Message: "should be like " + time.RFC3339}})
+			return
+		}
+		opts.Since = t
+	}
+
+	entries, err := api.tasks.AuditLog(c.Request.Context(), opts)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// auditTasklist 记录审计记录，新建任务时返回固定id
+type auditTasklist struct {
+	common.Tasklist
+	entries []common.AuditEntry
+}
+
+func (list *auditTasklist) Write(ctx context.Context, rawTask common.RawTask) (string, error) {
+	return "42", nil
+}
+
+func (list *auditTasklist) Delete(ctx context.Context, namespace string, id string) error {
+	return nil
+}
+
+func (list *auditTasklist) Audit(ctx context.Context, entry common.AuditEntry) error {
+	list.entries = append(list.entries, entry)
+	return nil
+}
+
+func TestAuditMutatingCalls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	list := &auditTasklist{}
+	api := &ApplicationInterface{tasks: list}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(callerKey, "ops") })
+	router.POST("/tasks", api.audit("task.create"), api.postTasks)
+	router.DELETE("/tasks/:id", api.audit("task.cancel"), api.deleteTasks)
+
+	send := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send(http.MethodPost, "/tasks", `{"description": "input: {}", "params": {"password": "hunter2"}}`)
+	send(http.MethodPost, "/tasks", `{"description": "input: {}", "scheduled_at": "soon"}`)
+	send(http.MethodDelete, "/tasks/7", "")
+
+	if len(list.entries) != 3 {
+		t.Fatalf("every call should be audited, got %+v", list.entries)
+	}
+
+	created := list.entries[0]
+	if created.Caller != "ops" || created.Action != "task.create" || created.Target != "42" || created.Result != "ok" {
+		t.Errorf("unexpected entry of created task: %+v", created)
+	}
+	if !strings.Contains(created.Summary, "params=password") || strings.Contains(created.Summary, "hunter2") {
+		t.Errorf("summary should name params without values, got %q", created.Summary)
+	}
+
+	invalid := list.entries[1]
+	if invalid.Status != http.StatusBadRequest || !strings.Contains(invalid.Result, "scheduled_at") {
+		t.Errorf("failed call should record its errors, got %+v", invalid)
+	}
+
+	cancelled := list.entries[2]
+	if cancelled.Action != "task.cancel" || cancelled.Target != "7" || cancelled.Status != http.StatusNoContent {
+		t.Errorf("unexpected entry of cancelled task: %+v", cancelled)
+	}
+}
+
+func TestAuditResult(t *testing.T) {
+	if got := auditResult(http.StatusOK, nil); got != "ok" {
+		t.Errorf("success should be ok, got %q", got)
+	}
+	if got := auditResult(http.StatusBadGateway, []byte("<html>")); got != "Bad Gateway" {
+		t.Errorf("unknown body should fall back to status text, got %q", got)
+	}
+	if got := auditResult(http.StatusNotFound, []byte(`{"error": "task not found"}`)); got != "task not found" {
+		t.Errorf("error should be kept, got %q", got)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// callerKey gin上下文中调用者名字的键
+const callerKey = "caller"
+
+// namespaceKey gin上下文中调用者所属命名空间的键，全局tokens的调用者没有
+const namespaceKey = "namespace"
+
+// authenticate 配置了tokens时，要求请求带上 Authorization: Bearer <token>；
+// 全局tokens可以访问所有命名空间，命名空间的tokens只能访问该命名空间
+func (api *ApplicationInterface) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		api.lock.RLock()
+		tokens := api.tokens
+		api.lock.RUnlock()
+
+		if len(tokens) == 0 && !api.namespaces.hasTokens() {
+			c.Set(callerKey, "anonymous")
+			return
+		}
+
+		token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if found {
+			for name, expected := range tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+					c.Set(callerKey, name)
+					return
+				}
+			}
+			if caller, ns, ok := api.namespaces.caller(token); ok {
+				c.Set(callerKey, caller)
+				c.Set(namespaceKey, ns)
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	}
+}
+
+// scope 调用者要访问的命名空间，为空时不限；命名空间的调用者只能访问自己的命名空间，其他调用者由namespace参数指定
+func (api *ApplicationInterface) scope(c *gin.Context) (string, error) {
+	requested := c.Query("namespace")
+	own := c.GetString(namespaceKey)
+	if own == "" {
+		return requested, nil
+	}
+	if requested != "" && requested != own {
+		return "", fmt.Errorf("%w: caller can only access namespace %s", errForbidden, own)
+	}
+	return own, nil
+}
+
+// target 新建任务或修改锚点所在的命名空间，未指定时为默认的命名空间，须已配置
+func (api *ApplicationInterface) target(c *gin.Context) (*namespace, error) {
+	name, err := api.scope(c)
+	if err != nil {
+		return nil, err
+	}
+	return api.configured(name)
+}
+
+// configured 已配置的命名空间，为空时为默认的命名空间
+func (api *ApplicationInterface) configured(name string) (*namespace, error) {
+	if name == "" {
+		name = common.DefaultNamespace
+	}
+	ns, ok := api.namespaces.get(name)
+	if !ok {
+		return nil, validationErr{{Field: "namespace", Message: name + " is not configured"}}
+	}
+	return ns, nil
+}
+
+// requireAdmin 只允许全局tokens的调用者访问
+func requireAdmin(c *gin.Context) error {
+	if ns := c.GetString(namespaceKey); ns != "" {
+		return fmt.Errorf("%w: caller of namespace %s", errForbidden, ns)
+	}
+	return nil
+}
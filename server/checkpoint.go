@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/checkpoint"
+	"github.com/turnon/clams/guard"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// checkpointCacheYAML 加到每个任务上的检查点缓存资源
+const checkpointCacheYAML = "label: " + checkpoint.CacheName + "\n" + checkpoint.CacheName + ": {}\n"
+
+// checkpointCache 以缓存资源的形式把任务的检查点提供给input
+type checkpointCache struct {
+	task common.Task
+}
+
+// taskEnvironment 为任务准备benthos环境，带上读写该任务检查点的缓存、接收插件panic的缓存、记录任务id的processor、记录任务指标的插件，以及读取任务id和参数的bloblang函数
+func taskEnvironment(task common.Task, failure *taskFailure, metrics *taskMetrics) (*service.Environment, error) {
+	env := service.GlobalEnvironment().Clone()
+
+	blobl, err := taskBloblang(task)
+	if err != nil {
+		return nil, err
+	}
+	env.UseBloblangEnvironment(blobl)
+
+	err = env.RegisterCache(
+		checkpoint.CacheName,
+		service.NewConfigSpec().Summary("Checkpoints of the running task"),
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Cache, error) {
+			return &checkpointCache{task: task}, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = env.RegisterCache(
+		guard.CacheName,
+		service.NewConfigSpec().Summary("Panics reported by plugins of the running task"),
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Cache, error) {
+			return &guardCache{failure: failure}, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = env.RegisterMetricsExporter(
+		taskMetricsExporter,
+		service.NewConfigSpec().Summary("Metrics of the running task, exported by the server"),
+		func(conf *service.ParsedConfig, log *service.Logger) (service.MetricsExporter, error) {
+			return &metricsExporter{metrics: metrics, taskID: task.ID(), namespace: task.Namespace()}, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = env.RegisterProcessor(
+		taskMetadataProcessor,
+		service.NewConfigSpec().Summary("Records the id of the running task as metadata "+taskIDMetadata),
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Processor, error) {
+			return &taskMetadata{id: task.ID()}, nil
+		},
+	)
+	return env, err
+}
+
+func (cache *checkpointCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := cache.task.Checkpoint(ctx, key)
+	if errors.Is(err, common.ErrNotFound) {
+		return nil, service.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+func (cache *checkpointCache) Set(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
+	return cache.task.SaveCheckpoint(ctx, key, string(value))
+}
+
+func (cache *checkpointCache) Add(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
+	return cache.task.SaveCheckpoint(ctx, key, string(value))
+}
+
+func (cache *checkpointCache) Delete(ctx context.Context, key string) error {
+	return cache.task.SaveCheckpoint(ctx, key, "")
+}
+
+func (cache *checkpointCache) Close(ctx context.Context) error {
+	return nil
+}
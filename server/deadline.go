@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// hookRetries 通知失败时的重试次数
+const hookRetries = 3
+
+// overdueQueueSize 等待发送的通知数，满了的留到下次检查
+const overdueQueueSize = 1000
+
+// hookBackoff 通知失败后第一次重试前的等待，之后逐次加长
+var hookBackoff = time.Second
+
+// deadlineConfig 截止时间的检查，hook不为空时任务逾期后向它POST通知
+type deadlineConfig struct {
+	Interval string            `yaml:"interval"`
+	Hook     string            `yaml:"hook"`
+	Headers  map[string]string `yaml:"headers"`
+}
+
+// overdueEvent 任务逾期时的通知
+type overdueEvent struct {
+	Event string          `json:"event"`
+	Task  common.TaskInfo `json:"task"`
+}
+
+// overdueChecker 定期把过了截止时间仍未结束的任务标为逾期并通知，不中止任务；
+// 通知由deliver逐个发送，送达后才记为已通知，没送达的下次检查时再发
+type overdueChecker struct {
+	tasks    common.Tasklist
+	interval time.Duration
+	hook     string
+	headers  map[string]string
+	client   *http.Client
+
+	queue   chan common.TaskInfo
+	lock    sync.Mutex
+	pending map[string]bool
+}
+
+// newOverdueChecker 创建检查，由领导者运行loop
+func newOverdueChecker(cfg deadlineConfig, tasks common.Tasklist) (*overdueChecker, error) {
+	interval := time.Minute
+	if cfg.Interval != "" {
+		d, err := time.ParseDuration(cfg.Interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("deadlines.interval: invalid duration %q", cfg.Interval)
+		}
+		interval = d
+	}
+	if cfg.Hook != "" && !strings.HasPrefix(cfg.Hook, "http://") && !strings.HasPrefix(cfg.Hook, "https://") {
+		return nil, fmt.Errorf("deadlines.hook should be a http or https url")
+	}
+
+	c := &overdueChecker{
+		tasks:    tasks,
+		interval: interval,
+		hook:     cfg.Hook,
+		headers:  cfg.Headers,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan common.TaskInfo, overdueQueueSize),
+		pending:  map[string]bool{},
+	}
+	return c, nil
+}
+
+// loop 按间隔检查，直到ctx结束
+func (c *overdueChecker) loop(ctx context.Context) {
+	go c.deliver(ctx)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check 检查一次，把逾期且还没通知到的任务交给deliver，不等通知发完
+func (c *overdueChecker) check(ctx context.Context) {
+	infos, err := c.tasks.MarkOverdue(ctx, time.Now())
+	if err != nil && ctx.Err() == nil {
+		log.Error().Str("mod", "deadline").Msgf("mark overdue: %v", err)
+	}
+
+	for _, info := range infos {
+		if c.hook == "" {
+			log.Warn().Str("mod", "deadline").Str("task", info.ID).Str("state", info.State).
+				Msgf("task is overdue, deadline was %s", info.Deadline.Format(scheduledAtLayout))
+			c.notified(ctx, info.ID)
+			continue
+		}
+		c.enqueue(info)
+	}
+}
+
+// enqueue 排队等待通知，已在排队或队列已满时跳过
+func (c *overdueChecker) enqueue(info common.TaskInfo) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.pending[info.ID] {
+		return
+	}
+	select {
+	case c.queue <- info:
+		c.pending[info.ID] = true
+		log.Warn().Str("mod", "deadline").Str("task", info.ID).Str("state", info.State).
+			Msgf("task is overdue, deadline was %s", info.Deadline.Format(scheduledAtLayout))
+	default:
+		log.Warn().Str("mod", "deadline").Str("task", info.ID).Msg("notification queue is full, retry on next check")
+	}
+}
+
+// deliver 逐个发送排队的通知，直到ctx结束
+func (c *overdueChecker) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case info := <-c.queue:
+			if err := c.notify(ctx, info); err != nil {
+				log.Error().Str("mod", "deadline").Str("task", info.ID).Msgf("notify: %v, retry on next check", err)
+			} else {
+				c.notified(ctx, info.ID)
+			}
+
+			c.lock.Lock()
+			delete(c.pending, info.ID)
+			c.lock.Unlock()
+		}
+	}
+}
+
+// notified 记下已通知，记不下时下次检查会再通知一次
+func (c *overdueChecker) notified(ctx context.Context, id string) {
+	if err := c.tasks.OverdueNotified(ctx, id); err != nil && ctx.Err() == nil {
+		log.Error().Str("mod", "deadline").Str("task", id).Msgf("mark notified: %v", err)
+	}
+}
+
+// notify 向hook发送通知，失败时稍后重试
+func (c *overdueChecker) notify(ctx context.Context, info common.TaskInfo) error {
+	body, err := json.Marshal(overdueEvent{Event: "task.overdue", Task: info})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		err = c.post(ctx, body)
+		if err == nil || i == hookRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(i+1) * hookBackoff):
+		}
+	}
+}
+
+// post 发送一次，非2xx的响应也算失败
+func (c *overdueChecker) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("hook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// overdueTasklist 检查时返回还没通知到的overdue，按状态统计逾期的任务并记下统计的命名空间
+type overdueTasklist struct {
+	common.Tasklist
+	lock      sync.Mutex
+	overdue   []common.TaskInfo
+	notified  chan string
+	counts    map[string]int
+	namespace string
+}
+
+func (list *overdueTasklist) MarkOverdue(ctx context.Context, now time.Time) ([]common.TaskInfo, error) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	return append([]common.TaskInfo(nil), list.overdue...), nil
+}
+
+func (list *overdueTasklist) OverdueNotified(ctx context.Context, id string) error {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	for i, info := range list.overdue {
+		if info.ID == id {
+			list.overdue = append(list.overdue[:i], list.overdue[i+1:]...)
+			break
+		}
+	}
+	if list.notified != nil {
+		list.notified <- id
+	}
+	return nil
+}
+
+func (list *overdueTasklist) Count(ctx context.Context, opts common.ListOptions) (int, error) {
+	if !opts.Overdue {
+		return 0, nil
+	}
+	list.namespace = opts.Namespace
+	return list.counts[opts.State], nil
+}
+
+func TestOverdueCheckerNotifies(t *testing.T) {
+	events := make(chan overdueEvent, 2)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xxx" {
+			t.Errorf("configured headers should be sent, got %v", r.Header)
+		}
+		var event overdueEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer hook.Close()
+
+	deadline := time.Now().Add(-time.Minute)
+	list := &overdueTasklist{overdue: []common.TaskInfo{{ID: "7", State: common.StateRunning, Deadline: &deadline}}, notified: make(chan string, 1)}
+	checker, err := newOverdueChecker(deadlineConfig{Hook: hook.URL, Headers: map[string]string{"Authorization": "Bearer xxx"}}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go checker.deliver(ctx)
+
+	checker.check(ctx)
+	waitNotified(t, list, "7")
+	checker.check(ctx)
+	close(events)
+
+	var got []overdueEvent
+	for event := range events {
+		got = append(got, event)
+	}
+	if len(got) != 1 || got[0].Event != "task.overdue" || got[0].Task.ID != "7" {
+		t.Errorf("each overdue task should be notified once, got %+v", got)
+	}
+
+	for _, bad := range []deadlineConfig{{Interval: "soon"}, {Hook: "mailto:ops@example.com"}} {
+		if _, err := newOverdueChecker(bad, list); err == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+}
+
+// waitNotified 等待任务被记为已通知
+func waitNotified(t *testing.T, list *overdueTasklist, id string) {
+	t.Helper()
+	select {
+	case got := <-list.notified:
+		if got != id {
+			t.Fatalf("task %s should be notified, got %s", id, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("task %s should be notified", id)
+	}
+}
+
+func TestOverdueCheckerRetries(t *testing.T) {
+	backoff := hookBackoff
+	hookBackoff = time.Millisecond
+	defer func() { hookBackoff = backoff }()
+
+	// 第一次请求卡住，之后一轮重试都失败，下一轮才成功
+	var attempts atomic.Int32
+	release := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := attempts.Add(1)
+		if n == 1 {
+			<-release
+		}
+		if n <= hookRetries+1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer hook.Close()
+
+	deadline := time.Now().Add(-time.Minute)
+	list := &overdueTasklist{overdue: []common.TaskInfo{{ID: "7", State: common.StatePending, Deadline: &deadline}}, notified: make(chan string, 1)}
+	checker, err := newOverdueChecker(deadlineConfig{Hook: hook.URL}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go checker.deliver(ctx)
+
+	start := time.Now()
+	checker.check(ctx)
+	checker.check(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("a slow hook should not stall checks, took %v", elapsed)
+	}
+	close(release)
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		checker.lock.Lock()
+		pending := len(checker.pending)
+		checker.lock.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failed notification should leave the queue")
+		}
+	}
+	if n := attempts.Load(); n != hookRetries+1 {
+		t.Errorf("notification should be sent once with %d retries, got %d attempts", hookRetries, n)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now()); len(infos) != 1 {
+		t.Fatalf("failed notification should not be marked as notified, got %+v", infos)
+	}
+
+	checker.check(ctx)
+	waitNotified(t, list, "7")
+	if n := attempts.Load(); n != hookRetries+2 {
+		t.Errorf("failed notification should be retried on next check, got %d attempts", n)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheck 一项就绪检查的结果
+type healthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// healthResponse 健康检查结果
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks,omitempty"`
+}
+
+// getHealthz 存活检查，进程能响应即可
+func (api *ApplicationInterface) getHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// getReadyz 就绪检查，任务列表可用并且worker在领取任务
+func (api *ApplicationInterface) getReadyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := []healthCheck{
+		newHealthCheck("tasklist", api.tasks.Ping(ctx)),
+	}
+	if api.team != nil {
+		checks = append(checks, newHealthCheck("workteam", api.team.ready()))
+	}
+	if api.ctx.Err() != nil {
+		checks = append(checks, newHealthCheck("server", api.ctx.Err()))
+	}
+
+	for _, check := range checks {
+		if !check.OK {
+			c.JSON(http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Checks: checks})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, healthResponse{Status: "ok", Checks: checks})
+}
+
+// newHealthCheck 由检查的错误生成结果
+func newHealthCheck(name string, err error) healthCheck {
+	if err != nil {
+		return healthCheck{Name: name, Error: err.Error()}
+	}
+	return healthCheck{Name: name, OK: true}
+}
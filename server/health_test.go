@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/turnon/clams/tasklist/common"
+	"github.com/turnon/clams/tasklist/redistasklist"
+)
+
+func TestWorkteamReady(t *testing.T) {
+	exited := &taskWorker{running: make(chan struct{}), readCtx: context.Background()}
+	close(exited.running)
+	failing := &taskWorker{running: make(chan struct{}), readCtx: context.Background(), lastErr: errors.New("connection refused")}
+	claiming := &taskWorker{running: make(chan struct{}), readCtx: context.Background()}
+
+	team := &workteam{workers: []*taskWorker{exited}}
+	if team.ready() == nil {
+		t.Error("team without running workers should not be ready")
+	}
+
+	team.workers = append(team.workers, failing)
+	if err := team.ready(); err == nil || !errors.Is(err, failing.lastErr) {
+		t.Errorf("team should report the read error, got %v", err)
+	}
+
+	team.workers = append(team.workers, claiming)
+	if err := team.ready(); err != nil {
+		t.Errorf("team with a claiming worker should be ready, got %v", err)
+	}
+}
+
+func TestWorkteamReadyThroughRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr := miniredis.RunT(t)
+	list, err := redistasklist.Init(ctx, map[string]any{"url": "redis://" + mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := newWorkteam(ctx, list, 1, nil, nil, isolationConfig{}, nil, nil)
+
+	// 稍后到期的任务让空闲的worker很快再去领取
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	scheduledAt := time.Now().In(shanghai).Add(2 * time.Second).Format(scheduledAtLayout)
+	if _, err := list.Write(ctx, common.RawTask{Description: "input: {}", ScheduledAt: scheduledAt}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := team.ready(); err != nil {
+		t.Fatalf("team waiting for tasks should be ready, got %v", err)
+	}
+
+	// redis不可用时领取任务出错，不再就绪
+	mr.Close()
+	deadline := time.Now().Add(10 * time.Second)
+	for team.ready() == nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := team.ready(); err == nil || !strings.Contains(err.Error(), "no worker is claiming tasks") {
+		t.Errorf("team should not be ready when reading fails, got %v", err)
+	}
+
+	cancel()
+	select {
+	case <-team.wait():
+	case <-time.After(5 * time.Second):
+		t.Fatal("team should stop with the context")
+	}
+}
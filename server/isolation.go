@@ -0,0 +1,427 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/turnon/clams/secret"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// IsolatedTaskCommand 子进程执行任务时的命令
+const IsolatedTaskCommand = "isolated-task"
+
+// isolationConfig 每个任务在独立的子进程中运行，并限制其资源；Env为除基本变量外传给子进程的环境变量名
+type isolationConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	MemoryMB   int      `yaml:"memory_mb"`
+	CPUSeconds int      `yaml:"cpu_seconds"`
+	Env        []string `yaml:"env"`
+}
+
+// isolatedBaseEnv 总是传给子进程的环境变量
+var isolatedBaseEnv = []string{"PATH", "HOME", "TMPDIR", "TZ", "LANG", "LC_ALL"}
+
+// isolatedInit 父进程经stdin交给子进程的任务
+type isolatedInit struct {
+	ID          string            `json:"id"`
+	Namespace   string            `json:"namespace"`
+	Description string            `json:"description"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+// isolatedEvent 子进程经fd 3发给父进程的事件
+type isolatedEvent struct {
+	Type    string `json:"type"`
+	Key     string `json:"key,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// isolatedReply 父进程经fd 4对检查点请求的回复
+type isolatedReply struct {
+	Value string `json:"value,omitempty"`
+	Found bool   `json:"found,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	eventLog            = "log"
+	eventCheckpoint     = "checkpoint"
+	eventSaveCheckpoint = "save_checkpoint"
+	eventDone           = "done"
+	eventError          = "error"
+	eventMetrics        = "metrics"
+)
+
+// stderrTail 子进程异常退出时，错误中带上的stderr长度
+const stderrTail = 4096
+
+// runIsolated 在子进程中运行任务，子进程的日志和检查点读写经管道转给task
+func runIsolated(ctx context.Context, task common.Task, resolved secret.Resolved, cfg isolationConfig, metrics *taskMetrics) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	input, err := json.Marshal(isolatedInit{ID: task.ID(), Namespace: task.Namespace(), Description: resolved.Text, Params: task.Params()})
+	if err != nil {
+		return err
+	}
+
+	eventsR, eventsW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer eventsR.Close()
+	repliesR, repliesW, err := os.Pipe()
+	if err != nil {
+		eventsW.Close()
+		return err
+	}
+	defer repliesW.Close()
+
+	stderr := &tailBuffer{limit: stderrTail}
+	cmd := isolatedCommand(exe, cfg, resolved)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	cmd.ExtraFiles = []*os.File{eventsW, repliesR}
+
+	err = cmd.Start()
+	eventsW.Close()
+	repliesR.Close()
+	if err != nil {
+		return err
+	}
+
+	// 中止或退出时通知子进程停止，不响应则强制结束
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		case <-task.Aborted():
+		}
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-exited:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+		}
+	}()
+
+	childErr := serveIsolated(ctx, task, resolved, metrics, eventsR, json.NewEncoder(repliesW))
+	waitErr := cmd.Wait()
+
+	if state := cmd.ProcessState; state != nil {
+		task.Log(ctx, isolatedUsage(state))
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if childErr != "" {
+		return errors.New(childErr)
+	}
+	if waitErr != nil {
+		if tail := stderr.String(); tail != "" {
+			return fmt.Errorf("%w: %s", waitErr, tail)
+		}
+		return waitErr
+	}
+	return nil
+}
+
+// isolatedCommand 启动子进程的命令，子进程只拿到基本的和配置的环境变量，保存密钥的变量总是去掉
+func isolatedCommand(exe string, cfg isolationConfig, resolved secret.Resolved) *exec.Cmd {
+	cmd := exec.Command(exe, IsolatedTaskCommand,
+		"-memory-mb", strconv.Itoa(cfg.MemoryMB),
+		"-cpu-seconds", strconv.Itoa(cfg.CPUSeconds))
+
+	cmd.Env = []string{}
+	for _, name := range append(append([]string{}, isolatedBaseEnv...), cfg.Env...) {
+		if value, ok := resolved.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+	return cmd
+}
+
+// serveIsolated 处理子进程的事件直到管道关闭，返回子进程报告的错误
+func serveIsolated(ctx context.Context, task common.Task, resolved secret.Resolved, metrics *taskMetrics, events io.Reader, replies *json.Encoder) string {
+	childErr := ""
+	scanner := bufio.NewScanner(events)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var event isolatedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+
+		switch event.Type {
+		case eventLog:
+			task.Log(ctx, resolved.Scrub(event.Message))
+		case eventError:
+			childErr = event.Message
+		case eventMetrics:
+			var series []metricSeries
+			if err := json.Unmarshal([]byte(event.Value), &series); err == nil {
+				metrics.replace(task.ID(), task.Namespace(), series)
+			}
+		case eventCheckpoint:
+			value, err := task.Checkpoint(ctx, event.Key)
+			reply := isolatedReply{Value: value, Found: err == nil}
+			if err != nil && !errors.Is(err, common.ErrNotFound) {
+				reply.Error = err.Error()
+			}
+			replies.Encode(reply)
+		case eventSaveCheckpoint:
+			reply := isolatedReply{}
+			if err := task.SaveCheckpoint(ctx, event.Key, event.Value); err != nil {
+				reply.Error = err.Error()
+			}
+			replies.Encode(reply)
+		}
+	}
+	return childErr
+}
+
+// isolatedUsage 子进程的退出状态和资源用量
+func isolatedUsage(state *os.ProcessState) string {
+	usage := fmt.Sprintf("process exited: %s, user %v, sys %v", state, state.UserTime(), state.SystemTime())
+	if rss, ok := maxRSS(state); ok {
+		usage += fmt.Sprintf(", max rss %d KB", rss)
+	}
+	return usage
+}
+
+// tailBuffer 只保留最后limit字节
+type tailBuffer struct {
+	lock  sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.limit; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return string(bytes.TrimSpace(b.buf))
+}
+
+// RunIsolatedTask 子进程入口：从stdin读任务，限制资源后运行，返回退出码
+func RunIsolatedTask(args []string) int {
+	fs := flag.NewFlagSet(IsolatedTaskCommand, flag.ContinueOnError)
+	memoryMB := fs.Int("memory-mb", 0, "memory limit in MB")
+	cpuSeconds := fs.Int("cpu-seconds", 0, "cpu time limit in seconds")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	events := os.NewFile(3, "events")
+	replies := os.NewFile(4, "replies")
+	if events == nil || replies == nil {
+		fmt.Fprintln(os.Stderr, "should be started by clams server")
+		return 2
+	}
+
+	task := &remoteTask{
+		events:  json.NewEncoder(events),
+		replies: json.NewDecoder(replies),
+		aborted: make(chan struct{}),
+	}
+
+	if err := setLimits(*memoryMB, *cpuSeconds); err != nil {
+		task.Error(context.Background(), err)
+		return 1
+	}
+
+	var init isolatedInit
+	if err := json.NewDecoder(os.Stdin).Decode(&init); err != nil {
+		task.Error(context.Background(), err)
+		return 1
+	}
+	task.id, task.namespace, task.description, task.params = init.ID, init.Namespace, init.Description, init.Params
+
+	// 父进程发来SIGTERM时中止stream
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sig
+		close(task.aborted)
+	}()
+
+	// 指标定期发给父进程，结束前再发一次
+	metrics := newTaskMetrics()
+	stopRelay, relayed := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(relayed)
+		task.relayMetrics(metrics, stopRelay)
+	}()
+
+	ctx := context.Background()
+	err := runStream(ctx, task, secret.Resolved{Text: init.Description}, metrics)
+	close(stopRelay)
+	<-relayed
+	if err != nil {
+		task.Error(ctx, err)
+		return 1
+	}
+	task.Done(ctx)
+	return 0
+}
+
+// remoteTask 子进程中的任务，日志和检查点经管道交给父进程
+type remoteTask struct {
+	id          string
+	namespace   string
+	description string
+	params      map[string]string
+	aborted     chan struct{}
+
+	lock    sync.Mutex
+	events  *json.Encoder
+	replies *json.Decoder
+}
+
+func (task *remoteTask) ID() string {
+	return task.id
+}
+
+func (task *remoteTask) Namespace() string {
+	return task.namespace
+}
+
+func (task *remoteTask) Description() string {
+	return task.description
+}
+
+func (task *remoteTask) Params() map[string]string {
+	return task.params
+}
+
+// Window 执行时间已由父进程判断
+func (task *remoteTask) Window() string {
+	return ""
+}
+
+func (task *remoteTask) Concurrency() []common.ConcurrencyGroup {
+	return nil
+}
+
+func (task *remoteTask) Aborted() chan struct{} {
+	return task.aborted
+}
+
+func (task *remoteTask) Done(ctx context.Context) error {
+	return task.send(isolatedEvent{Type: eventDone})
+}
+
+func (task *remoteTask) Error(ctx context.Context, err error) error {
+	return task.send(isolatedEvent{Type: eventError, Message: err.Error()})
+}
+
+// Defer 子进程中的任务已经开始执行，不能再推迟
+func (task *remoteTask) Defer(ctx context.Context, until time.Time, reason string) error {
+	return errors.New("isolated task can not be deferred")
+}
+
+// UseAnchors 锚点已由父进程记录
+func (task *remoteTask) UseAnchors(ctx context.Context, revision int) error {
+	return nil
+}
+
+func (task *remoteTask) Log(ctx context.Context, message string) error {
+	return task.send(isolatedEvent{Type: eventLog, Message: message})
+}
+
+func (task *remoteTask) Checkpoint(ctx context.Context, key string) (string, error) {
+	reply, err := task.request(isolatedEvent{Type: eventCheckpoint, Key: key})
+	if err != nil {
+		return "", err
+	}
+	if !reply.Found {
+		return "", common.ErrNotFound
+	}
+	return reply.Value, nil
+}
+
+func (task *remoteTask) SaveCheckpoint(ctx context.Context, key, value string) error {
+	_, err := task.request(isolatedEvent{Type: eventSaveCheckpoint, Key: key, Value: value})
+	return err
+}
+
+// relayMetrics 按间隔把任务的指标发给父进程，stop关闭时最后发一次
+func (task *remoteTask) relayMetrics(metrics *taskMetrics, stop chan struct{}) {
+	ticker := time.NewTicker(isolatedMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+		case <-ticker.C:
+		}
+
+		if series, err := json.Marshal(metrics.snapshot(task.id)); err == nil {
+			task.send(isolatedEvent{Type: eventMetrics, Value: string(series)})
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// send 发出事件
+func (task *remoteTask) send(event isolatedEvent) error {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	return task.events.Encode(event)
+}
+
+// request 发出事件并等待回复
+func (task *remoteTask) request(event isolatedEvent) (isolatedReply, error) {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+
+	var reply isolatedReply
+	if err := task.events.Encode(event); err != nil {
+		return reply, err
+	}
+	if err := task.replies.Decode(&reply); err != nil {
+		return reply, err
+	}
+	if reply.Error != "" {
+		return reply, errors.New(reply.Error)
+	}
+	return reply, nil
+}
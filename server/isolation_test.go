@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/turnon/clams/secret"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// memoryTask 把日志和检查点记在内存里的任务
+type memoryTask struct {
+	common.Task
+	logs        []string
+	checkpoints map[string]string
+}
+
+func (task *memoryTask) Log(ctx context.Context, message string) error {
+	task.logs = append(task.logs, message)
+	return nil
+}
+
+func (task *memoryTask) Checkpoint(ctx context.Context, key string) (string, error) {
+	value, ok := task.checkpoints[key]
+	if !ok {
+		return "", common.ErrNotFound
+	}
+	return value, nil
+}
+
+func (task *memoryTask) SaveCheckpoint(ctx context.Context, key, value string) error {
+	task.checkpoints[key] = value
+	return nil
+}
+
+func TestIsolatedProtocol(t *testing.T) {
+	eventsR, eventsW := io.Pipe()
+	repliesR, repliesW := io.Pipe()
+
+	parent := &memoryTask{checkpoints: map[string]string{}}
+	child := &remoteTask{events: json.NewEncoder(eventsW), replies: json.NewDecoder(repliesR)}
+
+	served := make(chan string)
+	go func() {
+		resolved := secret.Resolved{Text: "password: s3cret"}
+		served <- serveIsolated(context.Background(), parent, resolved, nil, eventsR, json.NewEncoder(repliesW))
+	}()
+
+	if _, err := child.Checkpoint(context.Background(), "scan"); !errors.Is(err, common.ErrNotFound) {
+		t.Fatalf("missing checkpoint should be ErrNotFound, got %v", err)
+	}
+	if err := child.SaveCheckpoint(context.Background(), "scan", "page-2"); err != nil {
+		t.Fatal(err)
+	}
+	value, err := child.Checkpoint(context.Background(), "scan")
+	if err != nil || value != "page-2" {
+		t.Fatalf("checkpoint should be page-2, got %q %v", value, err)
+	}
+
+	child.Log(context.Background(), "connected")
+	child.Error(context.Background(), errors.New("output failed"))
+	eventsW.Close()
+
+	if childErr := <-served; childErr != "output failed" {
+		t.Errorf("child error should be reported, got %q", childErr)
+	}
+	if len(parent.logs) != 1 || parent.logs[0] != "connected" {
+		t.Errorf("child logs should reach the parent, got %v", parent.logs)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	buf := &tailBuffer{limit: 8}
+	buf.Write([]byte("panic: "))
+	buf.Write([]byte("boom\n"))
+	if got := buf.String(); got != "c: boom" {
+		t.Errorf("should keep the last 8 bytes, got %q", got)
+	}
+}
+
+func TestIsolatedCommandEnv(t *testing.T) {
+	t.Setenv("CLAMS_SECRET_KEY", "master key")
+	t.Setenv("CLAMS_SECRET_DB_PASSWORD", "s3cret")
+	t.Setenv("DB_HOST", "db.internal")
+	t.Setenv("UNLISTED", "x")
+	resolver, err := secret.NewResolver(secret.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, _ := resolver.Resolve("input: {}", "")
+
+	cmd := isolatedCommand("clams", isolationConfig{Env: []string{"DB_HOST", "CLAMS_SECRET_KEY"}}, resolved)
+	env := strings.Join(cmd.Env, "\n")
+	if !strings.Contains(env, "DB_HOST=db.internal") || !strings.Contains(env, "PATH=") {
+		t.Errorf("base and configured variables should reach the child, got %v", cmd.Env)
+	}
+	for _, hidden := range []string{"CLAMS_SECRET_KEY", "CLAMS_SECRET_DB_PASSWORD", "UNLISTED"} {
+		if strings.Contains(env, hidden+"=") {
+			t.Errorf("%s should not reach the child, got %v", hidden, cmd.Env)
+		}
+	}
+}
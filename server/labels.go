@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// labelKeyPattern 标签名，不能含有=以便写成key=value
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_./-]{0,62}$`)
+
+// maxLabelValue 标签值的最大长度
+const maxLabelValue = 256
+
+// tasksCancelled 批量取消的响应
+type tasksCancelled struct {
+	IDs []string `json:"ids"`
+}
+
+// validateLabels 校验标签，按标签名排序输出错误
+func validateLabels(labels map[string]string) []fieldError {
+	keys := sortedKeys(labels)
+	var fields []fieldError
+	for _, k := range keys {
+		if !labelKeyPattern.MatchString(k) {
+			fields = append(fields, fieldError{Field: "labels." + k, Message: "should be letters, digits, _ . / or -, at most 63"})
+		}
+		if len(labels[k]) > maxLabelValue {
+			fields = append(fields, fieldError{Field: "labels." + k, Message: fmt.Sprintf("should be at most %d bytes", maxLabelValue)})
+		}
+	}
+	return fields
+}
+
+// labelFilter 解析查询参数中形如 label=key=value 的标签条件
+func labelFilter(c *gin.Context) (map[string]string, error) {
+	labels, err := parsePairs("label", c.QueryArray("label"))
+	if err != nil {
+		return nil, validationErr{{Field: "label", Message: err.Error()}}
+	}
+	if fields := validateLabels(labels); len(fields) > 0 {
+		return nil, validationErr(fields)
+	}
+	return labels, nil
+}
+
+// formatLabels 按标签名排序写成 key=value,key=value
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, k := range sortedKeys(labels) {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// sortedKeys 排序后的键
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cancelMatching 取消带有指定标签的未结束任务，必须指定标签以免误取消全部任务
+func (api *ApplicationInterface) cancelMatching(c *gin.Context) {
+	labels, err := labelFilter(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	ns, err := api.scope(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+
+	var fields validationErr
+	if len(labels) == 0 {
+		fields = append(fields, fieldError{Field: "label", Message: "is required"})
+	}
+	state := c.Query("state")
+	switch state {
+	case "", common.StatePending, common.StateRunning:
+	default:
+		fields = append(fields, fieldError{Field: "state", Message: "should be pending or running"})
+	}
+	if len(fields) > 0 {
+		api.respondErr(c, fields)
+		return
+	}
+	setAuditSummary(c, "state="+state+", labels="+formatLabels(labels))
+
+	ids, err := api.tasks.CancelMatching(c.Request.Context(), common.ListOptions{Namespace: ns, State: state, Labels: labels})
+	setAuditTarget(c, strings.Join(ids, ","))
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tasksCancelled{IDs: ids})
+}
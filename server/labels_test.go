@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// labelTasklist 记录批量取消的条件
+type labelTasklist struct {
+	common.Tasklist
+	opts []common.ListOptions
+}
+
+func (list *labelTasklist) CancelMatching(ctx context.Context, opts common.ListOptions) ([]string, error) {
+	list.opts = append(list.opts, opts)
+	return []string{"3", "5"}, nil
+}
+
+func TestCancelMatching(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	list := &labelTasklist{}
+	api := &ApplicationInterface{tasks: list}
+	router := gin.New()
+	router.POST("/tasks/cancel", api.cancelMatching)
+
+	post := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tasks/cancel"+query, nil))
+		return w
+	}
+
+	for _, bad := range []string{"", "?state=pending", "?label=target", "?label=target=ch-prod&state=done"} {
+		if w := post(bad); w.Code != http.StatusBadRequest {
+			t.Errorf("%q should be rejected, got %d %s", bad, w.Code, w.Body)
+		}
+	}
+	if len(list.opts) != 0 {
+		t.Fatalf("rejected requests should cancel nothing, got %+v", list.opts)
+	}
+
+	w := post("?state=pending&label=target=ch-prod&label=team=data")
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel should succeed, got %d %s", w.Code, w.Body)
+	}
+	var cancelled tasksCancelled
+	json.Unmarshal(w.Body.Bytes(), &cancelled)
+	if len(cancelled.IDs) != 2 {
+		t.Errorf("cancelled ids should be returned, got %s", w.Body)
+	}
+
+	opts := list.opts[0]
+	if opts.State != common.StatePending || opts.Labels["target"] != "ch-prod" || opts.Labels["team"] != "data" {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+}
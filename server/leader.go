@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// leadershipName 所有服务器竞选同一个领导者
+const leadershipName = "clams"
+
+// duty 只在领导者上运行的循环，ctx结束时返回
+type duty struct {
+	name string
+	loop func(context.Context)
+}
+
+// leader 参与选举，当选期间运行登记的循环，失去领导权时停止它们，由新的领导者接替
+type leader struct {
+	tasks     common.Tasklist
+	candidate string
+	duties    []duty
+	running   chan struct{}
+}
+
+// newLeader 创建选举者，登记循环后调用start
+func newLeader(tasks common.Tasklist) *leader {
+	hostname, _ := os.Hostname()
+	return &leader{
+		tasks:     tasks,
+		candidate: hostname + ":" + strconv.Itoa(os.Getpid()),
+		running:   make(chan struct{}),
+	}
+}
+
+// register 登记只在领导者上运行的循环，须在start之前调用
+func (l *leader) register(name string, loop func(context.Context)) {
+	l.duties = append(l.duties, duty{name: name, loop: loop})
+}
+
+// start 开始竞选，没有登记任何循环时不参选
+func (l *leader) start(ctx context.Context) {
+	if len(l.duties) == 0 {
+		close(l.running)
+		return
+	}
+	go l.campaign(ctx)
+}
+
+// wait 等待选举退出
+func (l *leader) wait() chan struct{} {
+	return l.running
+}
+
+// campaign 反复竞选，直到ctx结束
+func (l *leader) campaign(ctx context.Context) {
+	defer close(l.running)
+
+	for {
+		leadership, err := l.tasks.Elect(ctx, leadershipName, l.candidate)
+		if err == nil {
+			// ctx已结束时也要经lead让出领导权
+			l.lead(ctx, leadership)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Error().Str("mod", "leader").Msgf("elect: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+// lead 运行所有循环，直到失去领导权或ctx结束，等它们都退出后让出领导权
+func (l *leader) lead(ctx context.Context, leadership common.Leadership) {
+	log.Info().Str("mod", "leader").Msgf("%s is elected", l.candidate)
+
+	dutyCtx, cancel := context.WithCancel(ctx)
+	var group sync.WaitGroup
+	for _, d := range l.duties {
+		group.Add(1)
+		go func(d duty) {
+			defer group.Done()
+			log.Debug().Str("mod", "leader").Msgf("start %s", d.name)
+			d.loop(dutyCtx)
+		}(d)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-leadership.Lost():
+		log.Warn().Str("mod", "leader").Msgf("%s lost leadership", l.candidate)
+	}
+	cancel()
+	group.Wait()
+
+	resignCtx, cancelResign := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelResign()
+	if err := leadership.Resign(resignCtx); err != nil {
+		log.Error().Str("mod", "leader").Msgf("resign: %v", err)
+	}
+}
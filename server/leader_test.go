@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// electTasklist 同一时间只有一个领导者
+type electTasklist struct {
+	common.Tasklist
+	seat    chan struct{}
+	lock    sync.Mutex
+	current *seatLeadership
+}
+
+func (list *electTasklist) Elect(ctx context.Context, name, candidate string) (common.Leadership, error) {
+	select {
+	case list.seat <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	leadership := &seatLeadership{seat: list.seat, lost: make(chan struct{})}
+	list.lock.Lock()
+	list.current = leadership
+	list.lock.Unlock()
+	return leadership, nil
+}
+
+// depose 使当前领导者失去领导权
+func (list *electTasklist) depose() {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.current.once.Do(func() { close(list.current.lost) })
+}
+
+type seatLeadership struct {
+	seat     chan struct{}
+	lost     chan struct{}
+	once     sync.Once
+	resigned sync.Once
+}
+
+func (l *seatLeadership) Lost() chan struct{} {
+	return l.lost
+}
+
+func (l *seatLeadership) Resign(ctx context.Context) error {
+	l.once.Do(func() { close(l.lost) })
+	l.resigned.Do(func() { <-l.seat })
+	return nil
+}
+
+func TestLeaderRunsDutiesOnOneServer(t *testing.T) {
+	list := &electTasklist{seat: make(chan struct{}, 1)}
+	started := make(chan string, 4)
+
+	candidate := func(name string) (*leader, context.CancelFunc) {
+		l := newLeader(list)
+		l.register("record", func(ctx context.Context) {
+			started <- name
+			<-ctx.Done()
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		l.start(ctx)
+		return l, cancel
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-started:
+			if got != want {
+				t.Fatalf("duty should run on %s, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("duty should run on %s", want)
+		}
+	}
+
+	a, cancelA := candidate("a")
+	expect("a")
+	b, cancelB := candidate("b")
+	defer func() {
+		cancelB()
+		<-b.wait()
+	}()
+
+	select {
+	case got := <-started:
+		t.Fatalf("duty should not run on two servers, got %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancelA()
+	<-a.wait()
+	expect("b")
+
+	list.depose()
+	expect("b")
+}
+
+func TestLeaderWithoutDuties(t *testing.T) {
+	l := newLeader(&electTasklist{})
+	l.start(context.Background())
+	select {
+	case <-l.wait():
+	case <-time.After(time.Second):
+		t.Fatal("leader without duties should not campaign")
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/turnon/clams/secret"
 	"github.com/turnon/clams/tasklist"
 
 	"gopkg.in/yaml.v3"
@@ -15,15 +16,24 @@ import (
 
 // config 服务器配置
 type config struct {
-	Tasklist map[string]any `yaml:"tasklist"`
-	Workers  int            `yaml:"workers"`
-	Port     int            `yaml:"port"`
+	Tasklist  map[string]any    `yaml:"tasklist"`
+	Workers   int               `yaml:"workers"`
+	Port      int               `yaml:"port"`
+	Secrets   secret.Config     `yaml:"secrets"`
+	Tokens    map[string]string `yaml:"tokens"`
+	Retention retentionConfig   `yaml:"retention"`
+	Isolation isolationConfig   `yaml:"isolation"`
+	Schedule  scheduleConfig    `yaml:"schedule"`
+	Deadlines deadlineConfig    `yaml:"deadlines"`
+
+	Namespaces map[string]namespaceConfig `yaml:"namespaces"`
 }
 
 // mainServer 主服务器
 type mainServer struct {
-	cfg     *config
-	anchors string
+	cfg        *config
+	cfgPath    string
+	anchorPath string
 }
 
 // subordinate 从服务器
@@ -31,25 +41,45 @@ type subordinate interface {
 	wait() chan struct{}
 }
 
-// Run 根据配置启动服务器
-func Run(anchors string, cfgPath string) {
-	bytesArr, err := os.ReadFile(cfgPath)
+// Run 根据配置启动服务器，收到SIGHUP时重新加载配置和锚点文件
+func Run(anchorPath string, cfgPath string) {
+	cfg, err := loadConfig(cfgPath)
 	if err != nil {
 		panic(err)
 	}
 
-	var cfg config
-	err = yaml.Unmarshal(bytesArr, &cfg)
+	srv := mainServer{cfg: cfg, cfgPath: cfgPath, anchorPath: anchorPath}
+	<-srv.run()
+}
+
+// loadConfig 读取服务器配置
+func loadConfig(cfgPath string) (*config, error) {
+	bytesArr, err := os.ReadFile(cfgPath)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	var cfg config
+	if err := yaml.Unmarshal(bytesArr, &cfg); err != nil {
+		return nil, err
 	}
 
 	if cfg.Workers <= 0 {
 		cfg.Workers = 1
 	}
+	return &cfg, nil
+}
 
-	srv := mainServer{cfg: &cfg, anchors: anchors}
-	<-srv.run()
+// loadAnchorFile 读取锚点文件
+func loadAnchorFile(anchorPath string) (string, error) {
+	if anchorPath == "" {
+		return "", nil
+	}
+	bytesArr, err := os.ReadFile(anchorPath)
+	if err != nil {
+		return "", err
+	}
+	return string(bytesArr), nil
 }
 
 // run 运行主服务器和从服务器
@@ -65,12 +95,69 @@ func (srv *mainServer) run() chan struct{} {
 		return ch
 	}
 
+	// 加载锚点
+	base, err := loadAnchorFile(srv.anchorPath)
+	if err != nil {
+		log.Error().Str("mod", "server").Msgf("loadAnchorFile err: %v", err)
+		close(ch)
+		return ch
+	}
+	nss, err := newNamespaces(sigCtx, base, srv.cfg.Namespaces, srv.cfg.Tokens, tasks)
+	if err != nil {
+		log.Error().Str("mod", "server").Msgf("newNamespaces err: %v", err)
+		close(ch)
+		return ch
+	}
+
+	// 密钥来源
+	secrets, err := secret.NewResolver(srv.cfg.Secrets)
+	if err != nil {
+		log.Error().Str("mod", "server").Msgf("NewResolver err: %v", err)
+		close(ch)
+		return ch
+	}
+
+	// 执行时间的限制
+	schedule, err := newRunSchedule(srv.cfg.Schedule)
+	if err != nil {
+		log.Error().Str("mod", "server").Msgf("newRunSchedule err: %v", err)
+		close(ch)
+		return ch
+	}
+
 	// 运行从服务器
-	children := []subordinate{
-		newApi(sigCtx, srv.cfg.Port, tasks),
-		newWorkteam(sigCtx, tasks, srv.cfg.Workers, srv.anchors),
+	team := newWorkteam(sigCtx, tasks, srv.cfg.Workers, nss, secrets, srv.cfg.Isolation, schedule, newTaskMetrics())
+	api := newApi(sigCtx, srv.cfg.Port, srv.cfg.Tokens, tasks, nss, team)
+	lead := newLeader(tasks)
+	children := []subordinate{api, team, lead}
+
+	// 清理历史任务
+	if srv.cfg.Retention.enabled() {
+		purger, err := newRetention(srv.cfg.Retention, tasks)
+		if err != nil {
+			log.Error().Str("mod", "server").Msgf("newRetention err: %v", err)
+		} else {
+			lead.register("retention", purger.loop)
+		}
+	}
+
+	// 标记逾期的任务
+	checker, err := newOverdueChecker(srv.cfg.Deadlines, tasks)
+	if err != nil {
+		log.Error().Str("mod", "server").Msgf("newOverdueChecker err: %v", err)
+	} else {
+		lead.register("deadlines", checker.loop)
 	}
 
+	// 放回失联worker的任务
+	lead.register("orphans", requeueOrphans(tasks))
+
+	// 只在一个服务器上运行的循环
+	lead.start(sigCtx)
+
+	// 收到SIGHUP时重新加载
+	go srv.watchReload(sigCtx, nss, team, api)
+
 	// 等待从服务器退出
 	go func() {
 		for _, child := range children {
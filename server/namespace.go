@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/secret"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// namespaceNamePattern 命名空间名，和密钥引用中的命名空间一致
+var namespaceNamePattern = regexp.MustCompile(`^` + secret.NamespacePattern + `$`)
+
+// maxNamespaceName 命名空间名的最大长度
+const maxNamespaceName = 63
+
+// quotaGroupPrefix 命名空间的worker配额所用的并发组，请求中不能使用
+const quotaGroupPrefix = "namespace:"
+
+// errForbidden 调用者无权访问所请求的命名空间
+var errForbidden = errors.New("forbidden")
+
+// namespaceConfig 一个命名空间的配置，tokens只能访问该命名空间；
+// workers为整个集群中同时运行的任务数上限，concurrency为各并发组上限的上限
+type namespaceConfig struct {
+	Tokens      map[string]string `yaml:"tokens"`
+	Anchors     string            `yaml:"anchors"`
+	Workers     int               `yaml:"workers"`
+	Concurrency map[string]int    `yaml:"concurrency"`
+}
+
+// namespace 生效中的命名空间
+type namespace struct {
+	name        string
+	tokens      map[string]string
+	workers     int
+	concurrency map[string]int
+}
+
+// apply 去掉请求中保留的并发组，校验并发组上限和引用的密钥并加上worker配额
+func (ns *namespace) apply(req *taskRequest) error {
+	var fields validationErr
+	if foreign := secret.Foreign(req.Description, secretScope(ns.name)); len(foreign) > 0 {
+		fields = append(fields, fieldError{Field: "description", Message: fmt.Sprintf("secrets %s do not belong to namespace %s", strings.Join(foreign, ", "), ns.name)})
+	}
+	groups := make([]common.ConcurrencyGroup, 0, len(req.Concurrency)+1)
+	for i, group := range req.Concurrency {
+		if strings.HasPrefix(group.Key, quotaGroupPrefix) {
+			continue
+		}
+		if max, ok := ns.concurrency[group.Key]; ok && group.Limit > max {
+			fields = append(fields, fieldError{Field: fmt.Sprintf("concurrency[%d].limit", i), Message: fmt.Sprintf("should be at most %d in namespace %s", max, ns.name)})
+		}
+		groups = append(groups, group)
+	}
+	if ns.workers > 0 {
+		groups = append(groups, common.ConcurrencyGroup{Key: quotaGroupPrefix + ns.name, Limit: ns.workers})
+	}
+	if len(groups) == 0 {
+		groups = nil
+	}
+	req.Concurrency = groups
+	if len(fields) > 0 {
+		return fields
+	}
+	return nil
+}
+
+// secretScope 命名空间的任务能引用的密钥前缀，默认命名空间只能引用不带前缀的密钥
+func secretScope(namespace string) string {
+	if namespace == common.DefaultNamespace {
+		return ""
+	}
+	return namespace
+}
+
+// namespaces 配置的命名空间及各命名空间的锚点，未配置的命名空间只能执行已有的任务
+type namespaces struct {
+	tasks common.Tasklist
+
+	lock    sync.RWMutex
+	byName  map[string]*namespace
+	anchors map[string]*anchorSet
+}
+
+// newNamespaces 加载各命名空间的锚点并监听变化，base为默认命名空间的文件锚点，global为全局tokens
+func newNamespaces(ctx context.Context, base string, cfg map[string]namespaceConfig, global map[string]string, tasks common.Tasklist) (*namespaces, error) {
+	nss := &namespaces{tasks: tasks, byName: map[string]*namespace{}, anchors: map[string]*anchorSet{}}
+	if err := nss.set(ctx, base, cfg, global); err != nil {
+		return nil, err
+	}
+	go nss.watch(ctx)
+	return nss, nil
+}
+
+// set 替换命名空间的配置并重新读取各命名空间的锚点文件，出错时不做改变；命名空间的token不能与全局tokens相同，否则会被当作全局调用者
+func (nss *namespaces) set(ctx context.Context, base string, cfg map[string]namespaceConfig, global map[string]string) error {
+	byName := map[string]*namespace{common.DefaultNamespace: {name: common.DefaultNamespace}}
+	bases := map[string]string{common.DefaultNamespace: base}
+	tokens := map[string]string{}
+	for caller, token := range global {
+		tokens[token] = "tokens." + caller
+	}
+
+	for name, c := range cfg {
+		if !namespaceNamePattern.MatchString(name) || len(name) > maxNamespaceName {
+			return fmt.Errorf("namespaces.%s: name should be lowercase letters and digits joined by single -, at most %d", name, maxNamespaceName)
+		}
+		if c.Workers < 0 {
+			return fmt.Errorf("namespaces.%s.workers: should not be negative", name)
+		}
+		for key, limit := range c.Concurrency {
+			if limit < 1 {
+				return fmt.Errorf("namespaces.%s.concurrency.%s: should be positive", name, key)
+			}
+		}
+		for caller, token := range c.Tokens {
+			if other, dup := tokens[token]; dup {
+				return fmt.Errorf("namespaces.%s.tokens.%s: same token as %s", name, caller, other)
+			}
+			tokens[token] = caller + "@" + name
+		}
+		if c.Anchors != "" {
+			if name == common.DefaultNamespace {
+				return fmt.Errorf("namespaces.%s.anchors: default namespace uses the anchor file given by -anchor", name)
+			}
+			content, err := loadAnchorFile(c.Anchors)
+			if err != nil {
+				return fmt.Errorf("namespaces.%s.anchors: %w", name, err)
+			}
+			bases[name] = content
+		}
+		byName[name] = &namespace{name: name, tokens: c.Tokens, workers: c.Workers, concurrency: c.Concurrency}
+	}
+
+	nss.lock.RLock()
+	sets := make(map[string]*anchorSet, len(nss.anchors))
+	for name, set := range nss.anchors {
+		sets[name] = set
+	}
+	nss.lock.RUnlock()
+
+	// 先合成所有新的锚点，都没有问题再替换
+	for name := range byName {
+		if _, ok := sets[name]; !ok {
+			sets[name] = &anchorSet{namespace: name, tasks: nss.tasks}
+		}
+	}
+	for name := range sets {
+		anchors, err := nss.tasks.ListAnchors(ctx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := composeAnchors(bases[name], anchors.Anchors); err != nil {
+			return fmt.Errorf("anchors of namespace %s: %w", name, err)
+		}
+	}
+	for name, set := range sets {
+		if err := set.setBase(ctx, bases[name]); err != nil {
+			return err
+		}
+	}
+
+	nss.lock.Lock()
+	defer nss.lock.Unlock()
+	nss.byName = byName
+	nss.anchors = sets
+	return nil
+}
+
+// get 配置的命名空间，默认的命名空间总是存在
+func (nss *namespaces) get(name string) (*namespace, bool) {
+	if nss == nil {
+		if name == common.DefaultNamespace {
+			return &namespace{name: name}, true
+		}
+		return nil, false
+	}
+	nss.lock.RLock()
+	defer nss.lock.RUnlock()
+	ns, ok := nss.byName[name]
+	return ns, ok
+}
+
+// anchorSet 命名空间的锚点，未配置的命名空间（如已从配置中移除）只有任务列表中的锚点
+func (nss *namespaces) anchorSet(ctx context.Context, name string) (*anchorSet, error) {
+	if name == "" {
+		name = common.DefaultNamespace
+	}
+
+	nss.lock.RLock()
+	set, ok := nss.anchors[name]
+	nss.lock.RUnlock()
+	if ok {
+		return set, nil
+	}
+
+	set = &anchorSet{namespace: name, tasks: nss.tasks}
+	if err := set.reload(ctx); err != nil {
+		return nil, err
+	}
+
+	nss.lock.Lock()
+	defer nss.lock.Unlock()
+	if existing, ok := nss.anchors[name]; ok {
+		return existing, nil
+	}
+	nss.anchors[name] = set
+	return set, nil
+}
+
+// caller 按token找出命名空间的调用者，名字为 caller@namespace
+func (nss *namespaces) caller(token string) (string, string, bool) {
+	if nss == nil {
+		return "", "", false
+	}
+	nss.lock.RLock()
+	defer nss.lock.RUnlock()
+	for name, ns := range nss.byName {
+		for caller, expected := range ns.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+				return caller + "@" + name, name, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// hasTokens 是否有命名空间配置了tokens
+func (nss *namespaces) hasTokens() bool {
+	if nss == nil {
+		return false
+	}
+	nss.lock.RLock()
+	defer nss.lock.RUnlock()
+	for _, ns := range nss.byName {
+		if len(ns.tokens) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// watch 任务列表中的锚点变化时重新加载所有命名空间的锚点
+func (nss *namespaces) watch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-nss.tasks.AnchorsChanged():
+			if !ok {
+				return
+			}
+			nss.lock.RLock()
+			sets := make([]*anchorSet, 0, len(nss.anchors))
+			for _, set := range nss.anchors {
+				sets = append(sets, set)
+			}
+			nss.lock.RUnlock()
+
+			for _, set := range sets {
+				if err := set.reload(ctx); err != nil {
+					log.Error().Str("mod", "anchors").Str("namespace", set.namespace).Err(err).Send()
+					continue
+				}
+				_, revision := set.current()
+				log.Info().Str("mod", "anchors").Str("namespace", set.namespace).Int("revision", revision).Msg("reloaded")
+			}
+		}
+	}
+}
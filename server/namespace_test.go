@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// namespaceTasklist 记下写入的任务和列出任务的条件，任务1在team-a中
+type namespaceTasklist struct {
+	common.Tasklist
+	written []common.RawTask
+	opts    common.ListOptions
+}
+
+func (list *namespaceTasklist) Write(ctx context.Context, rawTask common.RawTask) (string, error) {
+	list.written = append(list.written, rawTask)
+	return "1", nil
+}
+
+func (list *namespaceTasklist) List(ctx context.Context, opts common.ListOptions) ([]common.TaskInfo, error) {
+	list.opts = opts
+	return []common.TaskInfo{}, nil
+}
+
+func (list *namespaceTasklist) Status(ctx context.Context, namespace string, id string) (common.TaskInfo, error) {
+	if namespace != "" && namespace != "team-a" {
+		return common.TaskInfo{}, common.ErrNotFound
+	}
+	return common.TaskInfo{ID: id, Namespace: "team-a"}, nil
+}
+
+func (list *namespaceTasklist) ListAnchors(ctx context.Context, namespace string) (common.AnchorSet, error) {
+	return common.AnchorSet{}, nil
+}
+
+func (list *namespaceTasklist) AnchorsChanged() chan struct{} {
+	return nil
+}
+
+func TestNamespaceScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	list := &namespaceTasklist{}
+	nss, err := newNamespaces(context.Background(), "", map[string]namespaceConfig{
+		"team-a": {Tokens: map[string]string{"alice": "ta"}, Workers: 2, Concurrency: map[string]int{"db": 3}},
+		"team-b": {Tokens: map[string]string{"bob": "tb"}},
+	}, map[string]string{"ops": "admin"}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &ApplicationInterface{tasks: list, namespaces: nss, tokens: map[string]string{"ops": "admin"}}
+	router := gin.New()
+	router.Use(api.authenticate())
+	router.GET("/tasks", api.listTasks)
+	router.POST("/tasks", api.postTasks)
+	router.GET("/tasks/:id/status", api.getTaskStatus)
+	router.GET("/audit", api.getAudit)
+	router.GET("/workers", api.getWorkers)
+
+	send := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("ta", http.MethodGet, "/tasks", ""); w.Code != http.StatusOK || list.opts.Namespace != "team-a" {
+		t.Errorf("caller of a namespace should only list its tasks, got %d %+v", w.Code, list.opts)
+	}
+	if w := send("ta", http.MethodGet, "/tasks?namespace=team-b", ""); w.Code != http.StatusForbidden {
+		t.Errorf("other namespaces should be forbidden, got %d", w.Code)
+	}
+	if w := send("admin", http.MethodGet, "/tasks", ""); w.Code != http.StatusOK || list.opts.Namespace != "" {
+		t.Errorf("global caller should list all namespaces, got %d %+v", w.Code, list.opts)
+	}
+	if w := send("tb", http.MethodGet, "/tasks/1/status", ""); w.Code != http.StatusNotFound {
+		t.Errorf("task of another namespace should be not found, got %d", w.Code)
+	}
+	if w := send("tb", http.MethodGet, "/audit", ""); w.Code != http.StatusForbidden {
+		t.Errorf("audit should be only for global callers, got %d", w.Code)
+	}
+	if w := send("ta", http.MethodGet, "/workers", ""); w.Code != http.StatusForbidden {
+		t.Errorf("workers should be only for global callers, got %d", w.Code)
+	}
+
+	w := send("ta", http.MethodPost, "/tasks", `{"description": "input: {}", "concurrency": [{"key": "db", "limit": 4}]}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "at most 3") {
+		t.Errorf("limit above the cap of the namespace should be rejected, got %d %s", w.Code, w.Body)
+	}
+	w = send("ta", http.MethodPost, "/tasks", `{"description": "input: {}", "concurrency": [{"key": "namespace:team-b", "limit": 9}]}`)
+	if w.Code != http.StatusCreated || len(list.written) != 1 {
+		t.Fatalf("task should be created, got %d %s", w.Code, w.Body)
+	}
+	created := list.written[0]
+	if created.Namespace != "team-a" || len(created.Concurrency) != 1 || created.Concurrency[0] != (common.ConcurrencyGroup{Key: "namespace:team-a", Limit: 2}) {
+		t.Errorf("task should be in the namespace with its worker quota only, got %+v", created)
+	}
+	if w := send("admin", http.MethodPost, "/tasks?namespace=team-c", `{"description": "input: {}"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unconfigured namespace should be rejected, got %d", w.Code)
+	}
+
+	w = send("ta", http.MethodPost, "/tasks", `{"description": "input:\n  sql: {dsn: \"${secret:team-b/db}\"}"}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "team-b/db") || len(list.written) != 1 {
+		t.Errorf("secret of another namespace should be rejected, got %d %s", w.Code, w.Body)
+	}
+	if w := send("ta", http.MethodPost, "/tasks", `{"description": "input:\n  sql: {dsn: \"${secret:shared}\"}"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("secret outside the namespace should be rejected, got %d %s", w.Code, w.Body)
+	}
+	if w := send("ta", http.MethodPost, "/tasks", `{"description": "input:\n  sql: {dsn: \"${secret:team-a/db}\"}"}`); w.Code != http.StatusCreated {
+		t.Errorf("secret of the namespace should be accepted, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestNamespacesConfig(t *testing.T) {
+	list := &namespaceTasklist{}
+	anchors := filepath.Join(t.TempDir(), "anchors.yml")
+	os.WriteFile(anchors, []byte("db: &db\n    dsn: team-a\n"), 0o644)
+
+	nss, err := newNamespaces(context.Background(), "db: &db\n    dsn: default\n", map[string]namespaceConfig{"team-a": {Anchors: anchors}}, nil, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{common.DefaultNamespace: "dsn: default", "team-a": "dsn: team-a", "gone": ""} {
+		set, err := nss.anchorSet(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if composed, _ := set.current(); !strings.Contains(composed, want) {
+			t.Errorf("anchors of %s should come from its own file, got %q", name, composed)
+		}
+	}
+
+	for _, bad := range []map[string]namespaceConfig{
+		{"Team A": {}},
+		{"team_a": {}},
+		{"team--a": {}},
+		{"team-": {}},
+		{"default": {Anchors: anchors}},
+		{"team-a": {Tokens: map[string]string{"a": "x"}}, "team-b": {Tokens: map[string]string{"b": "x"}}},
+		{"team-a": {Concurrency: map[string]int{"db": 0}}},
+	} {
+		if err := nss.set(context.Background(), "", bad, nil); err == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+	// 与全局token相同的命名空间token会以全局调用者登录
+	if err := nss.set(context.Background(), "", map[string]namespaceConfig{"team-a": {Tokens: map[string]string{"a": "admin"}}}, map[string]string{"ops": "admin"}); err == nil {
+		t.Errorf("namespace token same as a global token should be rejected")
+	}
+	if _, ok := nss.get("team-a"); !ok {
+		t.Errorf("rejected config should change nothing")
+	}
+}
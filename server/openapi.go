@@ -0,0 +1,198 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOpenapi 返回由路由生成的openapi文档
+func (api *ApplicationInterface) getOpenapi(c *gin.Context) {
+	c.JSON(http.StatusOK, openapiDocument(api.routes()))
+}
+
+// openapiDocument 由路由生成openapi文档
+func openapiDocument(routes []route) map[string]any {
+	paths := map[string]any{}
+	for _, r := range routes {
+		path, params := openapiPath(r.path)
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(r.method)] = openapiOperation(r, params)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": "clams", "version": "v1"},
+		"servers": []any{map[string]any{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{map[string]any{"bearer": []any{}}},
+	}
+}
+
+// openapiPath 把 :id 转为 {id}
+func openapiPath(ginPath string) (string, []string) {
+	segments := strings.Split(ginPath, "/")
+	params := []string{}
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// openapiOperation 生成一个操作的文档
+func openapiOperation(r route, pathParams []string) map[string]any {
+	op := map[string]any{"summary": r.summary}
+
+	parameters := []any{}
+	for _, p := range pathParams {
+		parameters = append(parameters, map[string]any{
+			"name": p, "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+		})
+	}
+	for _, q := range r.query {
+		parameters = append(parameters, map[string]any{
+			"name": q, "in": "query", "schema": map[string]any{"type": "string"},
+		})
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	content := map[string]any{}
+	if r.request != nil {
+		content[gin.MIMEJSON] = map[string]any{"schema": schemaOf(reflect.TypeOf(r.request))}
+	}
+	if r.multipart {
+		content[gin.MIMEMultipartPOSTForm] = map[string]any{"schema": multipartSchema(reflect.TypeOf(r.request))}
+	}
+	if r.rawBody != "" {
+		content[r.rawBody] = map[string]any{"schema": map[string]any{"type": "string"}}
+	}
+	if len(content) > 0 {
+		op["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	if r.produces != "" {
+		success["content"] = map[string]any{r.produces: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	} else if status != http.StatusNoContent {
+		var schema map[string]any
+		if r.response != nil {
+			schema = schemaOf(reflect.TypeOf(r.response))
+		} else {
+			schema = map[string]any{"type": "object"}
+		}
+		success["content"] = map[string]any{gin.MIMEJSON: map[string]any{"schema": schema}}
+	}
+
+	errSchema := map[string]any{gin.MIMEJSON: map[string]any{"schema": schemaOf(reflect.TypeOf(errorResponse{}))}}
+	op["responses"] = map[string]any{
+		strconv.Itoa(status): success,
+		"default":            map[string]any{"description": "error", "content": errSchema},
+	}
+	return op
+}
+
+// multipartSchema 表单中任务描述以file字段上传，列表字段可重复；批量提交时file为归档，其余字段对所有任务生效
+func multipartSchema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	props := map[string]any{
+		"file": map[string]any{"type": "string", "format": "binary"},
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" || name == "description" {
+			continue
+		}
+		if t.Field(i).Type.Kind() == reflect.Slice {
+			props[name] = map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+		} else {
+			props[name] = map[string]any{"type": "string"}
+		}
+	}
+	return map[string]any{"type": "object", "required": []string{"file"}, "properties": props}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf 由go类型生成json schema
+func schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonName(field)
+			if name == "" {
+				continue
+			}
+			prop := schemaOf(field.Type)
+			if doc := field.Tag.Get("doc"); doc != "" {
+				prop["description"] = doc
+			}
+			props[name] = prop
+		}
+		return map[string]any{"type": "object", "properties": props}
+	default:
+		return map[string]any{}
+	}
+}
+
+// jsonName 字段在json中的名字，不导出或忽略时为空
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
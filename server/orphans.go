@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// requeueOrphans 定期把失联worker的任务放回待执行，释放其并发组和命名空间配额，由领导者运行
+func requeueOrphans(tasks common.Tasklist) func(context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(workerHeartbeat)
+		defer ticker.Stop()
+
+		for {
+			ids, err := tasks.RequeueOrphans(ctx, 3*workerHeartbeat)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Str("mod", "orphans").Msgf("requeue orphans: %v", err)
+			}
+			if len(ids) > 0 {
+				log.Warn().Str("mod", "orphans").Msgf("requeued tasks of lost workers: %s", strings.Join(ids, ", "))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// orphanTasklist 记下放回失联任务时给的超时
+type orphanTasklist struct {
+	common.Tasklist
+	timeouts chan time.Duration
+}
+
+func (list *orphanTasklist) RequeueOrphans(ctx context.Context, timeout time.Duration) ([]string, error) {
+	list.timeouts <- timeout
+	return []string{"3"}, nil
+}
+
+func TestRequeueOrphans(t *testing.T) {
+	list := &orphanTasklist{timeouts: make(chan time.Duration, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		requeueOrphans(list)(ctx)
+		close(done)
+	}()
+
+	select {
+	case timeout := <-list.timeouts:
+		if timeout != 3*workerHeartbeat {
+			t.Errorf("workers missing three heartbeats should be lost, got %v", timeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("orphans should be requeued once the duty starts")
+	}
+	cancel()
+	<-done
+}
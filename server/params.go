@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/benthosdev/benthos/v4/public/bloblang"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/tasklist/common"
+	"gopkg.in/yaml.v3"
+)
+
+// taskIDMetadata 每条消息上记录任务id的metadata
+const taskIDMetadata = "clams_task_id"
+
+// taskMetadataProcessor 加到每个任务input上的processor，为消息记录任务id
+const taskMetadataProcessor = "clams_task_metadata"
+
+// paramNamePattern 参数名须能用于 ${name} 插值
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// taskEnvLookup 配置中的 ${name} 先取任务参数，再取环境变量
+func taskEnvLookup(task common.Task, lookupEnv func(string) (string, bool)) func(string) (string, bool) {
+	params := task.Params()
+	return func(name string) (string, bool) {
+		if value, ok := params[name]; ok {
+			return value, true
+		}
+		return lookupEnv(name)
+	}
+}
+
+// taskBloblang 在全局bloblang环境上加入task_id()和task_param("name")
+func taskBloblang(task common.Task) (*bloblang.Environment, error) {
+	env := bloblang.GlobalEnvironment().WithoutFunctions()
+
+	err := env.RegisterFunctionV2(
+		"task_id",
+		bloblang.NewPluginSpec().Description("Returns the id of the running task."),
+		func(args *bloblang.ParsedParams) (bloblang.Function, error) {
+			return func() (any, error) {
+				return task.ID(), nil
+			}, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = env.RegisterFunctionV2(
+		"task_param",
+		bloblang.NewPluginSpec().
+			Description("Returns a parameter of the running task, or the default when it is not given.").
+			Param(bloblang.NewStringParam("name")).
+			Param(bloblang.NewAnyParam("default").Optional()),
+		func(args *bloblang.ParsedParams) (bloblang.Function, error) {
+			name, err := args.GetString("name")
+			if err != nil {
+				return nil, err
+			}
+			fallback, _ := args.Get("default")
+
+			return func() (any, error) {
+				if value, ok := task.Params()[name]; ok {
+					return value, nil
+				}
+				if fallback != nil {
+					return fallback, nil
+				}
+				return nil, fmt.Errorf("task param %q is not given", name)
+			}, nil
+		},
+	)
+	return env, err
+}
+
+// taskMetadata 为消息记录任务id
+type taskMetadata struct {
+	id string
+}
+
+func (proc *taskMetadata) Process(ctx context.Context, msg *service.Message) (service.MessageBatch, error) {
+	msg.MetaSet(taskIDMetadata, proc.id)
+	return service.MessageBatch{msg}, nil
+}
+
+func (proc *taskMetadata) Close(ctx context.Context) error {
+	return nil
+}
+
+// withTaskMetadata 在input的processors最前面加上记录任务id的processor，没有input时原样返回
+func withTaskMetadata(text string) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return "", err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return text, nil
+	}
+
+	input := mappingValue(doc.Content[0], "input")
+	if input == nil || input.Kind != yaml.MappingNode {
+		return text, nil
+	}
+
+	processor := &yaml.Node{Kind: yaml.MappingNode}
+	processor.Content = []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: taskMetadataProcessor},
+		{Kind: yaml.MappingNode, Style: yaml.FlowStyle},
+	}
+
+	processors := mappingValue(input, "processors")
+	if processors == nil {
+		processors = &yaml.Node{Kind: yaml.SequenceNode}
+		input.Content = append(input.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "processors"}, processors)
+	}
+	if processors.Kind != yaml.SequenceNode {
+		return text, nil
+	}
+	processors.Content = append([]*yaml.Node{processor}, processors.Content...)
+
+	out, err := yaml.Marshal(&doc)
+	return string(out), err
+}
+
+// mappingValue 取yaml mapping中key对应的值
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
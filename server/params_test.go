@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/secret"
+)
+
+// paramTask 带id和参数的任务
+type paramTask struct {
+	memoryTask
+	id     string
+	params map[string]string
+}
+
+func (task *paramTask) ID() string {
+	return task.id
+}
+
+func (task *paramTask) Params() map[string]string {
+	return task.params
+}
+
+func TestTaskBloblang(t *testing.T) {
+	task := &paramTask{id: "42", params: map[string]string{"table": "t1"}}
+	env, err := taskBloblang(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exec, err := env.Parse(`root = [task_id(), task_param("table"), task_param("missing", "d")]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := exec.Query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := got.([]any); values[0] != "42" || values[1] != "t1" || values[2] != "d" {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	exec, _ = env.Parse(`root = task_param("missing")`)
+	if _, err := exec.Query(nil); err == nil {
+		t.Error("missing param without default should fail")
+	}
+}
+
+func TestTaskEnvLookup(t *testing.T) {
+	t.Setenv("CLAMS_PARAM_TEST", "from env")
+	t.Setenv("CLAMS_SECRET_KEY", "master key")
+	resolver, err := secret.NewResolver(secret.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, _ := resolver.Resolve("input: {}", "")
+	lookup := taskEnvLookup(&paramTask{params: map[string]string{"table": "t1"}}, resolved.LookupEnv)
+
+	if value, ok := lookup("table"); !ok || value != "t1" {
+		t.Errorf("param should be looked up first, got %q", value)
+	}
+	if value, ok := lookup("CLAMS_PARAM_TEST"); !ok || value != "from env" {
+		t.Errorf("environment should be the fallback, got %q", value)
+	}
+	if value, ok := lookup("CLAMS_SECRET_KEY"); ok {
+		t.Errorf("secret key should not be visible to tasks, got %q", value)
+	}
+}
+
+func TestWithTaskMetadata(t *testing.T) {
+	text, err := withTaskMetadata("input:\n  generate: {}\n  processors:\n    - log: {}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "processors:\n        - "+taskMetadataProcessor+": {}\n        - log: {}") {
+		t.Errorf("task metadata processor should come first, got\n%s", text)
+	}
+
+	unchanged := "output:\n  drop: {}\n"
+	if text, _ := withTaskMetadata(unchanged); text != unchanged {
+		t.Errorf("pipeline without input should be unchanged, got\n%s", text)
+	}
+
+	batch, _ := (&taskMetadata{id: "42"}).Process(context.Background(), service.NewMessage([]byte("{}")))
+	if id, _ := batch[0].MetaGet(taskIDMetadata); id != "42" {
+		t.Errorf("message should carry the task id, got %q", id)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/guard"
+)
+
+// guardCacheYAML 加到每个任务上的panic报告缓存资源
+const guardCacheYAML = "label: " + guard.CacheName + "\n" + guard.CacheName + ": {}\n"
+
+// taskFailure 插件报告的第一个panic，报告后stream应停止
+type taskFailure struct {
+	once    sync.Once
+	failed  chan struct{}
+	message string
+}
+
+// newTaskFailure 创建
+func newTaskFailure() *taskFailure {
+	return &taskFailure{failed: make(chan struct{})}
+}
+
+// fail 记录panic，只保留第一个
+func (f *taskFailure) fail(message string) {
+	f.once.Do(func() {
+		f.message = message
+		close(f.failed)
+	})
+}
+
+// err 有panic时返回对应的错误
+func (f *taskFailure) err() error {
+	select {
+	case <-f.failed:
+		return errors.New(f.message)
+	default:
+		return nil
+	}
+}
+
+// guardCache 以缓存资源的形式接收插件的panic
+type guardCache struct {
+	failure *taskFailure
+}
+
+func (cache *guardCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, service.ErrKeyNotFound
+}
+
+func (cache *guardCache) Set(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
+	if key == guard.PanicKey {
+		cache.failure.fail(string(value))
+	}
+	return nil
+}
+
+func (cache *guardCache) Add(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
+	return cache.Set(ctx, key, value, ttl)
+}
+
+func (cache *guardCache) Delete(ctx context.Context, key string) error {
+	return nil
+}
+
+func (cache *guardCache) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/turnon/clams/guard"
+)
+
+func TestGuardCache(t *testing.T) {
+	failure := newTaskFailure()
+	cache := &guardCache{failure: failure}
+
+	if failure.err() != nil {
+		t.Fatal("task should not fail before any panic")
+	}
+
+	cache.Set(context.Background(), guard.PanicKey, []byte("panic: first"), nil)
+	cache.Set(context.Background(), guard.PanicKey, []byte("panic: second"), nil)
+
+	select {
+	case <-failure.failed:
+	default:
+		t.Fatal("panic should stop the stream")
+	}
+	if err := failure.err(); err == nil || err.Error() != "panic: first" {
+		t.Errorf("first panic should be kept, got %v", err)
+	}
+}
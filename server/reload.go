@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// watchReload 收到SIGHUP时重新读取配置和各命名空间的锚点文件
+func (srv *mainServer) watchReload(ctx context.Context, nss *namespaces, team *workteam, api *ApplicationInterface) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			srv.reload(ctx, nss, team, api)
+		}
+	}
+}
+
+// reload 应用新的配置，正在执行的任务不受影响；tasklist、port、secrets、retention、isolation、deadlines需要重启才生效
+func (srv *mainServer) reload(ctx context.Context, nss *namespaces, team *workteam, api *ApplicationInterface) {
+	cfg, err := loadConfig(srv.cfgPath)
+	if err != nil {
+		log.Error().Str("mod", "server").Msgf("reload config: %v", err)
+		return
+	}
+	base, err := loadAnchorFile(srv.anchorPath)
+	if err != nil {
+		log.Error().Str("mod", "server").Msgf("reload anchors: %v", err)
+		return
+	}
+
+	if err := nss.set(ctx, base, cfg.Namespaces, cfg.Tokens); err != nil {
+		log.Error().Str("mod", "server").Msgf("reload anchors: %v", err)
+		return
+	}
+
+	api.setTokens(cfg.Tokens)
+
+	if err := team.schedule.set(cfg.Schedule); err != nil {
+		log.Error().Str("mod", "server").Msgf("reload schedule: %v", err)
+	}
+
+	before := team.size()
+	team.resize(cfg.Workers)
+
+	for name, changed := range map[string]bool{
+		"tasklist":  !reflect.DeepEqual(cfg.Tasklist, srv.cfg.Tasklist),
+		"port":      cfg.Port != srv.cfg.Port,
+		"secrets":   cfg.Secrets != srv.cfg.Secrets,
+		"retention": !reflect.DeepEqual(cfg.Retention, srv.cfg.Retention),
+		"isolation": !reflect.DeepEqual(cfg.Isolation, srv.cfg.Isolation),
+		"deadlines": !reflect.DeepEqual(cfg.Deadlines, srv.cfg.Deadlines),
+	} {
+		if changed {
+			log.Warn().Str("mod", "server").Msgf("%s changed, takes effect after restart", name)
+		}
+	}
+
+	// 保留需要重启才生效的旧配置，以便下次比较
+	cfg.Tasklist, cfg.Port, cfg.Secrets, cfg.Retention, cfg.Isolation = srv.cfg.Tasklist, srv.cfg.Port, srv.cfg.Secrets, srv.cfg.Retention, srv.cfg.Isolation
+	cfg.Deadlines = srv.cfg.Deadlines
+	srv.cfg = cfg
+
+	log.Info().Str("mod", "server").Int("workers", cfg.Workers).Int("was", before).Msg("reloaded")
+}
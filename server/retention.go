@@ -0,0 +1,185 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/secret"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// retentionConfig 历史任务保留策略
+type retentionConfig struct {
+	Interval   string            `yaml:"interval"`
+	MaxAge     string            `yaml:"max_age"`
+	States     map[string]string `yaml:"states"`
+	ArchiveDir string            `yaml:"archive_dir"`
+	BatchSize  int               `yaml:"batch_size"`
+}
+
+// enabled 是否配置了保留期
+func (cfg retentionConfig) enabled() bool {
+	return cfg.MaxAge != "" || len(cfg.States) > 0
+}
+
+// policy 解析为清理策略
+func (cfg retentionConfig) policy() (time.Duration, common.PurgePolicy, error) {
+	policy := common.PurgePolicy{StateMaxAge: map[string]time.Duration{}, BatchSize: cfg.BatchSize}
+
+	interval := time.Hour
+	if cfg.Interval != "" {
+		d, err := parseRetention(cfg.Interval)
+		if err != nil {
+			return 0, policy, fmt.Errorf("retention.interval: %w", err)
+		}
+		interval = d
+	}
+
+	if cfg.MaxAge != "" {
+		d, err := parseRetention(cfg.MaxAge)
+		if err != nil {
+			return 0, policy, fmt.Errorf("retention.max_age: %w", err)
+		}
+		policy.MaxAge = d
+	}
+
+	for state, age := range cfg.States {
+		switch state {
+		case common.StateDone, common.StateError, common.StateCancelled:
+		default:
+			return 0, policy, fmt.Errorf("retention.states: %s is not a finished state", state)
+		}
+		d, err := parseRetention(age)
+		if err != nil {
+			return 0, policy, fmt.Errorf("retention.states.%s: %w", state, err)
+		}
+		policy.StateMaxAge[state] = d
+	}
+
+	return interval, policy, nil
+}
+
+// parseRetention 解析时长，除了time.ParseDuration的格式，还支持以d结尾的天数
+func parseRetention(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// retention 定期清理过期的历史任务
+type retention struct {
+	tasks      common.Tasklist
+	interval   time.Duration
+	policy     common.PurgePolicy
+	archiveDir string
+}
+
+// newRetention 创建清理，由领导者运行loop
+func newRetention(cfg retentionConfig, tasks common.Tasklist) (*retention, error) {
+	interval, policy, err := cfg.policy()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ArchiveDir != "" {
+		if err := os.MkdirAll(cfg.ArchiveDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &retention{
+		tasks:      tasks,
+		interval:   interval,
+		policy:     policy,
+		archiveDir: cfg.ArchiveDir,
+	}
+	return r, nil
+}
+
+// loop 按间隔清理，直到ctx结束
+func (r *retention) loop(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge 清理一次
+func (r *retention) purge(ctx context.Context) {
+	var archive func([]common.TaskRecord) error
+	if r.archiveDir != "" {
+		archive = r.archive
+	}
+
+	n, err := r.tasks.Purge(ctx, r.policy, archive)
+	if err != nil && ctx.Err() == nil {
+		log.Error().Str("mod", "retention").Msgf("purge: %v", err)
+	}
+	if n > 0 {
+		log.Info().Str("mod", "retention").Msgf("purged %d tasks", n)
+	}
+}
+
+// archive 把一批任务写成gzip压缩的JSONL文件，描述和历次修改与api中一样抹去凭证
+func (r *retention) archive(records []common.TaskRecord) (err error) {
+	name := fmt.Sprintf("tasks-%s-%s.jsonl.gz", time.Now().Format("20060102150405"), records[0].ID)
+	path := filepath.Join(r.archiveDir, name)
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(path + ".tmp")
+		}
+	}()
+
+	zw := gzip.NewWriter(file)
+	enc := json.NewEncoder(zw)
+	for _, record := range records {
+		record.Description = secret.Redact(record.Description)
+		revisions := make([]common.TaskRevision, len(record.Revisions))
+		for i, revision := range record.Revisions {
+			revision.Description = secret.Redact(revision.Description)
+			revisions[i] = revision
+		}
+		record.Revisions = revisions
+		if err = enc.Encode(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
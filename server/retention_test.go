@@ -0,0 +1,81 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	cfg := retentionConfig{
+		MaxAge: "90d",
+		States: map[string]string{"cancelled": "12h"},
+	}
+
+	interval, policy, err := cfg.policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interval != time.Hour {
+		t.Errorf("default interval should be 1h, got %v", interval)
+	}
+	if policy.MaxAge != 90*24*time.Hour {
+		t.Errorf("max_age should be 90 days, got %v", policy.MaxAge)
+	}
+	if policy.StateMaxAge["cancelled"] != 12*time.Hour {
+		t.Errorf("cancelled should be kept 12h, got %v", policy.StateMaxAge["cancelled"])
+	}
+
+	for _, bad := range []retentionConfig{
+		{States: map[string]string{"running": "1d"}},
+		{MaxAge: "0d"},
+		{MaxAge: "forever"},
+	} {
+		if _, _, err := bad.policy(); err == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+}
+
+func TestArchiveRedactsDescriptions(t *testing.T) {
+	r := &retention{archiveDir: t.TempDir()}
+	record := common.TaskRecord{
+		TaskInfo:    common.TaskInfo{ID: "7"},
+		Description: "output:\n  password: hunter2\n",
+		Revisions:   []common.TaskRevision{{Revision: 1, Description: "token: abc123\n"}},
+	}
+	if err := r.archive([]common.TaskRecord{record}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(r.archiveDir, "*.jsonl.gz"))
+	if len(files) != 1 {
+		t.Fatalf("one archive should be written, got %v", files)
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content strings.Builder
+	if _, err := io.Copy(&content, zr); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(content.String(), "hunter2") || strings.Contains(content.String(), "abc123") {
+		t.Errorf("archive should not contain credentials, got %s", content.String())
+	}
+	if record.Description != "output:\n  password: hunter2\n" || record.Revisions[0].Description != "token: abc123\n" {
+		t.Errorf("records passed in should be left untouched, got %+v", record)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"os"
+)
+
+// setLimits 当前系统不支持资源限制
+func setLimits(memoryMB, cpuSeconds int) error {
+	if memoryMB > 0 || cpuSeconds > 0 {
+		return errors.New("resource limits are not supported on this platform")
+	}
+	return nil
+}
+
+// maxRSS 当前系统取不到子进程的内存峰值
+func maxRSS(state *os.ProcessState) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package server
+
+import (
+	"os"
+	"runtime/debug"
+	"syscall"
+)
+
+// setLimits 限制当前进程的内存和cpu时间，0为不限
+func setLimits(memoryMB, cpuSeconds int) error {
+	if memoryMB > 0 {
+		bytes := uint64(memoryMB) << 20
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: bytes, Max: bytes}); err != nil {
+			return err
+		}
+		// 接近上限前让gc更积极
+		debug.SetMemoryLimit(int64(bytes) / 10 * 9)
+	}
+	if cpuSeconds > 0 {
+		secs := uint64(cpuSeconds)
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: secs, Max: secs}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxRSS 子进程占用内存的峰值，单位KB
+func maxRSS(state *os.ProcessState) (int64, bool) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, false
+	}
+	return int64(rusage.Maxrss), true
+}
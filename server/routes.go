@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// route 一个api路由，同时用于注册和生成openapi文档
+type route struct {
+	method    string
+	path      string
+	summary   string
+	handler   gin.HandlerFunc
+	query     []string
+	request   any
+	multipart bool
+	rawBody   string
+	status    int
+	response  any
+	produces  string
+	audit     string
+}
+
+// routes /api/v1下的所有路由
+func (api *ApplicationInterface) routes() []route {
+	return []route{
+		{method: http.MethodGet, path: "/tasks", summary: "list tasks, newest first, label=key=value may repeat", handler: api.listTasks,
+			query: []string{"namespace", "state", "label", "overdue", "limit", "offset"}, response: []common.TaskInfo{}},
+		{method: http.MethodPost, path: "/tasks", summary: "create a task", handler: api.postTasks,
+			query: []string{"namespace"}, request: taskRequest{}, multipart: true, status: http.StatusCreated, response: taskCreated{}, audit: "task.create"},
+		{method: http.MethodPost, path: "/tasks/batch", summary: "create many tasks in one transaction from a json array or an archive of yaml files",
+			handler: api.postTasksBatch, query: []string{"namespace", "all_or_nothing"}, request: []taskRequest{}, multipart: true,
+			status: http.StatusCreated, response: batchCreated{}, audit: "task.batch"},
+		{method: http.MethodPost, path: "/tasks/cancel", summary: "cancel pending or running tasks with all the labels given as label=key=value",
+			handler: api.cancelMatching, query: []string{"namespace", "state", "label"}, response: tasksCancelled{}, audit: "task.cancel_matching"},
+		{method: http.MethodGet, path: "/tasks/export", summary: "export tasks with descriptions, logs and revisions as json lines, oldest first",
+			handler: api.exportTasks, query: []string{"namespace", "state", "label"}, produces: "application/x-ndjson"},
+		{method: http.MethodPost, path: "/tasks/import", summary: "import exported json lines in one transaction, tasks get new ids and running ones become pending",
+			handler: api.importTasks, query: []string{"namespace"}, rawBody: "application/x-ndjson", status: http.StatusCreated, response: tasksImported{}, audit: "task.import"},
+		{method: http.MethodDelete, path: "/tasks/:id", summary: "cancel a task", handler: api.deleteTasks,
+			query: []string{"namespace"}, status: http.StatusNoContent, audit: "task.cancel"},
+		{method: http.MethodGet, path: "/tasks/:id", summary: "download the description of a task", handler: api.getTasks,
+			query: []string{"namespace"}, produces: "application/octet-stream"},
+		{method: http.MethodPatch, path: "/tasks/:id", summary: "replace the description or options of a task not claimed yet, keeping the previous revision",
+			handler: api.patchTask, query: []string{"namespace"}, request: taskPatch{}, response: common.TaskInfo{}, audit: "task.edit"},
+		{method: http.MethodGet, path: "/tasks/:id/revisions", summary: "list previous revisions of an edited task", handler: api.getTaskRevisions,
+			query: []string{"namespace"}, response: []common.TaskRevision{}},
+		{method: http.MethodGet, path: "/tasks/:id/status", summary: "view the status of a task", handler: api.getTaskStatus,
+			query: []string{"namespace"}, response: common.TaskInfo{}},
+		{method: http.MethodPost, path: "/tasks/:id/rerun", summary: "rerun a finished or cancelled task", handler: api.rerunTask,
+			query: []string{"namespace"}, audit: "task.rerun"},
+		{method: http.MethodGet, path: "/tasks/:id/logs", summary: "view the logs of a task", handler: api.getTaskLogs,
+			query: []string{"namespace"}, response: []common.LogLine{}},
+
+		{method: http.MethodGet, path: "/workers", summary: "list workers of all servers, only for global tokens", handler: api.getWorkers,
+			response: []common.WorkerInfo{}},
+
+		{method: http.MethodGet, path: "/anchors", summary: "list current anchors", handler: api.getAnchors,
+			query: []string{"namespace"}, response: common.AnchorSet{}},
+		{method: http.MethodGet, path: "/anchors/:name", summary: "view the current version of an anchor", handler: api.getAnchor,
+			query: []string{"namespace"}, response: common.Anchor{}},
+		{method: http.MethodGet, path: "/anchors/:name/versions", summary: "list all versions of an anchor", handler: api.getAnchorVersions,
+			query: []string{"namespace"}, response: []common.Anchor{}},
+		{method: http.MethodPut, path: "/anchors/:name", summary: "create or update an anchor", handler: api.putAnchor,
+			query: []string{"namespace"}, rawBody: "application/yaml", response: common.Anchor{}, audit: "anchor.put"},
+		{method: http.MethodDelete, path: "/anchors/:name", summary: "delete an anchor, keeping its versions", handler: api.deleteAnchor,
+			query: []string{"namespace"}, status: http.StatusNoContent, audit: "anchor.delete"},
+
+		{method: http.MethodGet, path: "/audit", summary: "list recorded api actions, newest first, only for global tokens", handler: api.getAudit,
+			query: []string{"caller", "action", "target", "since", "limit", "offset"}, response: []common.AuditEntry{}},
+	}
+}
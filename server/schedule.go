@@ -0,0 +1,200 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// maxDeferSteps 在各时间段和停机期之间来回推迟的最多次数
+const maxDeferSteps = 1000
+
+// scheduleConfig 执行时间的限制，windows按并发组指定允许执行的时间段，blackouts期间所有任务都不执行
+type scheduleConfig struct {
+	Timezone  string            `yaml:"timezone"`
+	Windows   map[string]string `yaml:"windows"`
+	Blackouts []blackoutConfig  `yaml:"blackouts"`
+}
+
+// blackoutConfig 一个停机期，from和to为一段时间，cron为周期性的时间段
+type blackoutConfig struct {
+	From   string `yaml:"from"`
+	To     string `yaml:"to"`
+	Cron   string `yaml:"cron"`
+	Reason string `yaml:"reason"`
+}
+
+// blackout 解析后的停机期
+type blackout struct {
+	from, to time.Time
+	cron     *window
+	reason   string
+}
+
+// covers t是否在停机期内
+func (b blackout) covers(t time.Time) bool {
+	if b.cron != nil {
+		return b.cron.allows(t)
+	}
+	return !t.Before(b.from) && t.Before(b.to)
+}
+
+// end 停机期在t之后的结束时间
+func (b blackout) end(t time.Time) (time.Time, bool) {
+	if b.cron != nil {
+		return b.cron.nextOutside(t)
+	}
+	return b.to, true
+}
+
+// describe 推迟任务时记录的原因
+func (b blackout) describe() string {
+	desc := "blackout " + b.from.Format(scheduledAtLayout) + " - " + b.to.Format(scheduledAtLayout)
+	if b.cron != nil {
+		desc = "blackout " + b.cron.spec
+	}
+	if b.reason != "" {
+		desc += " (" + b.reason + ")"
+	}
+	return desc
+}
+
+// runSchedule 领取任务后判断现在能否执行，可以随配置重新加载
+type runSchedule struct {
+	lock      sync.RWMutex
+	location  *time.Location
+	windows   map[string]*window
+	blackouts []blackout
+}
+
+// newRunSchedule 按配置创建
+func newRunSchedule(cfg scheduleConfig) (*runSchedule, error) {
+	s := &runSchedule{}
+	if err := s.set(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// set 替换为新的配置，配置有误时保持不变
+func (s *runSchedule) set(cfg scheduleConfig) error {
+	location := time.Local
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return fmt.Errorf("schedule.timezone: %w", err)
+		}
+		location = loc
+	}
+
+	windows := make(map[string]*window, len(cfg.Windows))
+	for key, spec := range cfg.Windows {
+		w, err := parseWindow(spec, location)
+		if err != nil {
+			return fmt.Errorf("schedule.windows.%s: %w", key, err)
+		}
+		if _, ok := w.next(time.Now()); !ok {
+			return fmt.Errorf("schedule.windows.%s: %q never opens within a year", key, spec)
+		}
+		windows[key] = w
+	}
+
+	blackouts := make([]blackout, 0, len(cfg.Blackouts))
+	for i, bc := range cfg.Blackouts {
+		b := blackout{reason: bc.Reason}
+		switch {
+		case bc.Cron != "" && bc.From == "" && bc.To == "":
+			w, err := parseWindow(bc.Cron, location)
+			if err != nil {
+				return fmt.Errorf("schedule.blackouts[%d]: %w", i, err)
+			}
+			if _, ok := w.nextOutside(time.Now()); !ok {
+				return fmt.Errorf("schedule.blackouts[%d]: %q never ends within a year", i, bc.Cron)
+			}
+			b.cron = w
+		case bc.Cron == "" && bc.From != "" && bc.To != "":
+			var err error
+			if b.from, err = time.ParseInLocation(scheduledAtLayout, bc.From, location); err != nil {
+				return fmt.Errorf("schedule.blackouts[%d].from should be like %s", i, scheduledAtLayout)
+			}
+			if b.to, err = time.ParseInLocation(scheduledAtLayout, bc.To, location); err != nil {
+				return fmt.Errorf("schedule.blackouts[%d].to should be like %s", i, scheduledAtLayout)
+			}
+			if !b.to.After(b.from) {
+				return fmt.Errorf("schedule.blackouts[%d].to should be after from", i)
+			}
+		default:
+			return fmt.Errorf("schedule.blackouts[%d] should have either from and to, or cron", i)
+		}
+		blackouts = append(blackouts, b)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.location, s.windows, s.blackouts = location, windows, blackouts
+	return nil
+}
+
+// deferral 任务在now不能执行时，返回最早可以执行的时间和原因；任务自身的时间段无法解析时返回错误
+func (s *runSchedule) deferral(task common.Task, now time.Time) (time.Time, string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var windows []*window
+	if spec := task.Window(); spec != "" {
+		w, err := parseWindow(spec, s.location)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		windows = append(windows, w)
+	}
+	for _, group := range task.Concurrency() {
+		if w, ok := s.windows[group.Key]; ok {
+			windows = append(windows, w)
+		}
+	}
+	if len(windows) == 0 && len(s.blackouts) == 0 {
+		return time.Time{}, "", nil
+	}
+
+	// 反复推迟到所有时间段都允许且不在任何停机期内，原因取最先遇到的
+	at, reason := now, ""
+	for step := 0; step < maxDeferSteps; step++ {
+		moved := false
+		for _, w := range windows {
+			if w.allows(at) {
+				continue
+			}
+			next, ok := w.next(at)
+			if !ok {
+				return time.Time{}, "", fmt.Errorf("window %q never opens within a year", w.spec)
+			}
+			if reason == "" {
+				reason = "outside window " + w.spec
+			}
+			at, moved = next, true
+		}
+		for _, b := range s.blackouts {
+			if !b.covers(at) {
+				continue
+			}
+			end, ok := b.end(at)
+			if !ok {
+				return time.Time{}, "", fmt.Errorf("%s never ends within a year", b.describe())
+			}
+			if reason == "" {
+				reason = b.describe()
+			}
+			at, moved = end, true
+		}
+		if !moved {
+			if reason == "" {
+				return time.Time{}, "", nil
+			}
+			return at, reason, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("no time allowed by the windows and blackouts")
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// maxBatchTasks 一次批量提交的任务数上限
+const maxBatchTasks = 1000
+
+// batchItem 批量提交中的一个任务，name为归档中的文件名
+type batchItem struct {
+	name string
+	req  taskRequest
+}
+
+// batchResult 批量提交中一个任务的结果
+type batchResult struct {
+	Index  int          `json:"index"`
+	Name   string       `json:"name,omitempty" doc:"file name in the archive"`
+	ID     string       `json:"id,omitempty"`
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+// batchCreated 批量提交的响应，ids为新建的任务
+type batchCreated struct {
+	IDs     []string      `json:"ids"`
+	Results []batchResult `json:"results"`
+}
+
+// postTasksBatch 在一个事务中新建多个任务，all_or_nothing=true时有任何一个不合法则都不创建
+func (api *ApplicationInterface) postTasksBatch(c *gin.Context) {
+	allOrNothing, _ := strconv.ParseBool(c.Query("all_or_nothing"))
+
+	items, err := bindBatchRequest(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	setAuditSummary(c, fmt.Sprintf("%d tasks", len(items)))
+	ns, err := api.target(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+
+	results := make([]batchResult, len(items))
+	rawTasks := make([]common.RawTask, 0, len(items))
+	created := make([]int, 0, len(items))
+	var invalid validationErr
+
+	for i, item := range items {
+		results[i] = batchResult{Index: i, Name: item.name}
+
+		var fields validationErr
+		err := item.req.validate()
+		if err == nil {
+			err = ns.apply(&item.req)
+		}
+		if errors.As(err, &fields) {
+			results[i].Fields = fields
+			for _, f := range fields {
+				invalid = append(invalid, fieldError{Field: batchField(i, item.name) + "." + f.Field, Message: f.Message})
+			}
+			continue
+		}
+		rawTask := item.req.rawTask()
+		rawTask.Namespace = ns.name
+		rawTasks = append(rawTasks, rawTask)
+		created = append(created, i)
+	}
+
+	if len(invalid) > 0 && (allOrNothing || len(rawTasks) == 0) {
+		api.respondErr(c, invalid)
+		return
+	}
+
+	ids, err := api.tasks.WriteBatch(c.Request.Context(), rawTasks)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	for i, id := range ids {
+		results[created[i]].ID = id
+	}
+	setAuditTarget(c, strings.Join(ids, ","))
+	setAuditSummary(c, fmt.Sprintf("%d tasks, %d created", len(items), len(ids)))
+
+	c.JSON(http.StatusCreated, batchCreated{IDs: ids, Results: results})
+}
+
+// batchField 错误信息中任务的位置
+func batchField(i int, name string) string {
+	if name != "" {
+		return "tasks[" + name + "]"
+	}
+	return "tasks[" + strconv.Itoa(i) + "]"
+}
+
+// bindBatchRequest json为任务数组，multipart时file为yaml文件的归档，scheduled_at、concurrency、param、label、window和deadline对所有任务生效
+func bindBatchRequest(c *gin.Context) ([]batchItem, error) {
+	var items []batchItem
+
+	if c.ContentType() == gin.MIMEJSON {
+		var reqs []taskRequest
+		dec := json.NewDecoder(c.Request.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&reqs); err != nil {
+			return nil, validationErr{{Field: "body", Message: err.Error()}}
+		}
+		for _, req := range reqs {
+			items = append(items, batchItem{req: req})
+		}
+	} else {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, validationErr{{Field: "file", Message: "is required"}}
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		bytesArr, err := io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		descs, err := readArchive(bytesArr)
+		if err != nil {
+			return nil, validationErr{{Field: "file", Message: err.Error()}}
+		}
+
+		concurrency, err := parseConcurrencyGroups(c.PostFormArray("concurrency"))
+		if err != nil {
+			return nil, validationErr{{Field: "concurrency", Message: err.Error()}}
+		}
+		params, err := parseParams(c.PostFormArray("param"))
+		if err != nil {
+			return nil, validationErr{{Field: "param", Message: err.Error()}}
+		}
+		labels, err := parseLabels(c.PostFormArray("label"))
+		if err != nil {
+			return nil, validationErr{{Field: "label", Message: err.Error()}}
+		}
+
+		names := make([]string, 0, len(descs))
+		for name := range descs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			items = append(items, batchItem{name: name, req: taskRequest{
+				Description: descs[name],
+				ScheduledAt: c.PostForm("scheduled_at"),
+				Concurrency: concurrency,
+				Params:      params,
+				Labels:      labels,
+				Window:      c.PostForm("window"),
+				Deadline:    c.PostForm("deadline"),
+			}})
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, validationErr{{Field: "tasks", Message: "is empty"}}
+	}
+	if len(items) > maxBatchTasks {
+		return nil, validationErr{{Field: "tasks", Message: fmt.Sprintf("should be at most %d", maxBatchTasks)}}
+	}
+	return items, nil
+}
+
+// readArchive 读出zip、tar或tar.gz中的yaml文件
+func readArchive(data []byte) (map[string]string, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return readZip(data)
+	}
+
+	var reader io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	}
+	return readTar(reader)
+}
+
+// readZip 读出zip中的yaml文件
+func readZip(data []byte) (map[string]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	descs := map[string]string{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isTaskFile(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		bytesArr, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		descs[f.Name] = string(bytesArr)
+	}
+	return descs, nil
+}
+
+// readTar 读出tar中的yaml文件
+func readTar(reader io.Reader) (map[string]string, error) {
+	tr := tar.NewReader(reader)
+	descs := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return descs, nil
+		}
+		if err != nil {
+			return nil, errors.New("should be a zip, tar or tar.gz of yaml files")
+		}
+		if header.Typeflag != tar.TypeReg || !isTaskFile(header.Name) {
+			continue
+		}
+		bytesArr, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		descs[header.Name] = string(bytesArr)
+	}
+}
+
+// isTaskFile 是否为任务描述文件，忽略隐藏文件
+func isTaskFile(name string) bool {
+	base := path.Base(name)
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") {
+		return false
+	}
+	ext := path.Ext(base)
+	return ext == ".yml" || ext == ".yaml"
+}
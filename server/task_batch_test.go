@@ -0,0 +1,112 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// batchTasklist 记录批量写入的任务
+type batchTasklist struct {
+	common.Tasklist
+	written []common.RawTask
+}
+
+func (list *batchTasklist) WriteBatch(ctx context.Context, rawTasks []common.RawTask) ([]string, error) {
+	ids := make([]string, 0, len(rawTasks))
+	for _, rawTask := range rawTasks {
+		list.written = append(list.written, rawTask)
+		ids = append(ids, strconv.Itoa(len(list.written)))
+	}
+	return ids, nil
+}
+
+func TestReadArchive(t *testing.T) {
+	files := map[string]string{
+		"backfill/2023-01.yml":   "input: {}",
+		"backfill/2023-02.yaml":  "input: {}",
+		"backfill/README.md":     "# not a task",
+		"backfill/._2023-01.yml": "resource fork",
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for name, content := range files {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+
+	var tarred bytes.Buffer
+	gw := gzip.NewWriter(&tarred)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gw.Close()
+
+	for kind, data := range map[string][]byte{"zip": zipped.Bytes(), "tar.gz": tarred.Bytes()} {
+		descs, err := readArchive(data)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if len(descs) != 2 || descs["backfill/2023-01.yml"] == "" || descs["backfill/2023-02.yaml"] == "" {
+			t.Errorf("%s: only yaml files should be read, got %v", kind, descs)
+		}
+	}
+
+	if _, err := readArchive([]byte("input: {}")); err == nil {
+		t.Error("plain yaml should not be taken as an archive")
+	}
+}
+
+func TestPostTasksBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	list := &batchTasklist{}
+	api := &ApplicationInterface{tasks: list}
+	router := gin.New()
+	router.POST("/tasks/batch", api.postTasksBatch)
+
+	body := `[{"description": "input: {}"}, {"description": "input: [", "scheduled_at": "soon"}, {"description": "output: {}"}]`
+	post := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/batch"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("?all_or_nothing=true")
+	if w.Code != http.StatusBadRequest || len(list.written) != 0 {
+		t.Fatalf("all or nothing should create nothing, got %d %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "tasks[1].scheduled_at") {
+		t.Errorf("errors should point to the task, got %s", w.Body)
+	}
+
+	w = post("")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("valid tasks should be created, got %d %s", w.Code, w.Body)
+	}
+	var created batchCreated
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if len(created.IDs) != 2 || created.Results[0].ID != "1" || created.Results[1].ID != "" || created.Results[2].ID != "2" {
+		t.Errorf("ids should follow the request order, got %+v", created)
+	}
+	if len(created.Results[1].Fields) != 2 {
+		t.Errorf("invalid task should be reported, got %+v", created.Results[1])
+	}
+}
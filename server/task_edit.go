@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/secret"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// taskPatch 修改未开始任务的请求，只替换给出的字段
+type taskPatch struct {
+	Description *string                    `json:"description,omitempty" doc:"pipeline yaml"`
+	ScheduledAt *string                    `json:"scheduled_at,omitempty" doc:"like 2023-12-31 00:00:00"`
+	Concurrency *[]common.ConcurrencyGroup `json:"concurrency,omitempty" doc:"replaces all groups"`
+	Params      *map[string]string         `json:"params,omitempty" doc:"replaces all params"`
+	Labels      *map[string]string         `json:"labels,omitempty" doc:"replaces all labels"`
+	Window      *string                    `json:"window,omitempty" doc:"empty to run at any time"`
+	Deadline    *string                    `json:"deadline,omitempty" doc:"like 2023-12-31 08:00:00, empty for no deadline"`
+}
+
+// bindTaskPatch 解析json请求，至少要修改一个字段
+func bindTaskPatch(c *gin.Context) (taskPatch, error) {
+	var patch taskPatch
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patch); err != nil {
+		return patch, validationErr{{Field: "body", Message: err.Error()}}
+	}
+	if patch.Description == nil && patch.ScheduledAt == nil && patch.Concurrency == nil && patch.Params == nil && patch.Labels == nil && patch.Window == nil && patch.Deadline == nil {
+		return patch, validationErr{{Field: "body", Message: "has nothing to change"}}
+	}
+	return patch, nil
+}
+
+// apply 在任务当前的内容上替换给出的字段
+func (patch taskPatch) apply(cur common.RawTask) taskRequest {
+	req := taskRequest{
+		Description: cur.Description,
+		ScheduledAt: cur.ScheduledAt,
+		Concurrency: cur.Concurrency,
+		Params:      cur.Params,
+		Labels:      cur.Labels,
+		Window:      cur.Window,
+		Deadline:    cur.Deadline,
+	}
+	if patch.Description != nil {
+		req.Description = *patch.Description
+	}
+	if patch.ScheduledAt != nil {
+		req.ScheduledAt = *patch.ScheduledAt
+	}
+	if patch.Concurrency != nil {
+		req.Concurrency = *patch.Concurrency
+	}
+	if patch.Params != nil {
+		req.Params = *patch.Params
+	}
+	if patch.Labels != nil {
+		req.Labels = *patch.Labels
+	}
+	if patch.Window != nil {
+		req.Window = *patch.Window
+	}
+	if patch.Deadline != nil {
+		req.Deadline = *patch.Deadline
+	}
+	return req
+}
+
+// summary 审计记录中的摘要，只记修改了哪些字段
+func (patch taskPatch) summary() string {
+	var parts []string
+	if patch.Description != nil {
+		parts = append(parts, "description="+contentDigest(*patch.Description))
+	}
+	if patch.ScheduledAt != nil {
+		parts = append(parts, "scheduled_at="+*patch.ScheduledAt)
+	}
+	if patch.Concurrency != nil {
+		parts = append(parts, "concurrency")
+	}
+	if patch.Params != nil {
+		parts = append(parts, "params")
+	}
+	if patch.Labels != nil {
+		parts = append(parts, "labels="+formatLabels(*patch.Labels))
+	}
+	if patch.Window != nil {
+		parts = append(parts, "window="+*patch.Window)
+	}
+	if patch.Deadline != nil {
+		parts = append(parts, "deadline="+*patch.Deadline)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// patchTask 修改尚未被领取的任务，修改前的版本会保留
+func (api *ApplicationInterface) patchTask(c *gin.Context) {
+	patch, err := bindTaskPatch(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	setAuditSummary(c, patch.summary())
+
+	scope, err := api.scope(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+
+	id := c.Param("id")
+	err = api.tasks.Edit(c.Request.Context(), scope, id, c.GetString(callerKey), func(cur common.RawTask) (common.RawTask, error) {
+		req := patch.apply(cur)
+		if err := req.validate(); err != nil {
+			return cur, err
+		}
+		// 按命名空间当前的配置重新加上配额
+		ns, err := api.configured(cur.Namespace)
+		if err != nil {
+			return cur, err
+		}
+		if err := ns.apply(&req); err != nil {
+			return cur, err
+		}
+		next := req.rawTask()
+		next.Namespace = cur.Namespace
+		return next, nil
+	})
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+
+	info, err := api.tasks.Status(c.Request.Context(), scope, id)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// getTaskRevisions 列出任务被修改前的各个版本
+func (api *ApplicationInterface) getTaskRevisions(c *gin.Context) {
+	ns, err := api.scope(c)
+	var revisions []common.TaskRevision
+	if err == nil {
+		revisions, err = api.tasks.Revisions(c.Request.Context(), ns, c.Param("id"))
+	}
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	for i := range revisions {
+		revisions[i].Description = secret.Redact(revisions[i].Description)
+	}
+	c.JSON(http.StatusOK, revisions)
+}
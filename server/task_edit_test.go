@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// editTasklist 只有一个pending的任务1，任务2已被领取
+type editTasklist struct {
+	common.Tasklist
+	task   common.RawTask
+	editor string
+}
+
+func (list *editTasklist) Edit(ctx context.Context, namespace string, id string, editor string, edit func(common.RawTask) (common.RawTask, error)) error {
+	if id == "2" {
+		return fmt.Errorf("%w: task 2 has been claimed", common.ErrConflict)
+	}
+	next, err := edit(list.task)
+	if err != nil {
+		return err
+	}
+	list.task, list.editor = next, editor
+	return nil
+}
+
+func (list *editTasklist) Status(ctx context.Context, namespace string, id string) (common.TaskInfo, error) {
+	return common.TaskInfo{ID: id, Labels: list.task.Labels}, nil
+}
+
+func TestPatchTask(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	list := &editTasklist{task: common.RawTask{
+		Description: "input: {}",
+		ScheduledAt: "2023-12-31 00:00:00",
+		Params:      map[string]string{"table": "events"},
+		Labels:      map[string]string{"team": "data"},
+	}}
+	api := &ApplicationInterface{tasks: list}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(callerKey, "ops") })
+	router.PATCH("/tasks/:id", api.patchTask)
+
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/tasks/"+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := patch("1", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("empty patch should be rejected, got %d", w.Code)
+	}
+	if w := patch("1", `{"scheduled_at": "soon"}`); w.Code != http.StatusBadRequest || list.editor != "" {
+		t.Errorf("invalid patch should change nothing, got %d %s", w.Code, w.Body)
+	}
+	if w := patch("1", `{"description": "input:\n  sql: {dsn: \"${secret:team-b/db}\"}"}`); w.Code != http.StatusBadRequest || list.editor != "" {
+		t.Errorf("secret of another namespace should be rejected, got %d %s", w.Code, w.Body)
+	}
+	if w := patch("2", `{"description": "input: {}"}`); w.Code != http.StatusConflict {
+		t.Errorf("claimed task should not be edited, got %d %s", w.Code, w.Body)
+	}
+
+	w := patch("1", `{"description": "input:\n  generate: {}\n", "labels": {"team": "ops"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch should succeed, got %d %s", w.Code, w.Body)
+	}
+	if list.editor != "ops" || list.task.Description != "input:\n  generate: {}\n" || list.task.Labels["team"] != "ops" {
+		t.Errorf("given fields should be replaced by the caller, got %+v by %s", list.task, list.editor)
+	}
+	if list.task.ScheduledAt != "2023-12-31 00:00:00" || list.task.Params["table"] != "events" {
+		t.Errorf("other fields should be kept, got %+v", list.task)
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// maxImportLine 导入时一行记录的最大字节数
+const maxImportLine = 16 << 20
+
+// tasksImported 导入的响应，ids与各行一一对应
+type tasksImported struct {
+	IDs []string `json:"ids"`
+}
+
+// exportTasks 以jsonl导出符合条件的任务，每行一个完整记录；描述原样导出以便导入后执行
+func (api *ApplicationInterface) exportTasks(c *gin.Context) {
+	labels, err := labelFilter(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	ns, err := api.scope(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	state := c.Query("state")
+	// 读出第一批记录后才开始输出，此前的错误仍可以返回错误码
+	started := false
+	start := func() {
+		if !started {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(http.StatusOK)
+			started = true
+		}
+	}
+
+	enc := json.NewEncoder(c.Writer)
+	err = api.tasks.Export(c.Request.Context(), common.ListOptions{Namespace: ns, State: state, Labels: labels}, func(records []common.TaskRecord) error {
+		start()
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		if !started {
+			api.respondErr(c, err)
+			return
+		}
+		// 已经开始输出，只能中断，客户端会发现最后一行不完整或缺少记录
+		log.Error().Str("mod", "api").Msgf("export tasks: %v", err)
+		return
+	}
+	start()
+}
+
+// importTasks 在一个事务中导入jsonl记录，任务分配新的id；有任何一行不合法则都不导入。
+// 指定了命名空间时都导入其中，否则按记录中的命名空间
+func (api *ApplicationInterface) importTasks(c *gin.Context) {
+	forced, err := api.scope(c)
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	records, err := bindImportRequest(c, func(record *common.TaskRecord) validationErr {
+		if forced != "" {
+			record.Namespace = forced
+		}
+		return api.placeRecord(record)
+	})
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	setAuditSummary(c, fmt.Sprintf("%d tasks", len(records)))
+
+	ids, err := api.tasks.Import(c.Request.Context(), records)
+	setAuditTarget(c, strings.Join(ids, ","))
+	if err != nil {
+		api.respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, tasksImported{IDs: ids})
+}
+
+// bindImportRequest 逐行解析并校验记录，行号从1开始，place决定记录所在的命名空间
+func bindImportRequest(c *gin.Context, place func(*common.TaskRecord) validationErr) ([]common.TaskRecord, error) {
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxImportLine)
+
+	var (
+		records []common.TaskRecord
+		invalid validationErr
+	)
+	for line := 1; scanner.Scan(); line++ {
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		field := fmt.Sprintf("lines[%d]", line)
+		if len(records) >= maxBatchTasks {
+			return nil, validationErr{{Field: "body", Message: fmt.Sprintf("should have at most %d tasks", maxBatchTasks)}}
+		}
+
+		var record common.TaskRecord
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			invalid = append(invalid, fieldError{Field: field, Message: "is not a task record: " + err.Error()})
+			continue
+		}
+		fields := validateRecord(&record)
+		if len(fields) == 0 {
+			fields = place(&record)
+		}
+		for _, f := range fields {
+			invalid = append(invalid, fieldError{Field: field + "." + f.Field, Message: f.Message})
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, validationErr{{Field: "body", Message: err.Error()}}
+	}
+
+	if len(invalid) > 0 {
+		return nil, invalid
+	}
+	if len(records) == 0 {
+		return nil, validationErr{{Field: "body", Message: "has no task"}}
+	}
+	return records, nil
+}
+
+// validateRecord 校验导入的记录并按其状态整理各时间字段，使任务列表推出的状态与之一致；
+// 运行中的任务没有worker在执行，作为未开始的任务导入
+func validateRecord(record *common.TaskRecord) validationErr {
+	req := taskRequest{
+		Description: record.Description,
+		Concurrency: record.Concurrency,
+		Params:      record.Params,
+		Labels:      record.Labels,
+		Window:      record.Window,
+	}
+	var fields validationErr
+	if err := req.validate(); err != nil {
+		fields = err.(validationErr)
+	}
+
+	now := time.Now()
+	switch record.State {
+	case common.StatePending, common.StateRunning:
+		record.PerformedAt, record.FinishedAt, record.CancelledAt = nil, nil, nil
+		record.PerformedBy, record.Error = "", ""
+	case common.StateDone:
+		record.FinishedAt = orNow(record.FinishedAt, now)
+		record.CancelledAt, record.Error = nil, ""
+	case common.StateError:
+		record.FinishedAt = orNow(record.FinishedAt, now)
+		record.CancelledAt = nil
+		if record.Error == "" {
+			fields = append(fields, fieldError{Field: "error", Message: "is required for error tasks"})
+		}
+	case common.StateCancelled:
+		record.CancelledAt = orNow(record.CancelledAt, now)
+	default:
+		fields = append(fields, fieldError{Field: "state", Message: "should be pending, running, done, error or cancelled"})
+	}
+	return fields
+}
+
+// placeRecord 记录的命名空间须已配置且只引用该命名空间的密钥，并发组按命名空间当前的配置重新加上配额
+func (api *ApplicationInterface) placeRecord(record *common.TaskRecord) validationErr {
+	ns, err := api.configured(record.Namespace)
+	if err == nil {
+		req := taskRequest{Description: record.Description, Concurrency: record.Concurrency}
+		err = ns.apply(&req)
+		record.Namespace, record.Concurrency = ns.name, req.Concurrency
+	}
+	fields, _ := err.(validationErr)
+	return fields
+}
+
+// orNow 缺少时间时取now
+func orNow(at *time.Time, now time.Time) *time.Time {
+	if at == nil {
+		return &now
+	}
+	return at
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// exportTasklist 导出records，导入时记下收到的记录
+type exportTasklist struct {
+	common.Tasklist
+	records  []common.TaskRecord
+	opts     common.ListOptions
+	imported []common.TaskRecord
+}
+
+func (list *exportTasklist) Export(ctx context.Context, opts common.ListOptions, write func([]common.TaskRecord) error) error {
+	list.opts = opts
+	for _, record := range list.records {
+		if err := write([]common.TaskRecord{record}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (list *exportTasklist) Import(ctx context.Context, records []common.TaskRecord) ([]string, error) {
+	list.imported = records
+	ids := []string{}
+	for i := range records {
+		ids = append(ids, string(rune('a'+i)))
+	}
+	return ids, nil
+}
+
+func (list *exportTasklist) Status(ctx context.Context, namespace string, id string) (common.TaskInfo, error) {
+	return common.TaskInfo{ID: id}, nil
+}
+
+func TestExportImportTasks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	finished := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	list := &exportTasklist{records: []common.TaskRecord{
+		{TaskInfo: common.TaskInfo{ID: "1", State: common.StateDone, FinishedAt: &finished}, Description: "input: {}"},
+		{TaskInfo: common.TaskInfo{ID: "2", State: common.StateRunning, PerformedBy: "w1"}, Description: "password: hunter2"},
+	}}
+	api := &ApplicationInterface{tasks: list}
+	router := gin.New()
+	// 固定路径与参数路径并存
+	router.GET("/tasks/:id", api.getTaskStatus)
+	router.GET("/tasks/export", api.exportTasks)
+	router.POST("/tasks/import", api.importTasks)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks/export?state=done&label=team=data", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export should stream json lines, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if list.opts.State != common.StateDone || list.opts.Labels["team"] != "data" {
+		t.Errorf("filters should be passed, got %+v", list.opts)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "hunter2") {
+		t.Fatalf("each record should be a line with its description, got %q", lines)
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tasks/import", strings.NewReader(body)))
+		return w
+	}
+
+	if w := post(lines[0] + "\n{\"state\": \"done\"}\nnot json\n"); w.Code != http.StatusBadRequest || list.imported != nil {
+		t.Errorf("invalid lines should reject the whole import, got %d %s", w.Code, w.Body)
+	} else {
+		var resp errorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Fields) != 2 || resp.Fields[0].Field != "lines[2].description" || resp.Fields[1].Field != "lines[3]" {
+			t.Errorf("errors should name the lines, got %+v", resp.Fields)
+		}
+	}
+	if w := post(`{"state": "error", "description": "input: {}"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("error task without message should be rejected, got %d", w.Code)
+	}
+
+	w = post(strings.Join(lines, "\n\n") + "\n")
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"ids":["a","b"]`) {
+		t.Fatalf("import should succeed, got %d %s", w.Code, w.Body)
+	}
+	if len(list.imported) != 2 || !list.imported[0].FinishedAt.Equal(finished) {
+		t.Fatalf("records should be imported as exported, got %+v", list.imported)
+	}
+	if running := list.imported[1]; running.PerformedAt != nil || running.PerformedBy != "" {
+		t.Errorf("running task should be imported as pending, got %+v", running)
+	}
+}
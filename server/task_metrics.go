@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"gopkg.in/yaml.v3"
+)
+
+// taskMetricsExporter 任务的stream没有配置metrics时使用的指标插件
+const taskMetricsExporter = "clams_task_metrics"
+
+// isolatedMetricsInterval 子进程把指标发给父进程的间隔
+const isolatedMetricsInterval = time.Second
+
+const (
+	metricCounter = "counter"
+	metricGauge   = "gauge"
+	metricTimer   = "timer"
+)
+
+// metricNamePattern prometheus指标名中不允许的字符
+var metricNamePattern = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// metricSeries 一个指标在一组标签下的值，timer的Value为总纳秒数、Count为次数
+type metricSeries struct {
+	Name   string            `json:"name"`
+	Kind   string            `json:"kind"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  int64             `json:"value"`
+	Count  int64             `json:"count,omitempty"`
+}
+
+// key 指标名和排好序的标签
+func (s *metricSeries) key() string {
+	return s.Name + "{" + metricLabels(s.Labels) + "}"
+}
+
+// taskSeries 一个任务的所有指标
+type taskSeries struct {
+	namespace string
+	series    map[string]*metricSeries
+}
+
+// taskMetrics 正在运行的任务的benthos指标，任务结束时去掉
+type taskMetrics struct {
+	lock  sync.Mutex
+	tasks map[string]*taskSeries
+}
+
+// newTaskMetrics 创建空的指标
+func newTaskMetrics() *taskMetrics {
+	return &taskMetrics{tasks: map[string]*taskSeries{}}
+}
+
+// update 修改任务的一个指标，没有时先创建
+func (m *taskMetrics) update(taskID, namespace string, s metricSeries, change func(*metricSeries)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	task, ok := m.tasks[taskID]
+	if !ok {
+		task = &taskSeries{namespace: namespace, series: map[string]*metricSeries{}}
+		m.tasks[taskID] = task
+	}
+	key := s.key()
+	existing, ok := task.series[key]
+	if !ok {
+		existing = &s
+		task.series[key] = existing
+	}
+	change(existing)
+}
+
+// snapshot 任务当前的指标
+func (m *taskMetrics) snapshot(taskID string) []metricSeries {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	task, ok := m.tasks[taskID]
+	if !ok {
+		return nil
+	}
+	series := make([]metricSeries, 0, len(task.series))
+	for _, s := range task.series {
+		series = append(series, *s)
+	}
+	return series
+}
+
+// replace 用子进程发来的指标替换任务的指标
+func (m *taskMetrics) replace(taskID, namespace string, series []metricSeries) {
+	if m == nil {
+		return
+	}
+	task := &taskSeries{namespace: namespace, series: make(map[string]*metricSeries, len(series))}
+	for i := range series {
+		task.series[series[i].key()] = &series[i]
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tasks[taskID] = task
+}
+
+// remove 去掉已结束的任务的指标
+func (m *taskMetrics) remove(taskID string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.tasks, taskID)
+}
+
+// write 以prometheus的文本格式输出，带上task和namespace标签，namespace不为空时只输出该命名空间的任务
+func (m *taskMetrics) write(w io.Writer, namespace string) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	var all []metricSeries
+	for id, task := range m.tasks {
+		if namespace != "" && task.namespace != namespace {
+			continue
+		}
+		for _, s := range task.series {
+			labels := make(map[string]string, len(s.Labels)+2)
+			for k, v := range s.Labels {
+				labels[k] = v
+			}
+			labels["task"], labels["namespace"] = id, task.namespace
+			all = append(all, metricSeries{Name: s.Name, Kind: s.Kind, Labels: labels, Value: s.Value, Count: s.Count})
+		}
+	}
+	m.lock.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].key() < all[j].key()
+	})
+
+	typed := map[string]bool{}
+	for _, s := range all {
+		name := "benthos_" + metricNamePattern.ReplaceAllString(s.Name, "_")
+		labels := metricLabels(s.Labels)
+		if !typed[name] {
+			typed[name] = true
+			kind := s.Kind
+			if kind == metricTimer {
+				kind = "summary"
+			}
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		}
+		if s.Kind == metricTimer {
+			fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, float64(s.Value)/float64(time.Second))
+			fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, s.Count)
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels, s.Value)
+	}
+}
+
+// metricLabels 按标签名排序后写成prometheus的 k="v",...
+func metricLabels(labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for _, k := range sortedKeys(labels) {
+		parts = append(parts, fmt.Sprintf("%s=%q", metricNamePattern.ReplaceAllString(k, "_"), labels[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// metricsExporter 把一个任务的benthos指标记到taskMetrics中
+type metricsExporter struct {
+	metrics   *taskMetrics
+	taskID    string
+	namespace string
+}
+
+func (e *metricsExporter) NewCounterCtor(name string, labelKeys ...string) service.MetricsExporterCounterCtor {
+	return func(labelValues ...string) service.MetricsExporterCounter {
+		return e.handle(name, metricCounter, labelKeys, labelValues)
+	}
+}
+
+func (e *metricsExporter) NewTimerCtor(name string, labelKeys ...string) service.MetricsExporterTimerCtor {
+	return func(labelValues ...string) service.MetricsExporterTimer {
+		return e.handle(name, metricTimer, labelKeys, labelValues)
+	}
+}
+
+func (e *metricsExporter) NewGaugeCtor(name string, labelKeys ...string) service.MetricsExporterGaugeCtor {
+	return func(labelValues ...string) service.MetricsExporterGauge {
+		return e.handle(name, metricGauge, labelKeys, labelValues)
+	}
+}
+
+func (e *metricsExporter) Close(ctx context.Context) error {
+	return nil
+}
+
+// handle 一个指标在一组标签下的句柄
+func (e *metricsExporter) handle(name, kind string, labelKeys, labelValues []string) *metricHandle {
+	labels := make(map[string]string, len(labelKeys))
+	for i, k := range labelKeys {
+		if i < len(labelValues) {
+			labels[k] = labelValues[i]
+		}
+	}
+	return &metricHandle{exporter: e, series: metricSeries{Name: name, Kind: kind, Labels: labels}}
+}
+
+// metricHandle 实现counter、timer和gauge
+type metricHandle struct {
+	exporter *metricsExporter
+	series   metricSeries
+}
+
+func (h *metricHandle) change(change func(*metricSeries)) {
+	h.exporter.metrics.update(h.exporter.taskID, h.exporter.namespace, h.series, change)
+}
+
+func (h *metricHandle) Incr(count int64) {
+	h.change(func(s *metricSeries) { s.Value += count })
+}
+
+func (h *metricHandle) Timing(delta int64) {
+	h.change(func(s *metricSeries) { s.Value += delta; s.Count++ })
+}
+
+func (h *metricHandle) Set(value int64) {
+	h.change(func(s *metricSeries) { s.Value = value })
+}
+
+// hasMetricsSection 任务的配置中是否自己配置了metrics
+func hasMetricsSection(text string) bool {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil || len(doc.Content) == 0 {
+		return false
+	}
+	return mappingValue(doc.Content[0], "metrics") != nil
+}
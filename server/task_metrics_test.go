@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/turnon/clams/secret"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// countInput 发出count条消息后结束的input
+type countInput struct {
+	count int
+}
+
+func (in *countInput) Connect(ctx context.Context) error { return nil }
+
+func (in *countInput) Read(ctx context.Context) (*service.Message, service.AckFunc, error) {
+	if in.count == 0 {
+		return nil, nil, service.ErrEndOfInput
+	}
+	in.count--
+	return service.NewMessage([]byte("x")), func(ctx context.Context, err error) error { return nil }, nil
+}
+
+func (in *countInput) Close(ctx context.Context) error { return nil }
+
+// discardOutput 丢弃所有消息的output
+type discardOutput struct{}
+
+func (out discardOutput) Connect(ctx context.Context) error { return nil }
+
+func (out discardOutput) Write(ctx context.Context, msg *service.Message) error { return nil }
+
+func (out discardOutput) Close(ctx context.Context) error { return nil }
+
+// init 注册测试用的input和output，以及pure组件中stream默认使用的tracer
+func init() {
+	service.RegisterOtelTracerProvider("none", service.NewConfigSpec(),
+		func(conf *service.ParsedConfig) (trace.TracerProvider, error) {
+			return trace.NewNoopTracerProvider(), nil
+		})
+	service.RegisterInput("test_count", service.NewConfigSpec().Field(service.NewIntField("count")),
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Input, error) {
+			count, err := conf.FieldInt("count")
+			return &countInput{count: count}, err
+		})
+	service.RegisterOutput("test_discard", service.NewConfigSpec(),
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Output, int, error) {
+			return discardOutput{}, 1, nil
+		})
+}
+
+// streamTask 能在runStream中运行的任务
+type streamTask struct {
+	paramTask
+	namespace string
+	aborted   chan struct{}
+}
+
+func (task *streamTask) Namespace() string {
+	return task.namespace
+}
+
+func (task *streamTask) Aborted() chan struct{} {
+	return task.aborted
+}
+
+func TestRunStreamMetrics(t *testing.T) {
+	task := &streamTask{paramTask: paramTask{id: "5", memoryTask: memoryTask{checkpoints: map[string]string{}}}, namespace: "team-a", aborted: make(chan struct{})}
+	metrics := newTaskMetrics()
+	desc := "input:\n  test_count:\n    count: 3\noutput:\n  test_discard: {}\n"
+	if err := runStream(context.Background(), task, secret.Resolved{Text: desc}, metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	metrics.write(&out, "team-a")
+	if !strings.Contains(out.String(), "# TYPE benthos_input_received counter\n") ||
+		!strings.Contains(out.String(), `namespace="team-a",path="root.input",task="5"} 3`) {
+		t.Errorf("metrics of the task should be exported, got\n%s", out.String())
+	}
+
+	out.Reset()
+	metrics.write(&out, "team-b")
+	if out.Len() != 0 {
+		t.Errorf("metrics of other namespaces should be left out, got\n%s", out.String())
+	}
+
+	metrics.remove("5")
+	out.Reset()
+	metrics.write(&out, "")
+	if out.Len() != 0 {
+		t.Errorf("metrics of a finished task should be removed, got\n%s", out.String())
+	}
+}
+
+func TestIsolatedMetricsRelay(t *testing.T) {
+	eventsR, eventsW := io.Pipe()
+	_, repliesW := io.Pipe()
+
+	// 子进程中记下的指标
+	childMetrics := newTaskMetrics()
+	exporter := &metricsExporter{metrics: childMetrics, taskID: "5", namespace: "team-a"}
+	exporter.NewCounterCtor("output_sent", "label")("out").Incr(4)
+	exporter.NewTimerCtor("output_latency_ns", "label")("out").Timing(2e9)
+	child := &remoteTask{id: "5", events: json.NewEncoder(eventsW)}
+
+	parent := &streamTask{paramTask: paramTask{id: "5"}, namespace: "team-a"}
+	parentMetrics := newTaskMetrics()
+	served := make(chan string)
+	go func() {
+		served <- serveIsolated(context.Background(), parent, secret.Resolved{}, parentMetrics, eventsR, json.NewEncoder(repliesW))
+	}()
+
+	stop := make(chan struct{})
+	close(stop)
+	child.relayMetrics(childMetrics, stop)
+	eventsW.Close()
+	<-served
+
+	var out strings.Builder
+	parentMetrics.write(&out, "")
+	for _, want := range []string{
+		`benthos_output_sent{label="out",namespace="team-a",task="5"} 4`,
+		`benthos_output_latency_ns_sum{label="out",namespace="team-a",task="5"} 2`,
+		`benthos_output_latency_ns_count{label="out",namespace="team-a",task="5"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics of the child should reach the parent, want %s, got\n%s", want, out.String())
+		}
+	}
+}
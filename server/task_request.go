@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/turnon/clams/tasklist/common"
+	"gopkg.in/yaml.v3"
+)
+
+// scheduledAtLayout scheduled_at的格式
+const scheduledAtLayout = "2006-01-02 15:04:05"
+
+// taskRequest 新建任务的请求，json或multipart均可
+type taskRequest struct {
+	Description string                    `json:"description" doc:"pipeline yaml"`
+	ScheduledAt string                    `json:"scheduled_at,omitempty" doc:"like 2023-12-31 00:00:00, default now"`
+	Concurrency []common.ConcurrencyGroup `json:"concurrency,omitempty"`
+	Params      map[string]string         `json:"params,omitempty" doc:"available as ${name} in the pipeline and task_param(\"name\") in bloblang"`
+	Labels      map[string]string         `json:"labels,omitempty" doc:"key/value tags, filter with label=key=value when listing or cancelling"`
+	Window      string                    `json:"window,omitempty" doc:"allowed run time as cron fields, like TZ=Asia/Shanghai * 0-5 * * *"`
+	Deadline    string                    `json:"deadline,omitempty" doc:"like 2023-12-31 08:00:00, marked overdue if not finished by then"`
+}
+
+// taskCreated 新建任务的响应
+type taskCreated struct {
+	ID string `json:"id"`
+}
+
+// errorResponse 出错时的响应
+type errorResponse struct {
+	Error  string       `json:"error"`
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+// fieldError 请求中某个字段的错误
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErr 请求未通过校验
+type validationErr []fieldError
+
+func (fields validationErr) Error() string {
+	msgs := make([]string, 0, len(fields))
+	for _, f := range fields {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// bindTaskRequest 按Content-Type解析请求
+func bindTaskRequest(c *gin.Context) (taskRequest, error) {
+	if c.ContentType() == gin.MIMEJSON {
+		var req taskRequest
+		dec := json.NewDecoder(c.Request.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return req, validationErr{{Field: "body", Message: err.Error()}}
+		}
+		return req, nil
+	}
+	return bindTaskForm(c)
+}
+
+// bindTaskForm 解析multipart表单，任务描述在file字段
+func bindTaskForm(c *gin.Context) (taskRequest, error) {
+	req := taskRequest{ScheduledAt: c.PostForm("scheduled_at"), Window: c.PostForm("window"), Deadline: c.PostForm("deadline")}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return req, validationErr{{Field: "file", Message: "is required"}}
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return req, err
+	}
+	defer file.Close()
+
+	bytesArr, err := io.ReadAll(file)
+	if err != nil {
+		return req, err
+	}
+	req.Description = string(bytesArr)
+
+	concurrency, err := parseConcurrencyGroups(c.PostFormArray("concurrency"))
+	if err != nil {
+		return req, validationErr{{Field: "concurrency", Message: err.Error()}}
+	}
+	req.Concurrency = concurrency
+
+	params, err := parseParams(c.PostFormArray("param"))
+	if err != nil {
+		return req, validationErr{{Field: "param", Message: err.Error()}}
+	}
+	req.Params = params
+
+	labels, err := parseLabels(c.PostFormArray("label"))
+	if err != nil {
+		return req, validationErr{{Field: "label", Message: err.Error()}}
+	}
+	req.Labels = labels
+
+	return req, nil
+}
+
+// validate 校验请求，返回所有字段的错误
+func (req taskRequest) validate() error {
+	var fields validationErr
+
+	if strings.TrimSpace(req.Description) == "" {
+		fields = append(fields, fieldError{Field: "description", Message: "is required"})
+	} else {
+		var asMap map[string]any
+		if err := yaml.Unmarshal([]byte(req.Description), &asMap); err != nil {
+			fields = append(fields, fieldError{Field: "description", Message: "is not a yaml mapping: " + err.Error()})
+		}
+	}
+
+	if req.ScheduledAt != "" {
+		if _, err := time.Parse(scheduledAtLayout, req.ScheduledAt); err != nil {
+			fields = append(fields, fieldError{Field: "scheduled_at", Message: "should be like " + scheduledAtLayout})
+		}
+	}
+
+	if req.Deadline != "" {
+		deadline, err := time.Parse(scheduledAtLayout, req.Deadline)
+		if err != nil {
+			fields = append(fields, fieldError{Field: "deadline", Message: "should be like " + scheduledAtLayout})
+		} else if scheduledAt, err := time.Parse(scheduledAtLayout, req.ScheduledAt); err == nil && !deadline.After(scheduledAt) {
+			fields = append(fields, fieldError{Field: "deadline", Message: "should be after scheduled_at"})
+		}
+	}
+
+	seen := make(map[string]struct{}, len(req.Concurrency))
+	for i, group := range req.Concurrency {
+		field := fmt.Sprintf("concurrency[%d]", i)
+		if group.Key == "" {
+			fields = append(fields, fieldError{Field: field + ".key", Message: "is required"})
+		}
+		if group.Limit < 1 {
+			fields = append(fields, fieldError{Field: field + ".limit", Message: "should be positive"})
+		}
+		if _, dup := seen[group.Key]; dup {
+			fields = append(fields, fieldError{Field: field + ".key", Message: "is duplicated"})
+		}
+		seen[group.Key] = struct{}{}
+	}
+
+	names := make([]string, 0, len(req.Params))
+	for name := range req.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !paramNamePattern.MatchString(name) {
+			fields = append(fields, fieldError{Field: "params." + name, Message: "should be letters, digits or underscores"})
+		}
+	}
+
+	fields = append(fields, validateLabels(req.Labels)...)
+
+	if req.Window != "" {
+		if w, err := parseWindow(req.Window, time.Local); err != nil {
+			fields = append(fields, fieldError{Field: "window", Message: err.Error()})
+		} else if _, ok := w.next(time.Now()); !ok {
+			fields = append(fields, fieldError{Field: "window", Message: "never opens within a year"})
+		}
+	}
+
+	if len(fields) > 0 {
+		return fields
+	}
+	return nil
+}
+
+// rawTask 转为任务列表所需的结构
+func (req taskRequest) rawTask() common.RawTask {
+	return common.RawTask{
+		Description: req.Description,
+		ScheduledAt: req.ScheduledAt,
+		Concurrency: req.Concurrency,
+		Params:      req.Params,
+		Labels:      req.Labels,
+		Window:      req.Window,
+		Deadline:    req.Deadline,
+	}
+}
+
+// parseConcurrencyGroups 解析形如 key=limit 的并发组
+func parseConcurrencyGroups(values []string) ([]common.ConcurrencyGroup, error) {
+	groups := make([]common.ConcurrencyGroup, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		idx := strings.LastIndex(value, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("concurrency %q should be key=limit", value)
+		}
+		key := value[:idx]
+		limit, err := strconv.Atoi(value[idx+1:])
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("concurrency %q should have a positive limit", value)
+		}
+		if _, dup := seen[key]; dup {
+			return nil, fmt.Errorf("concurrency %q is duplicated", key)
+		}
+		seen[key] = struct{}{}
+		groups = append(groups, common.ConcurrencyGroup{Key: key, Limit: limit})
+	}
+	return groups, nil
+}
+
+// parseParams 解析形如 name=value 的任务参数
+func parseParams(values []string) (map[string]string, error) {
+	return parsePairs("param", values)
+}
+
+// parseLabels 解析形如 key=value 的任务标签
+func parseLabels(values []string) (map[string]string, error) {
+	return parsePairs("label", values)
+}
+
+// parsePairs 解析形如 name=value 的键值对，kind用于错误信息
+func parsePairs(kind string, values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	pairs := make(map[string]string, len(values))
+	for _, value := range values {
+		idx := strings.Index(value, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("%s %q should be name=value", kind, value)
+		}
+		name := value[:idx]
+		if _, dup := pairs[name]; dup {
+			return nil, fmt.Errorf("%s %q is duplicated", kind, name)
+		}
+		pairs[name] = value[idx+1:]
+	}
+	return pairs, nil
+}
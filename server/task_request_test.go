@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+func TestTaskRequestValidate(t *testing.T) {
+	req := taskRequest{
+		Description: "input: [",
+		ScheduledAt: "tomorrow",
+		Concurrency: []common.ConcurrencyGroup{{Key: "a", Limit: 0}, {Key: "a", Limit: 1}},
+		Params:      map[string]string{"ok_name": "1", "bad-name": "2"},
+		Labels:      map[string]string{"team": "data", "to=ch": "prod"},
+		Window:      "* 22-6 * * *",
+		Deadline:    "8am",
+	}
+
+	var fields validationErr
+	if !errors.As(req.validate(), &fields) {
+		t.Fatal("invalid request should fail validation")
+	}
+
+	expected := []string{"description", "scheduled_at", "deadline", "concurrency[0].limit", "concurrency[1].key", "params.bad-name", "labels.to=ch", "window"}
+	if len(fields) != len(expected) {
+		t.Fatalf("unexpected errors: %v", fields)
+	}
+	for i, field := range expected {
+		if fields[i].Field != field {
+			t.Errorf("error %d should be on %s, got %s", i, field, fields[i].Field)
+		}
+	}
+
+	ok := taskRequest{Description: "input:\n  generate: {}\n", ScheduledAt: "2023-12-31 00:00:00", Window: "TZ=Asia/Shanghai * 0-5,22-23 * * 1-5", Deadline: "2023-12-31 08:00:00"}
+	if err := ok.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	early := ok
+	early.Deadline = "2023-12-30 08:00:00"
+	if err := early.validate(); err == nil {
+		t.Error("deadline before scheduled_at should be rejected")
+	}
+}
+
+func TestOpenapiDocument(t *testing.T) {
+	api := &ApplicationInterface{}
+	doc := openapiDocument(api.routes())
+	paths := doc["paths"].(map[string]any)
+
+	item, ok := paths["/tasks/{id}/status"].(map[string]any)
+	if !ok {
+		t.Fatalf("path params should be converted: %v", paths)
+	}
+	if _, ok := item["get"]; !ok {
+		t.Fatal("status should be documented as get")
+	}
+
+	post := paths["/tasks"].(map[string]any)["post"].(map[string]any)
+	if _, ok := post["responses"].(map[string]any)["201"]; !ok {
+		t.Fatal("task creation should respond 201")
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/turnon/clams/secret"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// taskLogger 把stream的日志写入任务日志
+type taskLogger struct {
+	ctx      context.Context
+	task     common.Task
+	resolved secret.Resolved
+}
+
+func (l *taskLogger) Printf(format string, v ...any) {
+	l.write(fmt.Sprintf(format, v...))
+}
+
+func (l *taskLogger) Println(v ...any) {
+	l.write(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func (l *taskLogger) write(message string) {
+	l.task.Log(l.ctx, l.resolved.Scrub(message))
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// windowSearch 查找下一个允许执行的时间时最多往后找这么久
+const windowSearch = 366 * 24 * time.Hour
+
+// window 类似cron的时间段：分 时 日 月 周，可以用TZ=<时区>开头；
+// 各字段可以是*、n、a-b、*/s、a-b/s或用逗号连接，日和周都有限制时满足其一即可
+type window struct {
+	spec     string
+	location *time.Location
+
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+// parseWindow 解析时间段，没有TZ=时按location理解
+func parseWindow(spec string, location *time.Location) (*window, error) {
+	w := &window{spec: spec, location: location}
+
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "TZ=") {
+		loc, err := time.LoadLocation(strings.TrimPrefix(fields[0], "TZ="))
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", spec, err)
+		}
+		w.location = loc
+		fields = fields[1:]
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("window %q should have 5 fields: minute hour day month weekday", spec)
+	}
+
+	var err error
+	if w.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("window %q minute: %w", spec, err)
+	}
+	if w.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("window %q hour: %w", spec, err)
+	}
+	if w.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("window %q day: %w", spec, err)
+	}
+	if w.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("window %q month: %w", spec, err)
+	}
+	if w.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("window %q weekday: %w", spec, err)
+	}
+	// 周日可以写作0或7
+	if w.weekdays&(1<<7) != 0 {
+		w.weekdays |= 1
+	}
+	w.anyDay = fields[2] == "*"
+	w.anyWeekday = fields[4] == "*"
+	return w, nil
+}
+
+// parseCronField 解析一个字段为位集合
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:idx], n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q should be within %d-%d", part, min, max)
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+// allows t所在的这一分钟是否在时间段内
+func (w *window) allows(t time.Time) bool {
+	t = t.In(w.location)
+	return w.months&(1<<int(t.Month())) != 0 && w.dayMatches(t) &&
+		w.hours&(1<<t.Hour()) != 0 && w.minutes&(1<<t.Minute()) != 0
+}
+
+// dayMatches 日和周，与cron一样，两者都有限制时满足其一即可
+func (w *window) dayMatches(t time.Time) bool {
+	day := w.days&(1<<t.Day()) != 0
+	weekday := w.weekdays&(1<<int(t.Weekday())) != 0
+	switch {
+	case w.anyDay && w.anyWeekday:
+		return true
+	case w.anyDay:
+		return weekday
+	case w.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// next t之后（含t所在的分钟）最早允许执行的时间，windowSearch内都不允许时返回false
+func (w *window) next(t time.Time) (time.Time, bool) {
+	t = t.In(w.location).Truncate(time.Minute)
+	limit := t.Add(windowSearch)
+
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case w.months&(1<<int(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, w.location)
+		case !w.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, w.location)
+		case w.hours&(1<<t.Hour()) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, w.location)
+		case w.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextOutside t之后（含t所在的分钟）最早不在时间段内的时间，windowSearch内都在时返回false
+func (w *window) nextOutside(t time.Time) (time.Time, bool) {
+	t = t.In(w.location).Truncate(time.Minute)
+	limit := t.Add(windowSearch)
+
+	for t.Before(limit) {
+		if !w.allows(t) {
+			return t, true
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+func TestParseWindow(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 5-1 * * *", "* */0 * * *", "TZ=Nowhere/City * * * * *"} {
+		if _, err := parseWindow(spec, time.UTC); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+
+	w, err := parseWindow("TZ=Asia/Shanghai */15 0-5,22-23 * * 1-5", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+
+	// 2023-06-05是周一
+	cases := map[string]bool{
+		"2023-06-05 23:15": true,
+		"2023-06-05 23:16": false,
+		"2023-06-05 12:00": false,
+		"2023-06-06 05:45": true,
+		"2023-06-10 01:00": false,
+	}
+	for at, allowed := range cases {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", at, shanghai)
+		if w.allows(tm) != allowed {
+			t.Errorf("%s allowed should be %v", at, allowed)
+		}
+	}
+
+	from, _ := time.ParseInLocation("2006-01-02 15:04", "2023-06-09 06:00", shanghai)
+	next, ok := w.next(from)
+	if !ok || next.In(shanghai).Format("2006-01-02 15:04") != "2023-06-09 22:00" {
+		t.Errorf("next window should open friday night, got %v", next)
+	}
+	from, _ = time.ParseInLocation("2006-01-02 15:04", "2023-06-10 00:00", shanghai)
+	if next, _ := w.next(from); next.In(shanghai).Format("2006-01-02 15:04") != "2023-06-12 00:00" {
+		t.Errorf("weekend should be skipped, got %v", next)
+	}
+
+	// 日和周都有限制时满足其一即可
+	w, _ = parseWindow("0 0 1 * 0", time.UTC)
+	if !w.allows(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)) || !w.allows(time.Date(2023, 6, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Error("either day or weekday should match")
+	}
+}
+
+// windowTask 只有执行时间段和并发组的任务
+type windowTask struct {
+	common.Task
+	window string
+	groups []common.ConcurrencyGroup
+}
+
+func (task *windowTask) Window() string                         { return task.window }
+func (task *windowTask) Concurrency() []common.ConcurrencyGroup { return task.groups }
+
+func TestRunScheduleDeferral(t *testing.T) {
+	if _, err := newRunSchedule(scheduleConfig{Blackouts: []blackoutConfig{{From: "2023-06-05 00:00:00"}}}); err == nil {
+		t.Error("blackout without end should be rejected")
+	}
+	if _, err := newRunSchedule(scheduleConfig{Windows: map[string]string{"db": "* * 31 2 *"}}); err == nil {
+		t.Error("window never opening should be rejected")
+	}
+
+	schedule, err := newRunSchedule(scheduleConfig{
+		Timezone: "UTC",
+		Windows:  map[string]string{"mysql-prod": "* 0-5 * * *"},
+		Blackouts: []blackoutConfig{
+			{From: "2023-06-06 00:00:00", To: "2023-06-06 02:00:00", Reason: "maintenance"},
+			{Cron: "* 4 * * *"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		return tm
+	}
+	mysql := []common.ConcurrencyGroup{{Key: "mysql-prod", Limit: 2}}
+
+	if until, _, err := schedule.deferral(&windowTask{groups: mysql}, at("2023-06-05 01:00")); err != nil || !until.IsZero() {
+		t.Errorf("task within window should run now, got %v %v", until, err)
+	}
+	if until, _, _ := schedule.deferral(&windowTask{}, at("2023-06-05 12:00")); !until.IsZero() {
+		t.Errorf("task without window should run now, got %v", until)
+	}
+
+	until, reason, err := schedule.deferral(&windowTask{groups: mysql}, at("2023-06-05 12:00"))
+	if err != nil || !until.Equal(at("2023-06-06 02:00")) || !strings.Contains(reason, "outside window") {
+		t.Errorf("task should wait for the window and then the blackout, got %v %q %v", until, reason, err)
+	}
+	until, reason, _ = schedule.deferral(&windowTask{}, at("2023-06-06 01:00"))
+	if !until.Equal(at("2023-06-06 02:00")) || !strings.Contains(reason, "maintenance") {
+		t.Errorf("task should wait for the blackout, got %v %q", until, reason)
+	}
+	until, _, _ = schedule.deferral(&windowTask{window: "* 3-4 * * *"}, at("2023-06-05 04:30"))
+	if !until.Equal(at("2023-06-06 03:00")) {
+		t.Errorf("task window and cron blackout should both apply, got %v", until)
+	}
+
+	if _, _, err := schedule.deferral(&windowTask{window: "bad"}, at("2023-06-05 01:00")); err == nil {
+		t.Error("invalid task window should be an error")
+	}
+}
@@ -3,65 +3,280 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"runtime/debug"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
-	"github.com/benthosdev/benthos/v4/public/service"
 	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/guard"
+	"github.com/turnon/clams/secret"
 	"github.com/turnon/clams/tasklist/common"
 	"github.com/turnon/clams/util"
 )
 
+// workerHeartbeat worker登记心跳的间隔，超过三个间隔没有心跳视为失联
+const workerHeartbeat = 10 * time.Second
+
 // workteam 工作组
 type workteam struct {
+	ctx        context.Context
+	taskslist  common.Tasklist
+	namespaces *namespaces
+	secrets    *secret.Resolver
+	isolation  isolationConfig
+	schedule   *runSchedule
+	metrics    *taskMetrics
+	running    chan struct{}
+
+	lock    sync.Mutex
 	workers []*taskWorker
-	running chan struct{}
+	nextIdx int
+	group   sync.WaitGroup
+
+	// beat 一轮心跳和注销worker互斥，避免心跳在注销之后重新登记；heartbeating 心跳停止时关闭
+	beat         sync.Mutex
+	heartbeating chan struct{}
 }
 
 // newWorkteam 创建工作组
-func newWorkteam(ctx context.Context, taskslist common.Tasklist, workerCount int, anchors string) *workteam {
+func newWorkteam(ctx context.Context, taskslist common.Tasklist, workerCount int, nss *namespaces, secrets *secret.Resolver, isolation isolationConfig, schedule *runSchedule, metrics *taskMetrics) *workteam {
 	team := &workteam{
-		workers: make([]*taskWorker, 0, workerCount),
-		running: make(chan struct{}),
-	}
+		ctx:        ctx,
+		taskslist:  taskslist,
+		namespaces: nss,
+		secrets:    secrets,
+		isolation:  isolation,
+		schedule:   schedule,
+		metrics:    metrics,
+		running:    make(chan struct{}),
+		workers:    make([]*taskWorker, 0, workerCount),
 
-	for i := 0; i < workerCount; i++ {
-		team.workers = append(team.workers, newTaskWorker(ctx, i, anchors, taskslist))
+		heartbeating: make(chan struct{}),
 	}
 
+	team.resize(workerCount)
+	team.heartbeat(ctx)
+
 	go func() {
-		for _, w := range team.workers {
-			<-w.running
-		}
+		<-ctx.Done()
+		team.group.Wait()
 		close(team.running)
 	}()
 
 	return team
 }
 
+// size 未退役的worker数
+func (team *workteam) size() int {
+	team.lock.Lock()
+	defer team.lock.Unlock()
+
+	n := 0
+	for _, w := range team.workers {
+		if !w.retired() {
+			n++
+		}
+	}
+	return n
+}
+
+// resize 调整worker数，增加时启动新worker，减少时优先退役空闲的worker，忙碌的worker做完当前任务再退出
+func (team *workteam) resize(workerCount int) {
+	team.lock.Lock()
+	defer team.lock.Unlock()
+
+	if team.ctx.Err() != nil {
+		return
+	}
+
+	active := make([]*taskWorker, 0, len(team.workers))
+	for _, w := range team.workers {
+		if !w.retired() {
+			active = append(active, w)
+		}
+	}
+
+	for i := len(active); i < workerCount; i++ {
+		w := newTaskWorker(team.ctx, team.nextIdx, team.namespaces, team.secrets, team.isolation, team.schedule, team.metrics, team.taskslist)
+		team.nextIdx++
+		team.workers = append(team.workers, w)
+		team.group.Add(1)
+		go team.remove(w)
+	}
+
+	if excess := len(active) - workerCount; excess > 0 {
+		sort.SliceStable(active, func(i, j int) bool {
+			return active[i].info().TaskID == "" && active[j].info().TaskID != ""
+		})
+		for _, w := range active[:excess] {
+			w.retire()
+		}
+	}
+}
+
+// remove worker退出后移出工作组并注销
+func (team *workteam) remove(w *taskWorker) {
+	defer team.group.Done()
+	<-w.running
+
+	// 停机时先等心跳停止，退役时等当前这轮心跳结束，再移出和注销
+	if team.ctx.Err() != nil {
+		<-team.heartbeating
+	}
+	team.beat.Lock()
+	defer team.beat.Unlock()
+
+	team.lock.Lock()
+	for i, member := range team.workers {
+		if member == w {
+			team.workers = append(team.workers[:i], team.workers[i+1:]...)
+			break
+		}
+	}
+	team.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	team.taskslist.UnregisterWorker(ctx, w.id)
+}
+
+// members 当前的worker，包括正在退役的
+func (team *workteam) members() []*taskWorker {
+	team.lock.Lock()
+	defer team.lock.Unlock()
+	return append([]*taskWorker(nil), team.workers...)
+}
+
+// heartbeat 定期登记各worker的状态
+func (team *workteam) heartbeat(ctx context.Context) {
+	go func() {
+		defer close(team.heartbeating)
+
+		ticker := time.NewTicker(workerHeartbeat)
+		defer ticker.Stop()
+
+		for {
+			team.beat.Lock()
+			for _, w := range team.members() {
+				if err := team.taskslist.Heartbeat(ctx, w.info()); err != nil && ctx.Err() == nil {
+					log.Error().Str("mod", "workteam").Str("id", w.id).Msgf("heartbeat: %v", err)
+				}
+			}
+			team.beat.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
 // wait 等待worker退出
 func (team *workteam) wait() chan struct{} {
 	return team.running
 }
 
+// ready 至少有一个worker仍在领取任务
+func (team *workteam) ready() error {
+	var lastErr error
+	for _, w := range team.members() {
+		select {
+		case <-w.running:
+			continue
+		default:
+		}
+		if w.retired() {
+			continue
+		}
+		if err := w.readErr(); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	if lastErr != nil {
+		return fmt.Errorf("no worker is claiming tasks: %w", lastErr)
+	}
+	return errors.New("no worker is running")
+}
+
 // taskWorker worker
 type taskWorker struct {
-	ctx       context.Context
-	taskslist common.Tasklist
-	id        string
-	anchors   string
-	running   chan struct{}
+	ctx        context.Context
+	readCtx    context.Context
+	retire     context.CancelFunc
+	taskslist  common.Tasklist
+	id         string
+	namespaces *namespaces
+	secrets    *secret.Resolver
+	isolation  isolationConfig
+	schedule   *runSchedule
+	metrics    *taskMetrics
+	running    chan struct{}
+	startedAt  time.Time
+
+	lock    sync.Mutex
+	current string
+	lastErr error
 }
 
-// newTaskWorker 创建worker
-func newTaskWorker(ctx context.Context, idx int, anchors string, taskslist common.Tasklist) *taskWorker {
+// newTaskWorker 创建worker，retire只停止领取新任务，不影响正在执行的任务
+func newTaskWorker(ctx context.Context, idx int, nss *namespaces, secrets *secret.Resolver, isolation isolationConfig, schedule *runSchedule, metrics *taskMetrics, taskslist common.Tasklist) *taskWorker {
 	hostname, _ := os.Hostname()
 	id := hostname + ":" + strconv.Itoa(os.Getpid()) + ":" + strconv.Itoa(idx)
-	worker := &taskWorker{taskslist: taskslist, ctx: ctx, id: id, anchors: anchors}
+	worker := &taskWorker{taskslist: taskslist, ctx: ctx, id: id, namespaces: nss, secrets: secrets, isolation: isolation, schedule: schedule, metrics: metrics, startedAt: time.Now()}
+	worker.readCtx, worker.retire = context.WithCancel(ctx)
 	worker.loop()
 	return worker
 }
 
+// retired 是否已退役
+func (worker *taskWorker) retired() bool {
+	return worker.readCtx.Err() != nil
+}
+
+// info 当前状态，用于登记心跳
+func (worker *taskWorker) info() common.WorkerInfo {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+
+	hostname, _ := os.Hostname()
+	return common.WorkerInfo{
+		ID:        worker.id,
+		Host:      hostname,
+		Pid:       os.Getpid(),
+		StartedAt: worker.startedAt,
+		TaskID:    worker.current,
+	}
+}
+
+// setCurrent 记录正在执行的任务
+func (worker *taskWorker) setCurrent(taskID string) {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+	worker.current = taskID
+}
+
+// setReadErr 记录最近一次领取任务的错误
+func (worker *taskWorker) setReadErr(err error) {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+	worker.lastErr = err
+}
+
+// readErr 最近一次领取任务的错误
+func (worker *taskWorker) readErr() error {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+	return worker.lastErr
+}
+
 // logDebug 输出日志
 func (worker *taskWorker) logDebug(str string, v ...any) {
 	log.Debug().Str("mod", "taskWorker").Str("id", worker.id).Msgf(str, v...)
@@ -80,49 +295,143 @@ func (worker *taskWorker) loop() {
 		defer close(worker.running)
 
 		for {
-			task, err := worker.taskslist.Read(worker.ctx)
+			task, err := worker.taskslist.Read(worker.readCtx, worker.id)
 			if errors.Is(err, context.Canceled) {
 				return
 			}
+			worker.setReadErr(err)
 			if err != nil {
 				worker.logDebug("read task %p %v", task, err)
 				continue
 			}
+			if worker.deferred(task) {
+				continue
+			}
 
+			worker.setCurrent(task.ID())
 			worker.execute(task)
+			worker.setCurrent("")
 		}
 	}()
 }
 
-// execute 执行任务
+// deferred 任务不在允许执行的时间内时放回并推迟，不算作失败；任务的时间段有误或放不回去时任务失败，以免一直停在运行中
+func (worker *taskWorker) deferred(task common.Task) bool {
+	if worker.schedule == nil {
+		return false
+	}
+
+	until, reason, err := worker.schedule.deferral(task, time.Now())
+	if err != nil {
+		task.Error(worker.ctx, err)
+		return true
+	}
+	if until.IsZero() {
+		return false
+	}
+
+	worker.logInfo("defer task %v until %v: %s", task.ID(), until, reason)
+	if err := task.Defer(worker.ctx, until, reason); err != nil {
+		log.Error().Str("mod", "taskWorker").Str("id", worker.id).Msgf("defer task %v: %v", task.ID(), err)
+		task.Error(worker.ctx, fmt.Errorf("defer until %v (%s): %w", until, reason, err))
+	}
+	return true
+}
+
+// execute 执行任务，panic时只让该任务失败
 func (worker *taskWorker) execute(task common.Task) {
-	var err error
+	var (
+		err      error
+		resolved secret.Resolved
+	)
 
 	worker.logInfo("executeTask start: %v", task.ID())
 	defer worker.logInfo("executeTask end: %v, %v", task.ID(), err)
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := &guard.PanicError{Value: r, Stack: debug.Stack()}
+			worker.logInfo("executeTask panic: %v, %v", task.ID(), r)
+			task.Error(worker.ctx, resolved.ScrubErr(panicErr))
+		}
+	}()
 
-	builder := service.NewStreamBuilder()
+	task.Log(worker.ctx, "performed by "+worker.id)
 
-	taskDesc, err := util.InterpolateYamlAnchor(worker.anchors, task.Description())
+	set, err := worker.namespaces.anchorSet(worker.ctx, task.Namespace())
 	if err != nil {
 		task.Error(worker.ctx, err)
 		return
 	}
+	anchors, revision := set.current()
+	if err = task.UseAnchors(worker.ctx, revision); err != nil {
+		task.Error(worker.ctx, err)
+		return
+	}
 
-	err = builder.SetYAML(taskDesc)
+	taskDesc, err := util.InterpolateYamlAnchor(anchors, task.Description())
 	if err != nil {
 		task.Error(worker.ctx, err)
 		return
 	}
 
-	stream, err := builder.Build()
+	resolved, err = worker.secrets.Resolve(taskDesc, secretScope(task.Namespace()))
 	if err != nil {
 		task.Error(worker.ctx, err)
 		return
 	}
 
+	defer worker.metrics.remove(task.ID())
+	if worker.isolation.Enabled {
+		err = runIsolated(worker.ctx, task, resolved, worker.isolation, worker.metrics)
+	} else {
+		err = runStream(worker.ctx, task, resolved, worker.metrics)
+	}
+	if err != nil {
+		task.Error(worker.ctx, resolved.ScrubErr(err))
+		return
+	}
+
+	task.Done(worker.ctx)
+}
+
+// runStream 在当前进程中运行任务的stream，直到结束或任务被中止
+func runStream(ctx context.Context, task common.Task, resolved secret.Resolved, metrics *taskMetrics) error {
+	failure := newTaskFailure()
+	env, err := taskEnvironment(task, failure, metrics)
+	if err != nil {
+		return err
+	}
+
+	builder := env.NewStreamBuilder()
+	builder.SetPrintLogger(&taskLogger{ctx: ctx, task: task, resolved: resolved})
+	builder.SetEnvVarLookupFunc(taskEnvLookup(task, resolved.LookupEnv))
+
+	text, err := withTaskMetadata(resolved.Text)
+	if err != nil {
+		return err
+	}
+	if err := builder.SetYAML(text); err != nil {
+		return err
+	}
+	if metrics != nil && !hasMetricsSection(text) {
+		if err := builder.SetMetricsYAML(taskMetricsExporter + ": {}"); err != nil {
+			return err
+		}
+	}
+	if err := builder.AddCacheYAML(checkpointCacheYAML); err != nil {
+		return err
+	}
+	if err := builder.AddCacheYAML(guardCacheYAML); err != nil {
+		return err
+	}
+
+	stream, err := builder.Build()
+	if err != nil {
+		return err
+	}
+
 	// listen to abort
-	ctx, cancel := context.WithCancel(worker.ctx)
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	go func() {
@@ -130,15 +439,15 @@ func (worker *taskWorker) execute(task common.Task) {
 		case <-ctx.Done():
 		case <-task.Aborted():
 			stream.Stop(context.Background())
+		case <-failure.failed:
+			stream.StopWithin(10 * time.Second)
 		}
 		cancel()
 	}()
 
 	err = stream.Run(ctx)
-	if err != nil {
-		task.Error(worker.ctx, err)
-		return
+	if failed := failure.err(); failed != nil {
+		return failed
 	}
-
-	task.Done(ctx)
+	return err
 }
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// idleTasklist 没有任务可领的任务列表，记录注销的worker
+type idleTasklist struct {
+	common.Tasklist
+	lock         sync.Mutex
+	unregistered []string
+}
+
+func (list *idleTasklist) Read(ctx context.Context, workerID string) (common.Task, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (list *idleTasklist) Heartbeat(ctx context.Context, info common.WorkerInfo) error {
+	return nil
+}
+
+func (list *idleTasklist) UnregisterWorker(ctx context.Context, id string) error {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.unregistered = append(list.unregistered, id)
+	return nil
+}
+
+func TestWorkteamResize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	list := &idleTasklist{}
+	team := newWorkteam(ctx, list, 2, nil, nil, isolationConfig{}, nil, nil)
+
+	team.resize(3)
+	if n := team.size(); n != 3 {
+		t.Fatalf("team should grow to 3, got %d", n)
+	}
+
+	team.resize(1)
+	if n := team.size(); n != 1 {
+		t.Fatalf("team should shrink to 1, got %d", n)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(team.members()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(team.members()); n != 1 {
+		t.Fatalf("retired workers should leave the team, %d left", n)
+	}
+
+	cancel()
+	select {
+	case <-team.wait():
+	case <-time.After(time.Second):
+		t.Fatal("team should stop with the context")
+	}
+
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	if len(list.unregistered) != 3 {
+		t.Errorf("every worker should be unregistered, got %v", list.unregistered)
+	}
+}
+
+// slowHeartbeatTasklist 第一轮心跳卡住直到release关闭，记录仍登记着的worker
+type slowHeartbeatTasklist struct {
+	idleTasklist
+	entered    chan struct{}
+	release    chan struct{}
+	once       sync.Once
+	registered map[string]bool
+}
+
+func (list *slowHeartbeatTasklist) Heartbeat(ctx context.Context, info common.WorkerInfo) error {
+	list.once.Do(func() {
+		close(list.entered)
+		<-list.release
+	})
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.registered[info.ID] = true
+	return nil
+}
+
+func (list *slowHeartbeatTasklist) UnregisterWorker(ctx context.Context, id string) error {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	delete(list.registered, id)
+	return nil
+}
+
+func TestWorkteamUnregistersAfterHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	list := &slowHeartbeatTasklist{entered: make(chan struct{}), release: make(chan struct{}), registered: map[string]bool{}}
+	team := newWorkteam(ctx, list, 2, nil, nil, isolationConfig{}, nil, nil)
+
+	// 心跳进行中时停机，心跳完成后才注销
+	<-list.entered
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(list.release)
+
+	select {
+	case <-team.wait():
+	case <-time.After(time.Second):
+		t.Fatal("team should stop with the context")
+	}
+
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	if len(list.registered) != 0 {
+		t.Errorf("no worker should stay registered after shutdown, got %v", list.registered)
+	}
+}
+
+// deferTask 记录被推迟到何时
+type deferTask struct {
+	windowTask
+	until    time.Time
+	reason   string
+	deferErr error
+	err      error
+}
+
+func (task *deferTask) ID() string { return "1" }
+
+func (task *deferTask) Defer(ctx context.Context, until time.Time, reason string) error {
+	task.until, task.reason = until, reason
+	return task.deferErr
+}
+
+func (task *deferTask) Error(ctx context.Context, err error) error {
+	task.err = err
+	return nil
+}
+
+func TestWorkerDefersTask(t *testing.T) {
+	now := time.Now().UTC()
+	schedule, err := newRunSchedule(scheduleConfig{Timezone: "UTC", Blackouts: []blackoutConfig{{
+		From: now.Add(-time.Hour).Format(scheduledAtLayout),
+		To:   now.Add(time.Hour).Format(scheduledAtLayout),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := &taskWorker{ctx: context.Background(), schedule: schedule}
+
+	task := &deferTask{}
+	if !worker.deferred(task) || task.until.Before(now) || task.err != nil {
+		t.Fatalf("task in blackout should be deferred without failing, got %+v", task)
+	}
+
+	task = &deferTask{deferErr: errors.New("connection reset")}
+	if !worker.deferred(task) || !errors.Is(task.err, task.deferErr) {
+		t.Fatalf("task that can not be put back should fail instead of staying running, got %+v", task)
+	}
+
+	task = &deferTask{windowTask: windowTask{window: "bad"}}
+	if !worker.deferred(task) || task.err == nil {
+		t.Errorf("task with invalid window should fail, got %+v", task)
+	}
+
+	worker.schedule, _ = newRunSchedule(scheduleConfig{})
+	if worker.deferred(&deferTask{}) {
+		t.Error("task should run without windows or blackouts")
+	}
+}
@@ -1,24 +1,236 @@
 package common
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+// DefaultNamespace 未指定命名空间的任务和锚点所在的命名空间
+const DefaultNamespace = "default"
+
+// 任务状态
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateError     = "error"
+	StateCancelled = "cancelled"
+)
+
+// Tasklist 任务列表；以id操作任务的方法先给出命名空间，任务不在其中时为ErrNotFound，为空时不限
 type Tasklist interface {
-	Read(context.Context) (Task, error)
-	Write(context.Context, RawTask) error
-	Delete(context.Context, string) error
-	Peek(context.Context, string) (RawTask, error)
+	Read(context.Context, string) (Task, error)
+	Write(context.Context, RawTask) (string, error)
+	WriteBatch(context.Context, []RawTask) ([]string, error)
+	Delete(context.Context, string, string) error
+	Peek(context.Context, string, string) (RawTask, error)
 	Close(context.Context) error
+	Ping(context.Context) error
+	List(context.Context, ListOptions) ([]TaskInfo, error)
+	Count(context.Context, ListOptions) (int, error)
+	Status(context.Context, string, string) (TaskInfo, error)
+	Rerun(context.Context, string, string) error
+	CancelMatching(context.Context, ListOptions) ([]string, error)
+	Edit(context.Context, string, string, string, func(RawTask) (RawTask, error)) error
+	Revisions(context.Context, string, string) ([]TaskRevision, error)
+	Logs(context.Context, string, string) ([]LogLine, error)
+	// MarkOverdue 把过了截止时间仍未结束的任务标为逾期，返回这次新标记的和之前标记但还没通知到的任务
+	MarkOverdue(context.Context, time.Time) ([]TaskInfo, error)
+	// OverdueNotified 记下逾期通知已送达，之后MarkOverdue不再返回该任务
+	OverdueNotified(context.Context, string) error
+
+	Export(context.Context, ListOptions, func([]TaskRecord) error) error
+	Import(context.Context, []TaskRecord) ([]string, error)
+
+	Heartbeat(context.Context, WorkerInfo) error
+	UnregisterWorker(context.Context, string) error
+	Workers(context.Context, time.Duration) ([]WorkerInfo, error)
+	// RequeueOrphans 把执行的worker已超过timeout没有心跳的运行中任务放回待执行，返回放回的任务id
+	RequeueOrphans(ctx context.Context, timeout time.Duration) ([]string, error)
+
+	Purge(context.Context, PurgePolicy, func([]TaskRecord) error) (int, error)
+
+	Audit(context.Context, AuditEntry) error
+	AuditLog(context.Context, AuditOptions) ([]AuditEntry, error)
+
+	Elect(context.Context, string, string) (Leadership, error)
+
+	// 锚点按命名空间隔离，为空时即DefaultNamespace
+	ListAnchors(context.Context, string) (AnchorSet, error)
+	AnchorHistory(context.Context, string, string) ([]Anchor, error)
+	PutAnchor(context.Context, string, string, string) (Anchor, error)
+	DeleteAnchor(context.Context, string, string) error
+	AnchorsChanged() chan struct{}
+}
+
+// Leadership 选举胜出后持有的领导权
+type Leadership interface {
+	// Lost 失去领导权时关闭
+	Lost() chan struct{}
+	// Resign 让出领导权
+	Resign(context.Context) error
 }
 
 type RawTask struct {
+	// Namespace 任务所在的命名空间，为空时即DefaultNamespace，修改任务时不变
+	Namespace   string
 	Description string
 	ScheduledAt string
+	Concurrency []ConcurrencyGroup
+	Params      map[string]string
+	Labels      map[string]string
+	// Window 允许执行的时间段，由服务器解释，为空时不限
+	Window string
+	// Deadline 应当结束的时间，格式同ScheduledAt，为空时不限
+	Deadline string
+}
+
+// ConcurrencyGroup 同一Key下最多同时运行Limit个任务
+type ConcurrencyGroup struct {
+	Key   string `json:"key"`
+	Limit int    `json:"limit"`
 }
 
 type Task interface {
 	ID() string
+	Namespace() string
 	Description() string
+	Params() map[string]string
+	Window() string
+	Concurrency() []ConcurrencyGroup
 	Aborted() chan struct{}
 	Done(context.Context) error
 	Error(context.Context, error) error
+	// Defer 放回未开始的任务，到时再领取，不算作失败
+	Defer(context.Context, time.Time, string) error
+	UseAnchors(context.Context, int) error
+	Log(context.Context, string) error
+	Checkpoint(context.Context, string) (string, error)
+	SaveCheckpoint(context.Context, string, string) error
+}
+
+// TaskInfo 任务的状态
+type TaskInfo struct {
+	ID             string             `json:"id"`
+	Namespace      string             `json:"namespace"`
+	State          string             `json:"state"`
+	CreatedAt      *time.Time         `json:"created_at"`
+	ScheduledAt    *time.Time         `json:"scheduled_at"`
+	PerformedAt    *time.Time         `json:"performed_at"`
+	FinishedAt     *time.Time         `json:"finished_at"`
+	CancelledAt    *time.Time         `json:"cancelled_at"`
+	Error          string             `json:"error"`
+	PerformedBy    string             `json:"performed_by"`
+	AnchorRevision *int               `json:"anchor_revision"`
+	Concurrency    []ConcurrencyGroup `json:"concurrency"`
+	Params         map[string]string  `json:"params,omitempty"`
+	Labels         map[string]string  `json:"labels,omitempty"`
+	Window         string             `json:"window,omitempty"`
+	Deadline       *time.Time         `json:"deadline,omitempty"`
+	OverdueAt      *time.Time         `json:"overdue_at,omitempty"`
+	Revision       int                `json:"revision"`
+	Checkpoints    map[string]string  `json:"checkpoints,omitempty"`
+}
+
+// TaskRevision 任务被修改前的一个版本，Revision从0开始，ReplacedBy为修改者
+type TaskRevision struct {
+	Revision    int                `json:"revision"`
+	Description string             `json:"description"`
+	ScheduledAt *time.Time         `json:"scheduled_at"`
+	Concurrency []ConcurrencyGroup `json:"concurrency"`
+	Params      map[string]string  `json:"params,omitempty"`
+	Labels      map[string]string  `json:"labels,omitempty"`
+	Window      string             `json:"window,omitempty"`
+	Deadline    *time.Time         `json:"deadline,omitempty"`
+	ReplacedAt  time.Time          `json:"replaced_at"`
+	ReplacedBy  string             `json:"replaced_by"`
+}
+
+// WorkerInfo 一个worker的状态，Alive表示最近有心跳
+type WorkerInfo struct {
+	ID          string     `json:"id"`
+	Host        string     `json:"host"`
+	Pid         int        `json:"pid"`
+	StartedAt   time.Time  `json:"started_at"`
+	HeartbeatAt *time.Time `json:"heartbeat_at"`
+	TaskID      string     `json:"task_id"`
+	Alive       bool       `json:"alive"`
+}
+
+// TaskRecord 任务的完整记录，用于归档和导入导出
+type TaskRecord struct {
+	TaskInfo
+	Description string         `json:"description"`
+	Logs        []LogLine      `json:"logs"`
+	Revisions   []TaskRevision `json:"revisions,omitempty"`
+}
+
+// PurgePolicy 已结束的任务保留多久，StateMaxAge按状态单独指定，0为不限
+type PurgePolicy struct {
+	MaxAge      time.Duration
+	StateMaxAge map[string]time.Duration
+	BatchSize   int
+}
+
+// ListOptions 列出任务的条件，Labels须全部符合，Overdue时只要已标为逾期的任务，Namespace为空时不限
+type ListOptions struct {
+	Namespace string
+	State     string
+	Labels    map[string]string
+	Overdue   bool
+	Limit     int
+	Offset    int
+}
+
+// AuditEntry 一次修改性api调用的记录，只追加不修改
+type AuditEntry struct {
+	ID       int64     `json:"id"`
+	At       time.Time `json:"at"`
+	Caller   string    `json:"caller"`
+	ClientIP string    `json:"client_ip"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target"`
+	Summary  string    `json:"summary"`
+	Status   int       `json:"status"`
+	Result   string    `json:"result"`
+}
+
+// AuditOptions 查询审计记录的条件，空的条件不限
+type AuditOptions struct {
+	Caller string
+	Action string
+	Target string
+	Since  time.Time
+	Limit  int
+	Offset int
+}
+
+// LogLine 任务执行时的一行日志
+type LogLine struct {
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
+}
+
+// Anchor 一个具名的yaml锚点，每次修改产生新版本
+type Anchor struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Revision  int       `json:"revision"`
+	Content   string    `json:"content"`
+	Deleted   bool      `json:"deleted"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AnchorSet 某一时刻所有生效的锚点，Revision为最后一次修改的全局序号
+type AnchorSet struct {
+	Revision int      `json:"revision"`
+	Anchors  []Anchor `json:"anchors"`
 }
@@ -0,0 +1,138 @@
+package pgtasklist
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// anchorLockSpace 修改锚点所用advisory lock的命名空间
+const anchorLockSpace = migrationLockSpace + 1
+
+// ListAnchors 列出命名空间中所有生效的锚点
+func (list *pgTaskList) ListAnchors(ctx context.Context, namespace string) (common.AnchorSet, error) {
+	sql := `
+	select distinct on (name) id, name, version, coalesce(content, ''), deleted, created_at
+	from anchors
+	where namespace = $1
+	order by name, version desc
+	`
+
+	set := common.AnchorSet{Anchors: []common.Anchor{}}
+	anchors, err := list.queryAnchors(ctx, sql, namespaceOr(namespace))
+	if err != nil {
+		return set, err
+	}
+
+	for _, anchor := range anchors {
+		if anchor.Revision > set.Revision {
+			set.Revision = anchor.Revision
+		}
+		if !anchor.Deleted {
+			set.Anchors = append(set.Anchors, anchor)
+		}
+	}
+	return set, nil
+}
+
+// AnchorHistory 列出锚点的所有版本
+func (list *pgTaskList) AnchorHistory(ctx context.Context, namespace string, name string) ([]common.Anchor, error) {
+	sql := `
+	select id, name, version, coalesce(content, ''), deleted, created_at
+	from anchors
+	where namespace = $1 and name = $2
+	order by version desc
+	`
+
+	anchors, err := list.queryAnchors(ctx, sql, namespaceOr(namespace), name)
+	if err != nil {
+		return nil, err
+	}
+	if len(anchors) == 0 {
+		return nil, common.ErrNotFound
+	}
+	return anchors, nil
+}
+
+// PutAnchor 新增或修改锚点
+func (list *pgTaskList) PutAnchor(ctx context.Context, namespace string, name string, content string) (common.Anchor, error) {
+	return list.appendAnchor(ctx, namespaceOr(namespace), name, content, false)
+}
+
+// DeleteAnchor 删除锚点，保留历史版本
+func (list *pgTaskList) DeleteAnchor(ctx context.Context, namespace string, name string) error {
+	_, err := list.appendAnchor(ctx, namespaceOr(namespace), name, "", true)
+	return err
+}
+
+// AnchorsChanged 监听锚点变化
+func (list *pgTaskList) AnchorsChanged() chan struct{} {
+	return list.anchorSignal
+}
+
+// appendAnchor 为锚点追加一个版本，并通知其他服务器
+func (list *pgTaskList) appendAnchor(ctx context.Context, namespace string, name string, content string, deleted bool) (common.Anchor, error) {
+	anchor := common.Anchor{Name: name, Content: content, Deleted: deleted}
+
+	tx, err := list.conn.Begin(ctx)
+	if err != nil {
+		return anchor, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, "select pg_advisory_xact_lock($1, hashtext($2))", anchorLockSpace, namespace+"/"+name); err != nil {
+		return anchor, err
+	}
+
+	var (
+		lastVersion int
+		lastDeleted bool
+	)
+	err = tx.QueryRow(ctx, "select version, deleted from anchors where namespace = $1 and name = $2 order by version desc limit 1", namespace, name).
+		Scan(&lastVersion, &lastDeleted)
+	if err != nil && err != pgx.ErrNoRows {
+		return anchor, err
+	}
+	if deleted && (err == pgx.ErrNoRows || lastDeleted) {
+		return anchor, common.ErrNotFound
+	}
+
+	sql := `
+	insert into anchors (namespace, name, version, content, deleted, created_at)
+	values ($1, $2, $3, $4, $5, $6)
+	returning id, version, created_at
+	`
+	err = tx.QueryRow(ctx, sql, namespace, name, lastVersion+1, content, deleted, time.Now()).
+		Scan(&anchor.Revision, &anchor.Version, &anchor.CreatedAt)
+	if err != nil {
+		return anchor, err
+	}
+
+	if _, err := tx.Exec(ctx, "select pg_notify('"+tasksChannel+"', $1)", "anchors"); err != nil {
+		return anchor, err
+	}
+
+	return anchor, tx.Commit(ctx)
+}
+
+// queryAnchors 查询锚点
+func (list *pgTaskList) queryAnchors(ctx context.Context, sql string, args ...any) ([]common.Anchor, error) {
+	rows, err := list.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	anchors := []common.Anchor{}
+	for rows.Next() {
+		var anchor common.Anchor
+		err := rows.Scan(&anchor.Revision, &anchor.Name, &anchor.Version, &anchor.Content, &anchor.Deleted, &anchor.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors, rows.Err()
+}
@@ -0,0 +1,59 @@
+package pgtasklist
+
+import (
+	"context"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// Audit 追加一条审计记录
+func (list *pgTaskList) Audit(ctx context.Context, entry common.AuditEntry) error {
+	sql := `
+	insert into audit_log (at, caller, client_ip, method, path, action, target, summary, status, result)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := list.conn.Exec(ctx, sql, entry.At, entry.Caller, entry.ClientIP, entry.Method, entry.Path,
+		entry.Action, entry.Target, entry.Summary, entry.Status, entry.Result)
+	return err
+}
+
+// AuditLog 查询审计记录，新的在前
+func (list *pgTaskList) AuditLog(ctx context.Context, opts common.AuditOptions) ([]common.AuditEntry, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+
+	var since *time.Time
+	if !opts.Since.IsZero() {
+		since = &opts.Since
+	}
+
+	sql := `
+	select id, at, coalesce(caller, ''), coalesce(client_ip, ''), coalesce(method, ''), coalesce(path, ''),
+		coalesce(action, ''), coalesce(target, ''), coalesce(summary, ''), coalesce(status, 0), coalesce(result, '')
+	from audit_log
+	where ($1 = '' or caller = $1)
+	and ($2 = '' or action = $2)
+	and ($3 = '' or target = $3)
+	and ($4::timestamp is null or at >= $4)
+	order by id desc
+	limit $5 offset $6
+	`
+	rows, err := list.conn.Query(ctx, sql, opts.Caller, opts.Action, opts.Target, since, opts.Limit, opts.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []common.AuditEntry{}
+	for rows.Next() {
+		var e common.AuditEntry
+		err := rows.Scan(&e.ID, &e.At, &e.Caller, &e.ClientIP, &e.Method, &e.Path, &e.Action, &e.Target, &e.Summary, &e.Status, &e.Result)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
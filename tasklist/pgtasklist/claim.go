@@ -0,0 +1,161 @@
+package pgtasklist
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// maxIdleWait 没有通知时最多等这么久再查一次，以防通知丢失
+const maxIdleWait = 5 * time.Minute
+
+// claimSQL 领取最早到期的一个任务；被其他worker锁住的行直接跳过，并发组已满的任务不在候选之列
+const claimSQL = `
+update tasks
+set performed_at = $1, performed_by = $2
+where id = (
+	select t.id
+	from tasks t
+	where t.performed_at is null
+	and t.finished_at is null
+	and t.cancelled_at is null
+	and t.scheduled_at <= $1
+	and not exists (
+		select 1
+		from task_concurrency c
+		where c.task_id = t.id
+		and c.lim <= (
+			select count(*)
+			from task_concurrency rc
+			join tasks rt on rt.id = rc.task_id
+			where rc.key = c.key
+			and rt.performed_at is not null
+			and rt.finished_at is null
+			and rt.cancelled_at is null
+		)
+	)
+	order by t.scheduled_at, t.id
+	limit 1
+	for update skip locked
+)
+returning id, namespace, description, coalesce(params, '{}'), coalesce(run_window, '')
+`
+
+// Read 返回一个任务，没有可执行的任务时等到有新任务的通知或下一个计划时间
+func (list *pgTaskList) Read(ctx context.Context, workerID string) (common.Task, error) {
+	for {
+		wake := list.waiting()
+
+		t, err := list.claim(ctx, workerID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// 避免pg不可用时空转
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+			}
+			return nil, err
+		}
+		if t != nil {
+			return t, nil
+		}
+
+		timer := time.NewTimer(list.untilNext(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// claim 在一个事务中领取任务并占用其并发组，没有可执行的任务时返回nil
+func (list *pgTaskList) claim(ctx context.Context, workerID string) (*pgTask, error) {
+	for {
+		t, err := list.claimOnce(ctx, workerID)
+		// 与其他worker同时占用同一并发组时，重新挑选
+		if errors.Is(err, errConcurrencyFull) {
+			continue
+		}
+		return t, err
+	}
+}
+
+// claimOnce 执行一次claimSQL
+func (list *pgTaskList) claimOnce(ctx context.Context, workerID string) (*pgTask, error) {
+	tx, err := list.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	t := &pgTask{list: list, workerID: workerID, aborted: make(chan struct{})}
+	err = tx.QueryRow(ctx, claimSQL, list.timeNowStr(), workerID).Scan(&t.id, &t.namespace, &t.description, &t.params, &t.window)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.concurrency, err = list.acquireConcurrency(ctx, tx, t.id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	list.runningTasks.set(t.id, t)
+	return t, nil
+}
+
+// untilNext 距离下一个未到期任务的时间；并发组满员的任务要等其他任务结束时的通知
+func (list *pgTaskList) untilNext(ctx context.Context) time.Duration {
+	sql := `
+	select min(scheduled_at)
+	from tasks
+	where performed_at is null
+	and finished_at is null
+	and cancelled_at is null
+	and scheduled_at > $1
+	`
+
+	var next *time.Time
+	if err := list.conn.QueryRow(ctx, sql, list.timeNowStr()).Scan(&next); err != nil || next == nil {
+		return maxIdleWait
+	}
+
+	// scheduled_at不带时区，按list.location理解
+	wait := time.Until(list.wallClock(*next))
+	if wait > maxIdleWait {
+		wait = maxIdleWait
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// waiting 返回下次有新任务时会关闭的通道
+func (list *pgTaskList) waiting() chan struct{} {
+	list.wakeLock.Lock()
+	defer list.wakeLock.Unlock()
+	return list.wake
+}
+
+// wakeAll 唤醒所有等待任务的worker
+func (list *pgTaskList) wakeAll() {
+	list.wakeLock.Lock()
+	defer list.wakeLock.Unlock()
+	close(list.wake)
+	list.wake = make(chan struct{})
+}
@@ -0,0 +1,268 @@
+package pgtasklist
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// newTestList 连接CLAMS_TEST_PG_URL指定的pg并清空任务，未指定时跳过
+func newTestList(tb testing.TB) *pgTaskList {
+	tb.Helper()
+
+	url := os.Getenv("CLAMS_TEST_PG_URL")
+	if url == "" {
+		tb.Skip("CLAMS_TEST_PG_URL is not set")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	list, err := Init(ctx, map[string]any{"url": url})
+	if err != nil {
+		cancel()
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		cancel()
+		list.Close(context.Background())
+	})
+
+	truncateTasks(tb, list)
+	return list
+}
+
+// truncateTasks 清空任务及其附属表
+func truncateTasks(tb testing.TB, list *pgTaskList) {
+	tb.Helper()
+	if _, err := list.conn.Exec(context.Background(), "truncate tasks, task_concurrency, task_logs, task_checkpoints, task_revisions"); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// writeTasks 写入n个立即可执行的任务
+func writeTasks(tb testing.TB, list *pgTaskList, n int, concurrency []common.ConcurrencyGroup) {
+	tb.Helper()
+	for from := 0; from < n; from += 1000 {
+		batch := make([]common.RawTask, 0, 1000)
+		for i := from; i < n && i < from+1000; i++ {
+			batch = append(batch, common.RawTask{Description: "input: {}", Concurrency: concurrency})
+		}
+		if _, err := list.WriteBatch(context.Background(), batch); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// claimAll 让workers个worker同时领取，直到没有可执行的任务，返回各次领取的任务id和耗时
+func claimAll(tb testing.TB, list *pgTaskList, workers int) ([]int, []time.Duration) {
+	tb.Helper()
+
+	var (
+		lock      sync.Mutex
+		ids       []int
+		latencies []time.Duration
+		group     sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		group.Add(1)
+		go func(w int) {
+			defer group.Done()
+			for {
+				start := time.Now()
+				t, err := list.claim(context.Background(), fmt.Sprintf("worker-%d", w))
+				elapsed := time.Since(start)
+				if err != nil {
+					tb.Error(err)
+					return
+				}
+				if t == nil {
+					return
+				}
+
+				lock.Lock()
+				ids = append(ids, t.id)
+				latencies = append(latencies, elapsed)
+				lock.Unlock()
+			}
+		}(w)
+	}
+	group.Wait()
+	return ids, latencies
+}
+
+func TestClaimEachTaskOnce(t *testing.T) {
+	list := newTestList(t)
+	writeTasks(t, list, 200, nil)
+
+	ids, _ := claimAll(t, list, 16)
+	if len(ids) != 200 {
+		t.Fatalf("all 200 tasks should be claimed, got %d", len(ids))
+	}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("task %d is claimed twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestClaimRespectsConcurrency(t *testing.T) {
+	list := newTestList(t)
+	writeTasks(t, list, 10, []common.ConcurrencyGroup{{Key: "db", Limit: 2}})
+
+	ids, _ := claimAll(t, list, 8)
+	if len(ids) != 2 {
+		t.Fatalf("only 2 tasks of the group should run at once, got %d", len(ids))
+	}
+}
+
+func TestConcurrencySlotReleased(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	group := []common.ConcurrencyGroup{{Key: "db", Limit: 1}}
+	ids, err := list.WriteBatch(ctx, []common.RawTask{
+		{Description: "input: {}", Concurrency: group},
+		{Description: "input: {}", Concurrency: group},
+		{Description: "input: {}", Concurrency: group},
+		{Description: "input: {}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func() string {
+		t.Helper()
+		task, err := list.claim(ctx, "worker")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task == nil {
+			return ""
+		}
+		return task.ID()
+	}
+
+	first, err := list.claim(ctx, "worker")
+	if err != nil || first == nil || first.ID() != ids[0] {
+		t.Fatalf("first task of the group should be claimed, got %v %v", first, err)
+	}
+	if id := claim(); id != ids[3] {
+		t.Fatalf("task outside the full group should not wait behind it, got %q", id)
+	}
+	if id := claim(); id != "" {
+		t.Fatalf("full group should stop the claim, got %q", id)
+	}
+
+	first.Done(ctx)
+	second, err := list.claim(ctx, "worker")
+	if err != nil || second == nil || second.ID() != ids[1] {
+		t.Fatalf("finished task should release its slot, got %v %v", second, err)
+	}
+	second.Error(ctx, fmt.Errorf("boom"))
+	if id := claim(); id != ids[2] {
+		t.Fatalf("failed task should release its slot, got %q", id)
+	}
+}
+
+func TestReadWokenByNotify(t *testing.T) {
+	list := newTestList(t)
+
+	claimed := make(chan common.Task)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		task, _ := list.Read(ctx, "worker")
+		claimed <- task
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := list.Write(context.Background(), common.RawTask{Description: "input: {}"}); err != nil {
+		t.Fatal(err)
+	}
+	if task := <-claimed; task == nil {
+		t.Fatal("waiting worker should claim the new task")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("worker should be woken by notify, took %v", elapsed)
+	}
+}
+
+// BenchmarkClaimUnderContention 多个worker同时领取b.N个任务，报告每次领取的延迟
+func BenchmarkClaimUnderContention(b *testing.B) {
+	list := newTestList(b)
+
+	for _, workers := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.StopTimer()
+			truncateTasks(b, list)
+			writeTasks(b, list, b.N, nil)
+			b.StartTimer()
+
+			start := time.Now()
+			ids, latencies := claimAll(b, list, workers)
+			elapsed := time.Since(start)
+
+			b.StopTimer()
+			if len(ids) != b.N {
+				b.Fatalf("%d tasks should be claimed, got %d", b.N, len(ids))
+			}
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds()), "p50-µs")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+			b.ReportMetric(float64(b.N)/elapsed.Seconds(), "claims/s")
+		})
+	}
+}
+
+func TestRequeueOrphans(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	list.conn.Exec(ctx, "truncate workers")
+	group := []common.ConcurrencyGroup{{Key: "db", Limit: 2}}
+	writeTasks(t, list, 2, group)
+
+	lost, err := list.claim(ctx, "lost-worker")
+	if err != nil || lost == nil {
+		t.Fatalf("task should be claimed, got %v %v", lost, err)
+	}
+	alive, err := list.claim(ctx, "alive-worker")
+	if err != nil || alive == nil {
+		t.Fatalf("task should be claimed, got %v %v", alive, err)
+	}
+	if err := list.Heartbeat(ctx, common.WorkerInfo{ID: "alive-worker", StartedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 刚领取的任务不算失联
+	if ids, _ := list.RequeueOrphans(ctx, time.Minute); len(ids) != 0 {
+		t.Fatalf("task claimed just now should be kept, got %v", ids)
+	}
+	list.conn.Exec(ctx, "update tasks set performed_at = performed_at - interval '2 minutes'")
+
+	ids, err := list.RequeueOrphans(ctx, time.Minute)
+	if err != nil || len(ids) != 1 || ids[0] != lost.ID() {
+		t.Fatalf("only the task of the lost worker should be requeued, got %v %v", ids, err)
+	}
+	info, _ := list.Status(ctx, "", lost.ID())
+	if info.State != common.StatePending || info.PerformedBy != "" {
+		t.Errorf("orphan should be pending again, got %+v", info)
+	}
+
+	// 放回的任务能再被领取，失联的worker之后结束它也不生效
+	again, err := list.claim(ctx, "new-worker")
+	if err != nil || again == nil || again.ID() != lost.ID() {
+		t.Fatalf("requeued task should take the freed slot, got %v %v", again, err)
+	}
+	lost.Done(ctx)
+	if info, _ := list.Status(ctx, "", lost.ID()); info.State != common.StateRunning || info.PerformedBy != "new-worker" {
+		t.Errorf("lost worker should not finish the run of the new owner, got %+v", info)
+	}
+}
@@ -0,0 +1,81 @@
+package pgtasklist
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// concurrencyLockSpace 并发组所用advisory lock的命名空间
+const concurrencyLockSpace = migrationLockSpace + 2
+
+var errConcurrencyFull = errors.New("concurrency group is full")
+
+// writeConcurrency 记录任务所属的并发组
+func (list *pgTaskList) writeConcurrency(ctx context.Context, tx pgx.Tx, id int, groups []common.ConcurrencyGroup) error {
+	for _, group := range groups {
+		sql := "insert into task_concurrency (task_id, key, lim) values ($1, $2, $3)"
+		if _, err := tx.Exec(ctx, sql, id, group.Key, group.Limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acquireConcurrency 检查任务所属的每个并发组中，除该任务外仍有空位，锁随事务释放；返回任务所属的并发组
+func (list *pgTaskList) acquireConcurrency(ctx context.Context, tx pgx.Tx, id int) ([]common.ConcurrencyGroup, error) {
+	groups, err := list.concurrencyGroups(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	running := `
+	select count(*)
+	from task_concurrency c
+	join tasks t on t.id = c.task_id
+	where c.key = $1
+	and t.id <> $2
+	and t.performed_at is not null
+	and t.finished_at is null
+	and t.cancelled_at is null
+	`
+
+	// 按key顺序加锁，避免死锁
+	for _, group := range groups {
+		if _, err := tx.Exec(ctx, "select pg_advisory_xact_lock($1, hashtext($2))", concurrencyLockSpace, group.Key); err != nil {
+			return nil, err
+		}
+
+		var count int
+		if err := tx.QueryRow(ctx, running, group.Key, id).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count >= group.Limit {
+			list.debugf("task %d waits for %s (%d/%d)", id, group.Key, count, group.Limit)
+			return nil, errConcurrencyFull
+		}
+	}
+
+	return groups, nil
+}
+
+// concurrencyGroups 查询任务所属的并发组
+func (list *pgTaskList) concurrencyGroups(ctx context.Context, tx pgx.Tx, id int) ([]common.ConcurrencyGroup, error) {
+	rows, err := tx.Query(ctx, "select key, lim from task_concurrency where task_id = $1 order by key", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []common.ConcurrencyGroup{}
+	for rows.Next() {
+		var group common.ConcurrencyGroup
+		if err := rows.Scan(&group.Key, &group.Limit); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
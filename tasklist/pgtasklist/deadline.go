@@ -0,0 +1,55 @@
+package pgtasklist
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// MarkOverdue 把过了截止时间仍未开始或仍在运行的任务标为逾期，返回这次新标记的任务，
+// 以及之前标记但还没通知到的未结束的任务；任务照常执行
+func (list *pgTaskList) MarkOverdue(ctx context.Context, now time.Time) ([]common.TaskInfo, error) {
+	sql := `
+	update tasks
+	set overdue_at = $1
+	where deadline <= $1
+	and deadline is not null and overdue_at is null and finished_at is null and cancelled_at is null
+	returning ` + infoColumns
+	marked, err := list.queryInfos(ctx, sql, list.local(&now))
+	if err != nil {
+		return nil, err
+	}
+
+	sql = `
+	select ` + infoColumns + ` from tasks
+	where overdue_at < $1
+	and overdue_at is not null and overdue_notified_at is null and finished_at is null and cancelled_at is null
+	order by id`
+	unnotified, err := list.queryInfos(ctx, sql, list.local(&now))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := append(unnotified, marked...)
+	if err := list.fillConcurrency(ctx, infos); err != nil {
+		return nil, err
+	}
+
+	for _, info := range marked {
+		id, _ := strconv.Atoi(info.ID)
+		list.log(ctx, id, "overdue, deadline was "+info.Deadline.Format("2006-01-02 15:04:05"))
+	}
+	return infos, nil
+}
+
+// OverdueNotified 记下逾期通知已送达
+func (list *pgTaskList) OverdueNotified(ctx context.Context, idStr string) error {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return err
+	}
+	_, err = list.conn.Exec(ctx, "update tasks set overdue_notified_at = $2 where id = $1 and overdue_at is not null", id, list.timeNowStr())
+	return err
+}
@@ -0,0 +1,164 @@
+package pgtasklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// Edit 修改尚未被领取的任务，修改前的版本存入task_revisions；
+// 行锁与claimSQL的for update skip locked互斥，正在领取的任务要等领取提交后再判断，锁住期间的任务不会被领取
+func (list *pgTaskList) Edit(ctx context.Context, namespace string, idStr string, editor string, edit func(common.RawTask) (common.RawTask, error)) error {
+	id, err := list.taskID(ctx, namespace, idStr)
+	if err != nil {
+		return err
+	}
+
+	tx, err := list.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	sql := `
+	select namespace, coalesce(description, ''), scheduled_at, coalesce(params, '{}'), coalesce(labels, '{}'), coalesce(run_window, ''), deadline, revision,
+		performed_at is null and finished_at is null and cancelled_at is null
+	from tasks
+	where id = $1
+	for update
+	`
+	var (
+		cur         common.RawTask
+		scheduledAt time.Time
+		deadline    *time.Time
+		revision    int
+		pending     bool
+	)
+	err = tx.QueryRow(ctx, sql, id).Scan(&cur.Namespace, &cur.Description, &scheduledAt, &cur.Params, &cur.Labels, &cur.Window, &deadline, &revision, &pending)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return common.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !pending {
+		return fmt.Errorf("%w: task %d has been claimed or ended", common.ErrConflict, id)
+	}
+
+	// scheduled_at不带时区，原样输出即为list.location的时间
+	cur.ScheduledAt = scheduledAt.Format("2006-01-02 15:04:05")
+	if deadline != nil {
+		cur.Deadline = deadline.Format("2006-01-02 15:04:05")
+	}
+	if cur.Concurrency, err = list.concurrencyGroups(ctx, tx, id); err != nil {
+		return err
+	}
+
+	next, err := edit(cur)
+	if err != nil {
+		return err
+	}
+	if next.ScheduledAt == "" {
+		next.ScheduledAt = list.timeNowStr()
+	}
+
+	sql = `
+	insert into task_revisions (task_id, revision, description, scheduled_at, concurrency, params, labels, run_window, deadline, replaced_at, replaced_by)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	_, err = tx.Exec(ctx, sql, id, revision, cur.Description, cur.ScheduledAt, cur.Concurrency,
+		nullIfEmpty(cur.Params), nullIfEmpty(cur.Labels), nullIfBlank(cur.Window), nullIfBlank(cur.Deadline), time.Now(), editor)
+	if err != nil {
+		return err
+	}
+
+	// 截止时间改了就重新判断是否逾期
+	sql = `
+	update tasks
+	set description = $2, scheduled_at = $3, params = $4, labels = $5, run_window = $6, deadline = $7,
+		overdue_at = case when deadline is not distinct from $7 then overdue_at end,
+		overdue_notified_at = case when deadline is not distinct from $7 then overdue_notified_at end, revision = revision + 1
+	where id = $1
+	`
+	_, err = tx.Exec(ctx, sql, id, next.Description, next.ScheduledAt, nullIfEmpty(next.Params), nullIfEmpty(next.Labels),
+		nullIfBlank(next.Window), nullIfBlank(next.Deadline))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "delete from task_concurrency where task_id = $1", id); err != nil {
+		return err
+	}
+	if err := list.writeConcurrency(ctx, tx, id, next.Concurrency); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	list.log(ctx, id, fmt.Sprintf("edited by %s, revision %d", editor, revision+1))
+	// 计划时间可能提前了
+	list.notifyNew()
+	return nil
+}
+
+// Revisions 列出任务被修改前的各个版本
+func (list *pgTaskList) Revisions(ctx context.Context, namespace string, idStr string) ([]common.TaskRevision, error) {
+	id, err := list.taskID(ctx, namespace, idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := list.conn.QueryRow(ctx, "select exists (select 1 from tasks where id = $1)", id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, common.ErrNotFound
+	}
+
+	revisions, err := list.revisions(ctx, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if revisions[id] == nil {
+		return []common.TaskRevision{}, nil
+	}
+	return revisions[id], nil
+}
+
+// revisions 读出各任务被修改前的版本
+func (list *pgTaskList) revisions(ctx context.Context, ids []int) (map[int][]common.TaskRevision, error) {
+	sql := `
+	select task_id, revision, coalesce(description, ''), scheduled_at, coalesce(concurrency, '[]'), coalesce(params, '{}'), coalesce(labels, '{}'),
+		coalesce(run_window, ''), deadline, replaced_at, coalesce(replaced_by, '')
+	from task_revisions
+	where task_id = any($1)
+	order by task_id, revision
+	`
+	rows, err := list.conn.Query(ctx, sql, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := map[int][]common.TaskRevision{}
+	for rows.Next() {
+		var (
+			id  int
+			rev common.TaskRevision
+		)
+		err := rows.Scan(&id, &rev.Revision, &rev.Description, &rev.ScheduledAt, &rev.Concurrency, &rev.Params, &rev.Labels,
+			&rev.Window, &rev.Deadline, &rev.ReplacedAt, &rev.ReplacedBy)
+		if err != nil {
+			return nil, err
+		}
+		revisions[id] = append(revisions[id], rev)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,80 @@
+package pgtasklist
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+func TestEdit(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	id, err := list.Write(ctx, common.RawTask{
+		Description: "input: {}",
+		ScheduledAt: "2099-01-01 00:00:00",
+		Concurrency: []common.ConcurrencyGroup{{Key: "db", Limit: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = list.Edit(ctx, "", id, "ops", func(cur common.RawTask) (common.RawTask, error) {
+		if cur.ScheduledAt != "2099-01-01 00:00:00" || len(cur.Concurrency) != 1 {
+			t.Errorf("current task should be given, got %+v", cur)
+		}
+		return common.RawTask{Description: "input: []", Labels: map[string]string{"team": "data"}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, _ := list.Status(ctx, "", id)
+	if info.Revision != 1 || len(info.Concurrency) != 0 || info.Labels["team"] != "data" {
+		t.Fatalf("task should be edited, got %+v", info)
+	}
+	revisions, err := list.Revisions(ctx, "", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Description != "input: {}" || len(revisions[0].Concurrency) != 1 {
+		t.Fatalf("previous revision should be kept, got %+v", revisions)
+	}
+
+	// 计划时间改为现在，可以被领取，领取后不能再修改
+	if task, err := list.claim(ctx, "worker"); err != nil || task == nil {
+		t.Fatalf("edited task should be claimed, got %v %v", task, err)
+	}
+	err = list.Edit(ctx, "", id, "ops", func(cur common.RawTask) (common.RawTask, error) { return cur, nil })
+	if !errors.Is(err, common.ErrConflict) {
+		t.Fatalf("claimed task should not be edited, got %v", err)
+	}
+}
+
+func TestDefer(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	id, err := list.Write(ctx, common.RawTask{Description: "input: {}", Window: "* 0-5 * * *"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := list.claim(ctx, "worker")
+	if err != nil || task == nil || task.Window() != "* 0-5 * * *" {
+		t.Fatalf("task should be claimed with its window, got %v %v", task, err)
+	}
+
+	if err := task.Defer(ctx, time.Now().Add(time.Hour), "outside window"); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := list.Status(ctx, "", id)
+	if info.State != common.StatePending || info.Window != "* 0-5 * * *" {
+		t.Fatalf("deferred task should be pending, got %+v", info)
+	}
+	if task, err := list.claim(ctx, "worker"); err != nil || task != nil {
+		t.Errorf("deferred task should not be claimed before its time, got %v %v", task, err)
+	}
+}
@@ -0,0 +1,170 @@
+package pgtasklist
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// exportBatchSize 导出时每批读出的任务数
+const exportBatchSize = 500
+
+// Export 按id从小到大分批读出符合条件的任务的完整记录，交给write；
+// 表中的时间不带时区，导出时按list.location补上，以便导入其他任务列表
+func (list *pgTaskList) Export(ctx context.Context, opts common.ListOptions, write func([]common.TaskRecord) error) error {
+	sql := "select id from tasks where ($1 = '' or " + stateExpr + " = $1) and " + labelsCond + overdueCond(opts) +
+		" and ($4 = '' or namespace = $4) and id > $3 order by id limit " + strconv.Itoa(exportBatchSize)
+	last := 0
+	for {
+		ids, err := list.queryIds(ctx, sql, opts.State, nullIfEmpty(opts.Labels), last, opts.Namespace)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		records, err := list.records(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for i := range records {
+			list.localize(&records[i])
+		}
+		if err := write(records); err != nil {
+			return err
+		}
+		last = ids[len(ids)-1]
+	}
+}
+
+// localize 把记录中的各个时间按list.location理解
+func (list *pgTaskList) localize(record *common.TaskRecord) {
+	for _, at := range []*time.Time{record.CreatedAt, record.ScheduledAt, record.PerformedAt, record.FinishedAt, record.CancelledAt, record.Deadline, record.OverdueAt} {
+		if at != nil {
+			*at = list.wallClock(*at)
+		}
+	}
+	for i := range record.Logs {
+		record.Logs[i].At = list.wallClock(record.Logs[i].At)
+	}
+	for i := range record.Revisions {
+		rev := &record.Revisions[i]
+		rev.ReplacedAt = list.wallClock(rev.ReplacedAt)
+		for _, at := range []*time.Time{rev.ScheduledAt, rev.Deadline} {
+			if at != nil {
+				*at = list.wallClock(*at)
+			}
+		}
+	}
+}
+
+// wallClock 不带时区的时间按list.location理解
+func (list *pgTaskList) wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), list.location)
+}
+
+// Import 在一个事务中写入导出的任务，分配新的id，返回的id与records一一对应；
+// 状态由各时间字段决定，运行中的任务应先改为未开始
+func (list *pgTaskList) Import(ctx context.Context, records []common.TaskRecord) ([]string, error) {
+	tx, err := list.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	now := time.Now()
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		id, err := list.importTask(ctx, tx, record, now)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, strconv.Itoa(id))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	list.notifyNew()
+	return ids, nil
+}
+
+// importTask 在事务中写入一个任务及其并发组、日志、检查点和修改前的版本
+func (list *pgTaskList) importTask(ctx context.Context, tx pgx.Tx, record common.TaskRecord, now time.Time) (int, error) {
+	createdAt, scheduledAt := &now, &now
+	if record.CreatedAt != nil {
+		createdAt = record.CreatedAt
+	}
+	if record.ScheduledAt != nil {
+		scheduledAt = record.ScheduledAt
+	}
+
+	// 导入的逾期任务视为已通知过
+	sql := `
+	insert into tasks (description, created_at, scheduled_at, performed_at, finished_at, cancelled_at,
+		error, performed_by, anchor_revision, params, labels, run_window, deadline, overdue_at, overdue_notified_at, revision, namespace)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $14, $15, $16)
+	returning id
+	`
+	var id int
+	err := tx.QueryRow(ctx, sql, record.Description, list.local(createdAt), list.local(scheduledAt),
+		list.local(record.PerformedAt), list.local(record.FinishedAt), list.local(record.CancelledAt),
+		nullIfBlank(record.Error), nullIfBlank(record.PerformedBy), record.AnchorRevision,
+		nullIfEmpty(record.Params), nullIfEmpty(record.Labels), nullIfBlank(record.Window),
+		list.local(record.Deadline), list.local(record.OverdueAt), record.Revision, namespaceOr(record.Namespace)).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := list.writeConcurrency(ctx, tx, id, record.Concurrency); err != nil {
+		return 0, err
+	}
+
+	sql = "insert into task_logs (task_id, at, message) values ($1, $2, $3)"
+	for _, line := range record.Logs {
+		if _, err := tx.Exec(ctx, sql, id, list.local(&line.At), line.Message); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec(ctx, sql, id, now, "imported from task "+record.ID); err != nil {
+		return 0, err
+	}
+
+	sql = "insert into task_checkpoints (task_id, key, value, updated_at) values ($1, $2, $3, $4)"
+	for key, value := range record.Checkpoints {
+		if _, err := tx.Exec(ctx, sql, id, key, value, now); err != nil {
+			return 0, err
+		}
+	}
+
+	sql = `
+	insert into task_revisions (task_id, revision, description, scheduled_at, concurrency, params, labels, run_window, deadline, replaced_at, replaced_by)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	for _, rev := range record.Revisions {
+		_, err := tx.Exec(ctx, sql, id, rev.Revision, rev.Description, list.local(rev.ScheduledAt), rev.Concurrency,
+			nullIfEmpty(rev.Params), nullIfEmpty(rev.Labels), nullIfBlank(rev.Window), list.local(rev.Deadline), list.local(&rev.ReplacedAt), rev.ReplacedBy)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// local 转为list.location的时间，写入不带时区的字段时保留的是这个时区的钟点
+func (list *pgTaskList) local(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	at := t.In(list.location)
+	return &at
+}
+
+// nullIfBlank 空字符串存为null
+func nullIfBlank(str string) *string {
+	if str == "" {
+		return nil
+	}
+	return &str
+}
@@ -0,0 +1,52 @@
+package pgtasklist
+
+import (
+	"context"
+	"testing"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+func TestExportImport(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	id, err := list.Write(ctx, common.RawTask{
+		Description: "input: {}",
+		ScheduledAt: "2099-01-01 08:00:00",
+		Concurrency: []common.ConcurrencyGroup{{Key: "db", Limit: 1}},
+		Labels:      map[string]string{"team": "data"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []common.TaskRecord
+	err = list.Export(ctx, common.ListOptions{Labels: map[string]string{"team": "data"}}, func(batch []common.TaskRecord) error {
+		records = append(records, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != id || records[0].ScheduledAt.Location() != list.location {
+		t.Fatalf("task should be exported in list location, got %+v", records)
+	}
+	if records[0].ScheduledAt.In(list.location).Hour() != 8 {
+		t.Errorf("scheduled_at should keep its wall clock, got %v", records[0].ScheduledAt)
+	}
+
+	ids, err := list.Import(ctx, records)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("import should succeed, got %v %v", ids, err)
+	}
+	// 表中的时间不带时区，读出的钟点即list.location的钟点
+	info, err := list.Status(ctx, "", ids[0])
+	if err != nil || info.ScheduledAt.Hour() != 8 || len(info.Concurrency) != 1 || info.State != common.StatePending {
+		t.Errorf("imported task should be scheduled at the same time, got %+v %v", info, err)
+	}
+	logs, _ := list.Logs(ctx, "", ids[0])
+	if len(logs) == 0 || logs[len(logs)-1].Message != "imported from task "+id {
+		t.Errorf("imported task should note its origin, got %+v", logs)
+	}
+}
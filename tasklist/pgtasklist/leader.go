@@ -0,0 +1,128 @@
+package pgtasklist
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// leaderLockSpace 选举所用advisory lock的命名空间
+const leaderLockSpace = migrationLockSpace + 3
+
+// leaderCheckInterval 竞选的重试间隔，也是当选后检查连接的间隔
+var leaderCheckInterval = 5 * time.Second
+
+// Elect 在独占的连接上持有advisory lock即为领导者，阻塞直到当选或ctx结束；
+// 连接断开时pg释放这个锁，其他服务器随即接替
+func (list *pgTaskList) Elect(ctx context.Context, name, candidate string) (common.Leadership, error) {
+	var conn *pgx.Conn
+	for {
+		var err error
+		if conn == nil {
+			conn, err = list.leaderConn(ctx, candidate)
+		}
+		if conn != nil {
+			var locked bool
+			err = conn.QueryRow(ctx, "select pg_try_advisory_lock($1, hashtext($2))", leaderLockSpace, name).Scan(&locked)
+			if locked {
+				leader := &pgLeader{list: list, name: name, conn: conn, lost: make(chan struct{})}
+				go leader.watch()
+				return leader, nil
+			}
+			if err != nil {
+				conn.Close(context.Background())
+				conn = nil
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			list.errorf("elect %s: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if conn != nil {
+				conn.Close(context.Background())
+			}
+			return nil, ctx.Err()
+		case <-time.After(leaderCheckInterval):
+		}
+	}
+}
+
+// leaderConn 建立不属于连接池的连接，在pg_stat_activity中可以看出是哪个服务器
+func (list *pgTaskList) leaderConn(ctx context.Context, candidate string) (*pgx.Conn, error) {
+	cfg := list.conn.Config().ConnConfig.Copy()
+	if cfg.RuntimeParams == nil {
+		cfg.RuntimeParams = map[string]string{}
+	}
+	cfg.RuntimeParams["application_name"] = "clams leader " + candidate
+	return pgx.ConnectConfig(ctx, cfg)
+}
+
+// pgLeader 持有advisory lock的领导者
+type pgLeader struct {
+	list *pgTaskList
+	name string
+
+	// conn不能同时使用
+	lock  sync.Mutex
+	conn  *pgx.Conn
+	ended bool
+	lost  chan struct{}
+}
+
+// Lost 失去或让出领导权时关闭
+func (leader *pgLeader) Lost() chan struct{} {
+	return leader.lost
+}
+
+// watch 定期检查连接，连接断开即失去领导权
+func (leader *pgLeader) watch() {
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-leader.lost:
+			return
+		case <-ticker.C:
+		}
+
+		leader.lock.Lock()
+		if leader.ended {
+			leader.lock.Unlock()
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), leaderCheckInterval)
+		err := leader.conn.Ping(ctx)
+		cancel()
+		if err != nil {
+			leader.list.errorf("leader %s is lost: %v", leader.name, err)
+			leader.end()
+		}
+		leader.lock.Unlock()
+	}
+}
+
+// end 关闭连接并通知失去领导权，须持有lock
+func (leader *pgLeader) end() {
+	leader.ended = true
+	leader.conn.Close(context.Background())
+	close(leader.lost)
+}
+
+// Resign 释放锁，其他服务器随即可以当选
+func (leader *pgLeader) Resign(ctx context.Context) error {
+	leader.lock.Lock()
+	defer leader.lock.Unlock()
+	if leader.ended {
+		return nil
+	}
+
+	_, err := leader.conn.Exec(ctx, "select pg_advisory_unlock($1, hashtext($2))", leaderLockSpace, leader.name)
+	leader.end()
+	return err
+}
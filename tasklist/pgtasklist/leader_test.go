@@ -0,0 +1,43 @@
+package pgtasklist
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestElect(t *testing.T) {
+	list := newTestList(t)
+	defer func(interval time.Duration) { leaderCheckInterval = interval }(leaderCheckInterval)
+	leaderCheckInterval = 100 * time.Millisecond
+
+	a, err := list.Elect(context.Background(), "clams", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := list.Elect(ctx, "clams", "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("only one candidate should be elected, got %v", err)
+	}
+
+	// 领导者的连接断开后锁随之释放
+	if _, err := list.conn.Exec(context.Background(), "select pg_terminate_backend(pid) from pg_stat_activity where application_name = 'clams leader a'"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-a.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("leader should be lost with its connection")
+	}
+
+	b, err := list.Elect(context.Background(), "clams", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Resign(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
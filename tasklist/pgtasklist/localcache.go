@@ -25,20 +25,18 @@ func (local *localcache) del(id int) {
 	}
 }
 
-func (local *localcache) init(ids ...int) {
+func (local *localcache) set(id int, t *pgTask) {
 	local.lock.Lock()
 	defer local.lock.Unlock()
 
-	for _, id := range ids {
-		local.cache[id] = nil
-	}
+	local.cache[id] = t
 }
 
-func (local *localcache) set(id int, t *pgTask) {
+func (local *localcache) get(id int) *pgTask {
 	local.lock.Lock()
 	defer local.lock.Unlock()
 
-	local.cache[id] = t
+	return local.cache[id]
 }
 
 func (local *localcache) getIds() []int {
@@ -0,0 +1,261 @@
+package pgtasklist
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationLockSpace 迁移所用advisory lock的命名空间，与任务id的锁（单参数）互不冲突
+const migrationLockSpace = 0x636c616d
+
+// migration 一次表结构变更
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations 按版本排列的表结构变更，只能在末尾追加
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create tasks",
+		sql: `
+		create table if not exists tasks (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP,
+			scheduled_at TIMESTAMP,
+			performed_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			cancelled_at TIMESTAMP,
+			description TEXT,
+			error TEXT
+		)`,
+	},
+	{
+		version: 2,
+		name:    "create anchors",
+		sql: `
+		create table if not exists anchors (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			version INT NOT NULL,
+			content TEXT,
+			deleted BOOLEAN NOT NULL DEFAULT false,
+			created_at TIMESTAMP,
+			UNIQUE (name, version)
+		);
+		alter table tasks add column if not exists anchor_revision INT`,
+	},
+	{
+		version: 3,
+		name:    "create task_concurrency",
+		sql: `
+		create table if not exists task_concurrency (
+			task_id INT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+			key TEXT NOT NULL,
+			lim INT NOT NULL,
+			PRIMARY KEY (task_id, key)
+		);
+		create index if not exists task_concurrency_key on task_concurrency (key)`,
+	},
+	{
+		version: 4,
+		name:    "create task_logs",
+		sql: `
+		create table if not exists task_logs (
+			id SERIAL PRIMARY KEY,
+			task_id INT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+			at TIMESTAMP,
+			message TEXT
+		);
+		create index if not exists task_logs_task_id on task_logs (task_id)`,
+	},
+	{
+		version: 5,
+		name:    "create task_checkpoints",
+		sql: `
+		create table if not exists task_checkpoints (
+			task_id INT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+			key TEXT NOT NULL,
+			value TEXT,
+			updated_at TIMESTAMP,
+			PRIMARY KEY (task_id, key)
+		)`,
+	},
+	{
+		version: 6,
+		name:    "create workers",
+		sql: `
+		create table if not exists workers (
+			id TEXT PRIMARY KEY,
+			host TEXT,
+			pid INT,
+			started_at TIMESTAMP,
+			heartbeat_at TIMESTAMP,
+			task_id INT
+		);
+		alter table tasks add column if not exists performed_by TEXT`,
+	},
+	{
+		version: 7,
+		name:    "add task params",
+		sql:     `alter table tasks add column if not exists params JSONB`,
+	},
+	{
+		version: 8,
+		name:    "index claimable tasks",
+		sql: `
+		create index if not exists tasks_claimable on tasks (scheduled_at, id)
+		where performed_at is null and finished_at is null and cancelled_at is null`,
+	},
+	{
+		version: 9,
+		name:    "create audit log",
+		sql: `
+		create table if not exists audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			at TIMESTAMP NOT NULL,
+			caller TEXT,
+			client_ip TEXT,
+			method TEXT,
+			path TEXT,
+			action TEXT,
+			target TEXT,
+			summary TEXT,
+			status INT,
+			result TEXT
+		);
+		create index if not exists audit_log_caller on audit_log (caller);
+		create index if not exists audit_log_action on audit_log (action);
+		create or replace function audit_log_append_only() returns trigger as $$
+		begin
+			raise exception 'audit_log is append-only';
+		end
+		$$ language plpgsql;
+		drop trigger if exists audit_log_append_only on audit_log;
+		create trigger audit_log_append_only before update or delete on audit_log
+		for each row execute function audit_log_append_only()`,
+	},
+	{
+		version: 10,
+		name:    "add task labels",
+		sql: `
+		alter table tasks add column if not exists labels JSONB;
+		create index if not exists tasks_labels on tasks using gin (labels jsonb_path_ops)`,
+	},
+	{
+		version: 11,
+		name:    "create task_revisions",
+		sql: `
+		alter table tasks add column if not exists revision INT NOT NULL DEFAULT 0;
+		create table if not exists task_revisions (
+			task_id INT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+			revision INT NOT NULL,
+			description TEXT,
+			scheduled_at TIMESTAMP,
+			concurrency JSONB,
+			params JSONB,
+			labels JSONB,
+			replaced_at TIMESTAMP,
+			replaced_by TEXT,
+			PRIMARY KEY (task_id, revision)
+		)`,
+	},
+	{
+		version: 12,
+		name:    "add task run_window",
+		sql: `
+		alter table tasks add column if not exists run_window TEXT;
+		alter table task_revisions add column if not exists run_window TEXT`,
+	},
+	{
+		version: 13,
+		name:    "add task deadline",
+		sql: `
+		alter table tasks add column if not exists deadline TIMESTAMP;
+		alter table tasks add column if not exists overdue_at TIMESTAMP;
+		alter table task_revisions add column if not exists deadline TIMESTAMP;
+		create index if not exists tasks_deadline on tasks (deadline)
+			where deadline is not null and overdue_at is null and finished_at is null and cancelled_at is null`,
+	},
+	{
+		version: 14,
+		name:    "add namespaces",
+		sql: `
+		alter table tasks add column if not exists namespace TEXT NOT NULL DEFAULT 'default';
+		create index if not exists tasks_namespace on tasks (namespace, id);
+		alter table anchors add column if not exists namespace TEXT NOT NULL DEFAULT 'default';
+		alter table anchors drop constraint if exists anchors_name_version_key;
+		alter table anchors add constraint anchors_namespace_name_version_key UNIQUE (namespace, name, version)`,
+	},
+	{
+		version: 15,
+		name:    "add task overdue_notified_at",
+		sql: `
+		alter table tasks add column if not exists overdue_notified_at TIMESTAMP;
+		update tasks set overdue_notified_at = overdue_at where overdue_at is not null;
+		create index if not exists tasks_overdue_unnotified on tasks (id)
+			where overdue_at is not null and overdue_notified_at is null and finished_at is null and cancelled_at is null`,
+	},
+}
+
+// migrate 在advisory lock保护下执行未执行过的迁移
+func (list *pgTaskList) migrate(ctx context.Context) error {
+	return list.conn.AcquireFunc(ctx, func(c *pgxpool.Conn) error {
+		if _, err := c.Exec(ctx, "select pg_advisory_lock($1, 1)", migrationLockSpace); err != nil {
+			return err
+		}
+		defer c.Exec(context.Background(), "select pg_advisory_unlock($1, 1)", migrationLockSpace)
+
+		_, err := c.Exec(ctx, `
+		create table if not exists schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT,
+			applied_at TIMESTAMP
+		)`)
+		if err != nil {
+			return err
+		}
+
+		var current int
+		err = c.QueryRow(ctx, "select coalesce(max(version), 0) from schema_migrations").Scan(&current)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.version <= current {
+				continue
+			}
+			if err := list.applyMigration(ctx, c, m); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+			list.debugf("migrated to %d: %s", m.version, m.name)
+		}
+		return nil
+	})
+}
+
+// applyMigration 在一个事务里执行迁移并记录版本
+func (list *pgTaskList) applyMigration(ctx context.Context, c *pgxpool.Conn, m migration) error {
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, m.sql); err != nil {
+		return err
+	}
+
+	sql := "insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)"
+	if _, err := tx.Exec(ctx, sql, m.version, m.name, time.Now()); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
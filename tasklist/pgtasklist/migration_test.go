@@ -0,0 +1,16 @@
+package pgtasklist
+
+import "testing"
+
+func TestMigrationsOrdered(t *testing.T) {
+	last := 0
+	for _, m := range migrations {
+		if m.version <= last {
+			t.Fatalf("migration %d (%s) should be greater than %d", m.version, m.name, last)
+		}
+		if m.sql == "" {
+			t.Fatalf("migration %d (%s) has no sql", m.version, m.name)
+		}
+		last = m.version
+	}
+}
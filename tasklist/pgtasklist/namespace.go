@@ -0,0 +1,38 @@
+package pgtasklist
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// taskID 解析任务id，namespace不为空时任务须在其中，否则视为不存在；
+// 任务的命名空间不会改变，之后的操作无需再判断
+func (list *pgTaskList) taskID(ctx context.Context, namespace string, idStr string) (int, error) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, common.ErrNotFound
+	}
+	if namespace == "" {
+		return id, nil
+	}
+
+	var other bool
+	err = list.conn.QueryRow(ctx, "select exists (select 1 from tasks where id = $1 and namespace <> $2)", id, namespace).Scan(&other)
+	if err != nil {
+		return 0, err
+	}
+	if other {
+		return 0, common.ErrNotFound
+	}
+	return id, nil
+}
+
+// namespaceOr 为空时即默认的命名空间
+func namespaceOr(namespace string) string {
+	if namespace == "" {
+		return common.DefaultNamespace
+	}
+	return namespace
+}
@@ -0,0 +1,206 @@
+package pgtasklist
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// purgeLockKey 清理任务所用的advisory lock，同一时间只有一个服务器在清理
+const purgeLockKey = 3
+
+// Purge 按保留策略删除已结束的任务，删除前交给archive归档；其他服务器正在清理时直接返回
+func (list *pgTaskList) Purge(ctx context.Context, policy common.PurgePolicy, archive func([]common.TaskRecord) error) (int, error) {
+	where, args := purgeCondition(policy)
+	if where == "" {
+		return 0, nil
+	}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 500
+	}
+
+	purged := 0
+	err := list.conn.AcquireFunc(ctx, func(c *pgxpool.Conn) error {
+		var locked bool
+		if err := c.QueryRow(ctx, "select pg_try_advisory_lock($1, $2)", migrationLockSpace, purgeLockKey).Scan(&locked); err != nil {
+			return err
+		}
+		if !locked {
+			list.debugf("purge is running on another server")
+			return nil
+		}
+		defer c.Exec(context.Background(), "select pg_advisory_unlock($1, $2)", migrationLockSpace, purgeLockKey)
+
+		sql := "select id from tasks where " + where + " order by id limit " + strconv.Itoa(policy.BatchSize)
+		for {
+			ids, err := list.queryIds(ctx, sql, args...)
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+
+			if archive != nil {
+				records, err := list.records(ctx, ids)
+				if err != nil {
+					return err
+				}
+				if err := archive(records); err != nil {
+					return err
+				}
+			}
+
+			tag, err := list.conn.Exec(ctx, "delete from tasks where id = any($1)", ids)
+			if err != nil {
+				return err
+			}
+			purged += int(tag.RowsAffected())
+		}
+	})
+	return purged, err
+}
+
+// purgeCondition 由保留策略生成条件，只涉及已结束或已取消的任务
+func purgeCondition(policy common.PurgePolicy) (string, []any) {
+	now := time.Now()
+	endedAt := "coalesce(cancelled_at, finished_at)"
+	conds := []string{}
+	args := []any{}
+
+	if policy.MaxAge > 0 {
+		args = append(args, now.Add(-policy.MaxAge))
+		conds = append(conds, endedAt+" < $"+strconv.Itoa(len(args)))
+	}
+
+	states := make([]string, 0, len(policy.StateMaxAge))
+	for state := range policy.StateMaxAge {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		maxAge := policy.StateMaxAge[state]
+		if maxAge <= 0 {
+			continue
+		}
+		args = append(args, state, now.Add(-maxAge))
+		conds = append(conds, "("+stateExpr+" = $"+strconv.Itoa(len(args)-1)+" and "+endedAt+" < $"+strconv.Itoa(len(args))+")")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return endedAt + " is not null and (" + strings.Join(conds, " or ") + ")", args
+}
+
+// queryIds 查询任务id
+func (list *pgTaskList) queryIds(ctx context.Context, sql string, args ...any) ([]int, error) {
+	rows, err := list.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// records 读出任务的完整记录
+func (list *pgTaskList) records(ctx context.Context, ids []int) ([]common.TaskRecord, error) {
+	infos, err := list.queryInfos(ctx, "select "+infoColumns+" from tasks where id = any($1) order by id", ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := list.fillConcurrency(ctx, infos); err != nil {
+		return nil, err
+	}
+
+	records := make([]common.TaskRecord, 0, len(infos))
+	idx := make(map[int]int, len(infos))
+	for i, info := range infos {
+		id, _ := strconv.Atoi(info.ID)
+		idx[id] = i
+		records = append(records, common.TaskRecord{TaskInfo: info, Logs: []common.LogLine{}})
+	}
+
+	rows, err := list.conn.Query(ctx, "select id, coalesce(description, '') from tasks where id = any($1)", ids)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			id   int
+			desc string
+		)
+		if err := rows.Scan(&id, &desc); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		records[idx[id]].Description = desc
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = list.conn.Query(ctx, "select task_id, at, message from task_logs where task_id = any($1) order by id", ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   int
+			line common.LogLine
+		)
+		if err := rows.Scan(&id, &line.At, &line.Message); err != nil {
+			return nil, err
+		}
+		record := &records[idx[id]]
+		record.Logs = append(record.Logs, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = list.conn.Query(ctx, "select task_id, key, coalesce(value, '') from task_checkpoints where task_id = any($1)", ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id         int
+			key, value string
+		)
+		if err := rows.Scan(&id, &key, &value); err != nil {
+			return nil, err
+		}
+		record := &records[idx[id]]
+		if record.Checkpoints == nil {
+			record.Checkpoints = map[string]string{}
+		}
+		record.Checkpoints[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	revisions, err := list.revisions(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for id, revs := range revisions {
+		records[idx[id]].Revisions = revs
+	}
+	return records, nil
+}
@@ -0,0 +1,291 @@
+package pgtasklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// stateExpr 由各时间字段推出任务状态
+const stateExpr = `
+	case
+		when cancelled_at is not null then 'cancelled'
+		when finished_at is not null and error is not null then 'error'
+		when finished_at is not null then 'done'
+		when performed_at is not null then 'running'
+		else 'pending'
+	end`
+
+// infoColumns 查询TaskInfo所需的字段
+const infoColumns = `
+	id, namespace, ` + stateExpr + `, created_at, scheduled_at, performed_at, finished_at, cancelled_at,
+	coalesce(error, ''), coalesce(performed_by, ''), anchor_revision, coalesce(params, '{}'), coalesce(labels, '{}'),
+	coalesce(run_window, ''), deadline, overdue_at, revision`
+
+// List 列出任务，新的在前
+func (list *pgTaskList) List(ctx context.Context, opts common.ListOptions) ([]common.TaskInfo, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+
+	sql := "select " + infoColumns + " from tasks where ($1 = '' or " + stateExpr + " = $1) and " + labelsCond + overdueCond(opts) +
+		" and ($5 = '' or namespace = $5) order by id desc limit $3 offset $4"
+	infos, err := list.queryInfos(ctx, sql, opts.State, nullIfEmpty(opts.Labels), opts.Limit, opts.Offset, opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := list.fillConcurrency(ctx, infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Count 统计符合条件的任务数
+func (list *pgTaskList) Count(ctx context.Context, opts common.ListOptions) (int, error) {
+	sql := "select count(*) from tasks where ($1 = '' or " + stateExpr + " = $1) and " + labelsCond + overdueCond(opts) + " and ($3 = '' or namespace = $3)"
+	var n int
+	err := list.conn.QueryRow(ctx, sql, opts.State, nullIfEmpty(opts.Labels), opts.Namespace).Scan(&n)
+	return n, err
+}
+
+// labelsCond 按$2中的标签筛选，可用上tasks_labels索引
+const labelsCond = "($2::jsonb is null or labels @> $2::jsonb)"
+
+// overdueCond 只要已标为逾期的任务时追加的条件
+func overdueCond(opts common.ListOptions) string {
+	if opts.Overdue {
+		return " and overdue_at is not null"
+	}
+	return ""
+}
+
+// nullIfEmpty 空的map存为null，作为标签条件时即不筛选
+func nullIfEmpty(pairs map[string]string) map[string]string {
+	if len(pairs) == 0 {
+		return nil
+	}
+	return pairs
+}
+
+// CancelMatching 取消符合条件的未结束任务，运行中的任务会被中止，返回被取消的任务id
+func (list *pgTaskList) CancelMatching(ctx context.Context, opts common.ListOptions) ([]string, error) {
+	sql := `
+	update tasks
+	set cancelled_at = $3
+	where cancelled_at is null
+	and finished_at is null
+	and ($1 = '' or ` + stateExpr + ` = $1)
+	and ` + labelsCond + overdueCond(opts) + `
+	and ($4 = '' or namespace = $4)
+	returning id
+	`
+	ids, err := list.queryIds(ctx, sql, opts.State, nullIfEmpty(opts.Labels), time.Now(), opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled := make([]string, 0, len(ids))
+	for _, id := range ids {
+		cancelled = append(cancelled, strconv.Itoa(id))
+	}
+	if len(ids) > 0 {
+		list.conn.Exec(context.Background(), "select pg_notify('"+tasksChannel+"', $1)", "abort")
+	}
+	return cancelled, nil
+}
+
+// Status 查看任务状态
+func (list *pgTaskList) Status(ctx context.Context, namespace string, idStr string) (common.TaskInfo, error) {
+	id, err := list.taskID(ctx, namespace, idStr)
+	if err != nil {
+		return common.TaskInfo{}, err
+	}
+
+	infos, err := list.queryInfos(ctx, "select "+infoColumns+" from tasks where id = $1", id)
+	if err != nil {
+		return common.TaskInfo{}, err
+	}
+	if len(infos) == 0 {
+		return common.TaskInfo{}, common.ErrNotFound
+	}
+	if err := list.fillConcurrency(ctx, infos); err != nil {
+		return common.TaskInfo{}, err
+	}
+	if err := list.fillCheckpoints(ctx, &infos[0], id); err != nil {
+		return common.TaskInfo{}, err
+	}
+	return infos[0], nil
+}
+
+// Rerun 重新执行已结束或已取消的任务，已过的截止时间一并清除，免得重跑后立即又逾期
+func (list *pgTaskList) Rerun(ctx context.Context, namespace string, idStr string) error {
+	id, err := list.taskID(ctx, namespace, idStr)
+	if err != nil {
+		return err
+	}
+
+	tx, err := list.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	// 先锁住该行，读出的状态在事务结束前不会变
+	sql := `
+	with prev as (
+		select id, finished_at is not null and error is null and cancelled_at is null as succeeded
+		from tasks
+		where id = $2
+		and (finished_at is not null or cancelled_at is not null)
+		for update
+	)
+	update tasks t
+	set scheduled_at = $1, performed_at = null, performed_by = null, finished_at = null, cancelled_at = null, error = null,
+		overdue_at = null, overdue_notified_at = null, deadline = case when t.deadline > $1 then t.deadline end
+	from prev
+	where t.id = prev.id
+	returning prev.succeeded
+	`
+
+	var succeeded bool
+	err = tx.QueryRow(ctx, sql, list.timeNowStr(), id).Scan(&succeeded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: task %d is pending or running", common.ErrConflict, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 成功结束的任务从头执行，出错或取消的任务从检查点继续
+	if succeeded {
+		if _, err := tx.Exec(ctx, "delete from task_checkpoints where task_id = $1", id); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	list.log(ctx, id, "rerun")
+	list.notifyNew()
+	return nil
+}
+
+// Logs 查看任务日志
+func (list *pgTaskList) Logs(ctx context.Context, namespace string, idStr string) ([]common.LogLine, error) {
+	id, err := list.taskID(ctx, namespace, idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := list.conn.Query(ctx, "select at, message from task_logs where task_id = $1 order by id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []common.LogLine{}
+	for rows.Next() {
+		var line common.LogLine
+		if err := rows.Scan(&line.At, &line.Message); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// log 记录任务日志
+func (list *pgTaskList) log(ctx context.Context, id int, message string) error {
+	sql := "insert into task_logs (task_id, at, message) values ($1, $2, $3)"
+	_, err := list.conn.Exec(ctx, sql, id, time.Now(), message)
+	return err
+}
+
+// queryInfos 查询任务状态
+func (list *pgTaskList) queryInfos(ctx context.Context, sql string, args ...any) ([]common.TaskInfo, error) {
+	rows, err := list.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []common.TaskInfo{}
+	for rows.Next() {
+		var (
+			info common.TaskInfo
+			id   int
+		)
+		err := rows.Scan(&id, &info.Namespace, &info.State, &info.CreatedAt, &info.ScheduledAt, &info.PerformedAt,
+			&info.FinishedAt, &info.CancelledAt, &info.Error, &info.PerformedBy, &info.AnchorRevision, &info.Params, &info.Labels,
+			&info.Window, &info.Deadline, &info.OverdueAt, &info.Revision)
+		if err != nil {
+			return nil, err
+		}
+		info.ID = strconv.Itoa(id)
+		info.Concurrency = []common.ConcurrencyGroup{}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// fillConcurrency 补上任务的并发组
+func (list *pgTaskList) fillConcurrency(ctx context.Context, infos []common.TaskInfo) error {
+	if len(infos) == 0 {
+		return nil
+	}
+
+	idx := make(map[int]int, len(infos))
+	ids := make([]int, 0, len(infos))
+	for i, info := range infos {
+		id, _ := strconv.Atoi(info.ID)
+		idx[id] = i
+		ids = append(ids, id)
+	}
+
+	rows, err := list.conn.Query(ctx, "select task_id, key, lim from task_concurrency where task_id = any($1) order by key", ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id    int
+			group common.ConcurrencyGroup
+		)
+		if err := rows.Scan(&id, &group.Key, &group.Limit); err != nil {
+			return err
+		}
+		info := &infos[idx[id]]
+		info.Concurrency = append(info.Concurrency, group)
+	}
+	return rows.Err()
+}
+
+// fillCheckpoints 补上任务的检查点
+func (list *pgTaskList) fillCheckpoints(ctx context.Context, info *common.TaskInfo, id int) error {
+	rows, err := list.conn.Query(ctx, "select key, coalesce(value, '') from task_checkpoints where task_id = $1", id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		if info.Checkpoints == nil {
+			info.Checkpoints = map[string]string{}
+		}
+		info.Checkpoints[key] = value
+	}
+	return rows.Err()
+}
@@ -0,0 +1,166 @@
+package pgtasklist
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+func TestListAndCancelByLabels(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	ids, err := list.WriteBatch(ctx, []common.RawTask{
+		{Description: "input: {}", Labels: map[string]string{"target": "ch-prod", "team": "data"}},
+		{Description: "input: {}", Labels: map[string]string{"target": "ch-prod"}},
+		{Description: "input: {}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := list.List(ctx, common.ListOptions{Labels: map[string]string{"target": "ch-prod", "team": "data"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].ID != ids[0] || infos[0].Labels["team"] != "data" {
+		t.Fatalf("tasks should have all the labels, got %+v", infos)
+	}
+
+	cancelled, err := list.CancelMatching(ctx, common.ListOptions{State: common.StatePending, Labels: map[string]string{"target": "ch-prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cancelled) != 2 {
+		t.Fatalf("pending tasks with the label should be cancelled, got %v", cancelled)
+	}
+	if info, _ := list.Status(ctx, "", ids[2]); info.State != common.StatePending {
+		t.Fatalf("task without the label should be kept, got %s", info.State)
+	}
+}
+
+func TestMarkOverdue(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	layout := "2006-01-02 15:04:05"
+	now := time.Now().In(list.location)
+	past := now.Add(-time.Minute).Format(layout)
+	future := now.Add(time.Hour).Format(layout)
+
+	ids, err := list.WriteBatch(ctx, []common.RawTask{
+		{Description: "input: {}", Deadline: past, ScheduledAt: future},
+		{Description: "input: {}", Deadline: future, ScheduledAt: future},
+		{Description: "input: {}", ScheduledAt: future},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := list.MarkOverdue(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].ID != ids[0] || infos[0].OverdueAt == nil {
+		t.Fatalf("only the task past its deadline should be marked, got %+v", infos)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now()); len(infos) != 1 || infos[0].ID != ids[0] {
+		t.Errorf("overdue task should be returned until notified, got %+v", infos)
+	}
+	if err := list.OverdueNotified(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now()); len(infos) != 0 {
+		t.Errorf("overdue task should be marked and notified once, got %+v", infos)
+	}
+	if n, _ := list.Count(ctx, common.ListOptions{State: common.StatePending, Overdue: true}); n != 1 {
+		t.Errorf("count of overdue tasks should be 1, got %d", n)
+	}
+
+	// 推后截止时间后不再逾期
+	err = list.Edit(ctx, "", ids[0], "ops", func(cur common.RawTask) (common.RawTask, error) {
+		cur.Deadline = future
+		return cur, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := list.Status(ctx, "", ids[0]); info.OverdueAt != nil {
+		t.Errorf("new deadline should clear overdue, got %+v", info)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now().Add(2*time.Hour)); len(infos) != 2 {
+		t.Errorf("both tasks should be overdue later, got %+v", infos)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	ns := "test-" + time.Now().Format("150405.000000")
+
+	ids, err := list.WriteBatch(ctx, []common.RawTask{{Description: "input: {}"}, {Namespace: ns, Description: "input: {}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := list.List(ctx, common.ListOptions{Namespace: ns})
+	if err != nil || len(infos) != 1 || infos[0].ID != ids[1] || infos[0].Namespace != ns {
+		t.Fatalf("only tasks in the namespace should be listed, got %+v %v", infos, err)
+	}
+	if info, _ := list.Status(ctx, "", ids[0]); info.Namespace != common.DefaultNamespace {
+		t.Errorf("tasks written without a namespace should be in the default one, got %+v", info)
+	}
+	if _, err := list.Status(ctx, ns, ids[0]); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("task in another namespace should be not found, got %v", err)
+	}
+	if err := list.Delete(ctx, ns, ids[0]); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("task in another namespace should not be cancelled, got %v", err)
+	}
+
+	// 锚点按命名空间隔离
+	if _, err := list.PutAnchor(ctx, ns, "db", "isolated"); err != nil {
+		t.Fatal(err)
+	}
+	set, err := list.ListAnchors(ctx, ns)
+	if err != nil || len(set.Anchors) != 1 || set.Anchors[0].Content != "isolated" {
+		t.Fatalf("anchors should be isolated, got %+v %v", set, err)
+	}
+	if history, _ := list.AnchorHistory(ctx, "", "db"); len(history) > 0 && history[0].Content == "isolated" {
+		t.Errorf("anchor should not leak into the default namespace, got %+v", history)
+	}
+}
+
+func TestRerunClearsPastDeadline(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	layout := "2006-01-02 15:04:05"
+	now := time.Now().In(list.location)
+
+	ids, err := list.WriteBatch(ctx, []common.RawTask{
+		{Description: "input: {}", Deadline: now.Add(-time.Minute).Format(layout), ScheduledAt: now.Add(time.Hour).Format(layout)},
+		{Description: "input: {}", Deadline: now.Add(time.Hour).Format(layout), ScheduledAt: now.Add(time.Hour).Format(layout)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list.CancelMatching(ctx, common.ListOptions{State: common.StatePending}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		if err := list.Rerun(ctx, "", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, _ := list.Status(ctx, "", ids[0]); info.Deadline != nil {
+		t.Errorf("rerun should clear a past deadline, got %+v", info)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now()); len(infos) != 0 {
+		t.Errorf("rerun task should not be overdue right away, got %+v", infos)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now().Add(2*time.Hour)); len(infos) != 1 || infos[0].ID != ids[1] {
+		t.Errorf("rerun should keep a deadline still ahead, got %+v", infos)
+	}
+}
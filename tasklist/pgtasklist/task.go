@@ -2,15 +2,25 @@ package pgtasklist
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/turnon/clams/tasklist/common"
 )
 
 // pgTask 代表一个任务
 type pgTask struct {
 	list        *pgTaskList
 	id          int
+	workerID    string
+	namespace   string
 	description string
+	params      map[string]string
+	window      string
+	concurrency []common.ConcurrencyGroup
 	aborted     chan struct{}
 }
 
@@ -19,11 +29,31 @@ func (t *pgTask) ID() string {
 	return strconv.Itoa(t.id)
 }
 
+// Namespace 返回任务所在的命名空间
+func (t *pgTask) Namespace() string {
+	return t.namespace
+}
+
 // Description 返回任务脚本
 func (t *pgTask) Description() string {
 	return t.description
 }
 
+// Params 返回任务参数
+func (t *pgTask) Params() map[string]string {
+	return t.params
+}
+
+// Window 返回任务允许执行的时间段
+func (t *pgTask) Window() string {
+	return t.window
+}
+
+// Concurrency 返回任务所属的并发组
+func (t *pgTask) Concurrency() []common.ConcurrencyGroup {
+	return t.concurrency
+}
+
 // Aborted 监听中止
 func (t *pgTask) Aborted() chan struct{} {
 	return t.aborted
@@ -31,13 +61,79 @@ func (t *pgTask) Aborted() chan struct{} {
 
 // Done 标记任务结束
 func (t *pgTask) Done(ctx context.Context) error {
-	_, err := t.list.conn.Exec(ctx, "update tasks set finished_at = $1 where id = $2", time.Now(), t.id)
-	return err
+	return t.finish(ctx, nil, "done")
 }
 
 // Done 标记任务错误
 func (t *pgTask) Error(ctx context.Context, err error) error {
-	sql := "update tasks set finished_at = $1, error = $2 where id = $3"
-	_, updateErr := t.list.conn.Exec(ctx, sql, time.Now(), err.Error(), t.id)
-	return updateErr
+	message := err.Error()
+	return t.finish(ctx, &message, "error: "+message)
+}
+
+// finish 记录结束时间；任务已被放回或由其他worker领取时不做改变，也不记日志
+func (t *pgTask) finish(ctx context.Context, message *string, logLine string) error {
+	sql := "update tasks set finished_at = $1, error = $2 where id = $3 and performed_by = $4"
+	tag, err := t.list.conn.Exec(ctx, sql, time.Now(), message, t.id, t.workerID)
+	if err == nil && tag.RowsAffected() == 0 {
+		t.list.debugf("task %d is no longer claimed by %s, %s ignored", t.id, t.workerID, logLine)
+		return nil
+	}
+	t.list.notifyNew()
+	t.list.log(ctx, t.id, logLine)
+	return err
+}
+
+// Defer 放回未开始的任务并推迟到until，释放所占的并发组；任务已被取消或已不归该worker时不再放回
+func (t *pgTask) Defer(ctx context.Context, until time.Time, reason string) error {
+	t.list.runningTasks.del(t.id)
+
+	sql := `
+	update tasks
+	set performed_at = null, performed_by = null, scheduled_at = $1
+	where id = $2
+	and performed_by = $3
+	and finished_at is null
+	and cancelled_at is null
+	`
+	// scheduled_at不带时区，写入list.location的钟点
+	tag, err := t.list.conn.Exec(ctx, sql, until.In(t.list.location), t.id, t.workerID)
+	if err == nil && tag.RowsAffected() == 0 {
+		return nil
+	}
+	t.list.notifyNew()
+	t.list.log(ctx, t.id, fmt.Sprintf("deferred until %s: %s", until.In(t.list.location).Format("2006-01-02 15:04:05"), reason))
+	return err
+}
+
+// UseAnchors 记录任务所用锚点的版本
+func (t *pgTask) UseAnchors(ctx context.Context, revision int) error {
+	_, err := t.list.conn.Exec(ctx, "update tasks set anchor_revision = $1 where id = $2", revision, t.id)
+	return err
+}
+
+// Log 记录任务日志
+func (t *pgTask) Log(ctx context.Context, message string) error {
+	return t.list.log(ctx, t.id, message)
+}
+
+// Checkpoint 读出任务的检查点
+func (t *pgTask) Checkpoint(ctx context.Context, key string) (string, error) {
+	var value string
+	sql := "select coalesce(value, '') from task_checkpoints where task_id = $1 and key = $2"
+	err := t.list.conn.QueryRow(ctx, sql, t.id, key).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && value == "") {
+		return "", common.ErrNotFound
+	}
+	return value, err
+}
+
+// SaveCheckpoint 保存任务的检查点
+func (t *pgTask) SaveCheckpoint(ctx context.Context, key string, value string) error {
+	sql := `
+	insert into task_checkpoints (task_id, key, value, updated_at)
+	values ($1, $2, $3, $4)
+	on conflict (task_id, key) do update set value = excluded.value, updated_at = excluded.updated_at
+	`
+	_, err := t.list.conn.Exec(ctx, sql, t.id, key, value, time.Now())
+	return err
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -31,19 +33,17 @@ func Init(ctx context.Context, cfg map[string]any) (*pgTaskList, error) {
 		ctx:          ctx,
 		conn:         conn,
 		location:     loc,
-		passedIds:    make([]int, 0, 10),
-		readyTaskIds: make(chan int),
 		runningTasks: newLocalcache(),
-		newSignal:    make(chan struct{}, 1),
+		wake:         make(chan struct{}),
 		abortSignal:  make(chan struct{}, 1),
+		anchorSignal: make(chan struct{}, 1),
 	}
-	if err := list.init(ctx); err != nil {
+	if err := list.migrate(ctx); err != nil {
 		return nil, err
 	}
 
 	go list.listenDbForChange()
 	go list.listenChanForAbort()
-	go list.loopDbAndListenChanForNew()
 
 	return list, nil
 }
@@ -53,11 +53,13 @@ type pgTaskList struct {
 	ctx          context.Context
 	conn         *pgxpool.Pool
 	location     *time.Location
-	passedIds    []int
-	readyTaskIds chan int
 	runningTasks *localcache
-	newSignal    chan struct{}
 	abortSignal  chan struct{}
+	anchorSignal chan struct{}
+	listening    atomic.Bool
+
+	wakeLock sync.Mutex
+	wake     chan struct{}
 }
 
 // debugf 打印调试信息
@@ -70,53 +72,63 @@ func (list *pgTaskList) errorf(str string, v ...any) {
 	log.Error().Str("mod", "tasklist").Msgf(str, v...)
 }
 
-// init 初始化pg任务列表
-func (list *pgTaskList) init(ctx context.Context) error {
-	_, err := list.conn.Exec(ctx, `
-	create table if not exists tasks (
-		id SERIAL PRIMARY KEY,
-		created_at TIMESTAMP,
-		scheduled_at TIMESTAMP,
-		performed_at TIMESTAMP,
-		finished_at TIMESTAMP,
-		cancelled_at TIMESTAMP,
-		description TEXT,
-		error TEXT
-	)`)
-	if err != nil {
-		return err
-	}
+// listenDbForChange 监听任务变化，连接断开后重连
+func (list *pgTaskList) listenDbForChange() {
+	backoff := time.Second
+	for {
+		err := list.listenOnce()
+		list.listening.Store(false)
+		if list.ctx.Err() != nil {
+			return
+		}
+		list.errorf("listen: %v, retry in %v", err, backoff)
 
-	return nil
+		select {
+		case <-list.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
 }
 
-// listenForChange 监听任务变化
-func (list *pgTaskList) listenDbForChange() {
-	list.conn.AcquireFunc(list.ctx, func(c *pgxpool.Conn) error {
-		_, listenErr := c.Exec(list.ctx, "listen "+tasksChannel)
-		if listenErr != nil {
-			list.errorf("list: %v", listenErr)
-			close(list.newSignal)
-			close(list.abortSignal)
+// listenOnce 在一个连接上监听，直到出错
+func (list *pgTaskList) listenOnce() error {
+	return list.conn.AcquireFunc(list.ctx, func(c *pgxpool.Conn) error {
+		if _, err := c.Exec(list.ctx, "listen "+tasksChannel); err != nil {
+			c.Conn().Close(context.Background())
+			return err
 		}
+		list.listening.Store(true)
 
-		for {
-			note, waitErr := c.Conn().WaitForNotification(list.ctx)
-			if errors.Is(waitErr, context.Canceled) {
-				return nil
+		// 断开期间可能错过了通知
+		list.wakeAll()
+		for _, ch := range []chan struct{}{list.abortSignal, list.anchorSignal} {
+			select {
+			case ch <- struct{}{}:
+			default:
 			}
-			if waitErr != nil {
-				list.errorf("WaitForNotification: %v", waitErr)
-				close(list.newSignal)
-				close(list.abortSignal)
-				return waitErr
+		}
+
+		for {
+			note, err := c.Conn().WaitForNotification(list.ctx)
+			if err != nil {
+				// 出错的连接不再放回连接池
+				c.Conn().Close(context.Background())
+				return err
 			}
 
 			var ch chan struct{}
-			if note.Payload == "abort" {
+			switch note.Payload {
+			case "abort":
 				ch = list.abortSignal
-			} else {
-				ch = list.newSignal
+			case "anchors":
+				ch = list.anchorSignal
+			default:
+				list.wakeAll()
+				continue
 			}
 
 			select {
@@ -127,6 +139,17 @@ func (list *pgTaskList) listenDbForChange() {
 	})
 }
 
+// Ping 检查与pg的连接，以及是否正在监听任务变化
+func (list *pgTaskList) Ping(ctx context.Context) error {
+	if err := list.conn.Ping(ctx); err != nil {
+		return err
+	}
+	if !list.listening.Load() {
+		return errors.New("not listening on " + tasksChannel)
+	}
+	return nil
+}
+
 // listenChanForAbort 监听任务中止
 func (list *pgTaskList) listenChanForAbort() {
 	for {
@@ -136,13 +159,12 @@ func (list *pgTaskList) listenChanForAbort() {
 		case <-list.abortSignal:
 			if err := list.abortTasks(); err != nil {
 				list.errorf("loop abortSignal: %v", err)
-				return
 			}
 		}
 	}
 }
 
-// abortTasks 中止运行中的任务
+// abortTasks 中止运行中已被取消，或因worker失联已被放回的任务
 func (list *pgTaskList) abortTasks() error {
 	return list.conn.AcquireFunc(list.ctx, func(c *pgxpool.Conn) error {
 		ids := list.runningTasks.getIds()
@@ -150,7 +172,7 @@ func (list *pgTaskList) abortTasks() error {
 			return nil
 		}
 
-		sql := "select id from tasks where cancelled_at is not null and id = any($1)"
+		sql := "select id, cancelled_at is not null, coalesce(performed_by, '') from tasks where id = any($1)"
 		rows, queryErr := c.Query(list.ctx, sql, ids)
 		if queryErr != nil {
 			return queryErr
@@ -158,32 +180,23 @@ func (list *pgTaskList) abortTasks() error {
 		defer rows.Close()
 
 		for rows.Next() {
-			var id int
-			if scanErr := rows.Scan(&id); scanErr != nil {
+			var (
+				id          int
+				cancelled   bool
+				performedBy string
+			)
+			if scanErr := rows.Scan(&id, &cancelled, &performedBy); scanErr != nil {
 				return scanErr
 			}
-			list.runningTasks.del(id)
+			if t := list.runningTasks.get(id); cancelled || t != nil && t.workerID != performedBy {
+				list.runningTasks.del(id)
+			}
 		}
 
 		return nil
 	})
 }
 
-// Read 返回一个任务
-func (list *pgTaskList) Read(ctx context.Context) (common.Task, error) {
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case id := <-list.readyTaskIds:
-			t, err := list.fetchOne(ctx, id)
-			if err == nil || err == context.Canceled {
-				return t, err
-			}
-		}
-	}
-}
-
 // Close 断开pg任务列表
 func (list *pgTaskList) Close(ctx context.Context) error {
 	list.conn.Close()
@@ -191,221 +204,113 @@ func (list *pgTaskList) Close(ctx context.Context) error {
 }
 
 // Peek 查看任务
-func (list *pgTaskList) Peek(ctx context.Context, idStr string) (common.RawTask, error) {
-	var desc string
-	list.conn.QueryRow(ctx, "select description from tasks where id = $1", idStr).Scan(&desc)
-
-	rawTask := common.RawTask{
-		Description: desc,
+func (list *pgTaskList) Peek(ctx context.Context, namespace string, idStr string) (common.RawTask, error) {
+	id, err := list.taskID(ctx, namespace, idStr)
+	if err != nil {
+		return common.RawTask{}, err
 	}
-	return rawTask, nil
-}
 
-// Delete 删除任务
-func (list *pgTaskList) Delete(ctx context.Context, idStr string) error {
-	idInt, idErr := strconv.Atoi(idStr)
-	if idErr != nil {
-		return idErr
+	var ns, desc string
+	err = list.conn.QueryRow(ctx, "select namespace, coalesce(description, '') from tasks where id = $1", id).Scan(&ns, &desc)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return common.RawTask{}, common.ErrNotFound
 	}
-
-	var cancelErr error
-	lockErr := list.lock(ctx, idInt, func(ctx context.Context, id int) {
-		sql := `
-		update tasks
-		set cancelled_at = $1
-		where id = $2
-		and cancelled_at is null
-		and finished_at is null
-		`
-
-		_, cancelErr = list.conn.Exec(ctx, sql, time.Now(), id)
-		if cancelErr != nil {
-			return
-		}
-
-		list.conn.Exec(context.Background(), "select pg_notify('"+tasksChannel+"', $1)", "abort")
-	})
-	if lockErr != nil {
-		return lockErr
+	if err != nil {
+		return common.RawTask{}, err
 	}
 
-	return cancelErr
+	rawTask := common.RawTask{
+		Namespace:   ns,
+		Description: desc,
+	}
+	return rawTask, nil
 }
 
-// Write 往pg写入一个任务
-func (list *pgTaskList) Write(ctx context.Context, rawTask common.RawTask) error {
-	scheduledAt := rawTask.ScheduledAt
-	if scheduledAt == "" {
-		scheduledAt = list.timeNowStr()
+// Delete 取消未结束的任务，运行中的任务会被中止
+func (list *pgTaskList) Delete(ctx context.Context, namespace string, idStr string) error {
+	id, err := list.taskID(ctx, namespace, idStr)
+	if err != nil {
+		return err
 	}
 
-	sql := "insert into tasks (description, created_at, scheduled_at) values ($1, $2, $3)"
-	_, err := list.conn.Exec(ctx, sql, rawTask.Description, time.Now(), scheduledAt)
-	if err != nil {
+	sql := `
+	update tasks
+	set cancelled_at = $1
+	where id = $2
+	and cancelled_at is null
+	and finished_at is null
+	`
+	if _, err := list.conn.Exec(ctx, sql, time.Now(), id); err != nil {
 		return err
 	}
 
-	list.conn.Exec(context.Background(), "select pg_notify('"+tasksChannel+"', $1)", "new")
+	list.conn.Exec(context.Background(), "select pg_notify('"+tasksChannel+"', $1)", "abort")
 	return nil
 }
 
-// loopDbAndListenChanForNew 从pg轮询新任务，也监听新任务
-func (list *pgTaskList) loopDbAndListenChanForNew() {
-	for {
-		err := list.fetchSomeIds()
-		list.debugf("loopDbAndListenChanForNew %v", err)
-
-		if errors.Is(err, pgx.ErrNoRows) {
-			select {
-			case <-list.newSignal:
-			case <-time.After(1 * time.Minute):
-			}
-			continue
-		}
-		if errors.Is(err, context.Canceled) {
-			return
-		}
+// Write 往pg写入一个任务
+func (list *pgTaskList) Write(ctx context.Context, rawTask common.RawTask) (string, error) {
+	ids, err := list.WriteBatch(ctx, []common.RawTask{rawTask})
+	if err != nil {
+		return "", err
 	}
+	return ids[0], nil
 }
 
-// fetchSomeIds 取出一些可执行的id
-func (list *pgTaskList) fetchSomeIds() error {
-	sql := `
-	select id
-	from tasks
-	where performed_at is null
-	and scheduled_at <= $1
-	and id <> any($2)
-	and finished_at is null
-	and cancelled_at is null
-	order by scheduled_at
-	limit 10`
-
-	if len(list.passedIds) == 0 {
-		list.passedIds = append(list.passedIds, 0)
+// WriteBatch 在一个事务中写入多个任务，返回的id与rawTasks一一对应
+func (list *pgTaskList) WriteBatch(ctx context.Context, rawTasks []common.RawTask) ([]string, error) {
+	tx, err := list.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback(context.Background())
 
-	idSet := make(map[int]struct{})
-	funcErr := list.conn.AcquireFunc(list.ctx, func(c *pgxpool.Conn) error {
-		rows, queryErr := c.Query(list.ctx, sql, list.timeNowStr(), list.passedIds)
-		if queryErr != nil {
-			return queryErr
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var id int
-			if scanErr := rows.Scan(&id); scanErr != nil {
-				return scanErr
-			}
-			idSet[id] = struct{}{}
+	ids := make([]string, 0, len(rawTasks))
+	for _, rawTask := range rawTasks {
+		id, err := list.insertTask(ctx, tx, rawTask)
+		if err != nil {
+			return nil, err
 		}
-		return nil
-	})
-	list.passedIds = list.passedIds[:0]
-	if funcErr != nil {
-		return funcErr
+		ids = append(ids, strconv.Itoa(id))
 	}
 
-	if len(idSet) == 0 {
-		return pgx.ErrNoRows
-	}
-
-	maybeOutdated := time.After(1 * time.Minute)
-	for id := range idSet {
-		select {
-		case <-list.ctx.Done():
-			return list.ctx.Err()
-		case <-maybeOutdated:
-			return nil
-		case list.readyTaskIds <- id:
-			list.passedIds = append(list.passedIds, id)
-		}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
 	}
 
-	return nil
+	list.notifyNew()
+	return ids, nil
 }
 
-// fetchOne 从pg读出一个任务
-func (list *pgTaskList) fetchOne(ctx context.Context, id int) (common.Task, error) {
-	var (
-		t        common.Task
-		fetchErr error
-	)
-	lockErr := list.lock(ctx, id, func(ctx context.Context, id int) {
-		t, fetchErr = list._fetchOne(ctx, id)
-	})
-	if lockErr != nil {
-		return nil, lockErr
+// insertTask 在事务中插入一个任务
+func (list *pgTaskList) insertTask(ctx context.Context, tx pgx.Tx, rawTask common.RawTask) (int, error) {
+	scheduledAt := rawTask.ScheduledAt
+	if scheduledAt == "" {
+		scheduledAt = list.timeNowStr()
 	}
-	if fetchErr != nil {
-		return nil, fetchErr
+
+	var id int
+	var params map[string]string
+	if len(rawTask.Params) > 0 {
+		params = rawTask.Params
 	}
-	return t, fetchErr
-}
 
-// lock 加锁
-func (list *pgTaskList) lock(ctx context.Context, id int, fn func(context.Context, int)) error {
-	var lockable bool
-	err := list.conn.AcquireFunc(ctx, func(c *pgxpool.Conn) error {
-		return c.QueryRow(ctx, "select pg_try_advisory_lock($1)", id).Scan(&lockable)
-	})
+	sql := "insert into tasks (namespace, description, created_at, scheduled_at, params, labels, run_window, deadline) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id"
+	err := tx.QueryRow(ctx, sql, namespaceOr(rawTask.Namespace), rawTask.Description, time.Now(), scheduledAt, params, nullIfEmpty(rawTask.Labels),
+		nullIfBlank(rawTask.Window), nullIfBlank(rawTask.Deadline)).Scan(&id)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if !lockable {
-		return pgx.ErrNoRows
-	}
-	defer list.conn.AcquireFunc(ctx, func(c *pgxpool.Conn) error {
-		c.QueryRow(ctx, "select pg_advisory_unlock($1)", id)
-		return nil
-	})
 
-	fn(ctx, id)
-	return nil
+	if err := list.writeConcurrency(ctx, tx, id, rawTask.Concurrency); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-// _fetchOne 从pg读出一个任务
-func (list *pgTaskList) _fetchOne(ctx context.Context, id int) (common.Task, error) {
-	var t *pgTask
-	fnErr := list.conn.AcquireFunc(ctx, func(c *pgxpool.Conn) error {
-		markPerforming := `
-		update tasks
-		set performed_at = $1
-		where id = $2
-		and scheduled_at <= $3
-		and performed_at is null
-		and cancelled_at is null
-		returning description
-		`
-
-		now := list.timeNowStr()
-		rows, err := c.Query(ctx, markPerforming, now, id, now)
-		if err != nil {
-			return err
-		}
-
-		var desc string
-		for rows.Next() {
-			if err = rows.Scan(&desc); err != nil {
-				return err
-			}
-		}
-		if desc == "" {
-			return pgx.ErrNoRows
-		}
-
-		t = &pgTask{
-			id:          id,
-			list:        list,
-			description: desc,
-			aborted:     make(chan struct{}),
-		}
-		list.runningTasks.set(id, t)
-		return nil
-	})
-
-	return t, fnErr
+// notifyNew 通知有任务可执行
+func (list *pgTaskList) notifyNew() {
+	list.conn.Exec(context.Background(), "select pg_notify('"+tasksChannel+"', $1)", "new")
 }
 
 // timeNowStr 当前时间
@@ -0,0 +1,126 @@
+package pgtasklist
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// workerRetention 超过这么久没有心跳的worker会被清除
+const workerRetention = 24 * time.Hour
+
+// Heartbeat 登记worker及其当前任务
+func (list *pgTaskList) Heartbeat(ctx context.Context, info common.WorkerInfo) error {
+	var taskID *int
+	if id, err := strconv.Atoi(info.TaskID); err == nil {
+		taskID = &id
+	}
+
+	sql := `
+	insert into workers (id, host, pid, started_at, heartbeat_at, task_id)
+	values ($1, $2, $3, $4, $5, $6)
+	on conflict (id) do update set heartbeat_at = excluded.heartbeat_at, task_id = excluded.task_id
+	`
+	now := time.Now()
+	if _, err := list.conn.Exec(ctx, sql, info.ID, info.Host, info.Pid, info.StartedAt, now, taskID); err != nil {
+		return err
+	}
+
+	_, err := list.conn.Exec(ctx, "delete from workers where heartbeat_at < $1", now.Add(-workerRetention))
+	return err
+}
+
+// UnregisterWorker 注销worker
+func (list *pgTaskList) UnregisterWorker(ctx context.Context, id string) error {
+	_, err := list.conn.Exec(ctx, "delete from workers where id = $1", id)
+	return err
+}
+
+// Workers 列出worker，timeout内有心跳的为存活
+func (list *pgTaskList) Workers(ctx context.Context, timeout time.Duration) ([]common.WorkerInfo, error) {
+	sql := `
+	select id, coalesce(host, ''), coalesce(pid, 0), started_at, heartbeat_at, coalesce(task_id::text, ''), heartbeat_at >= $1
+	from workers
+	order by id
+	`
+	rows, err := list.conn.Query(ctx, sql, time.Now().Add(-timeout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := []common.WorkerInfo{}
+	for rows.Next() {
+		var info common.WorkerInfo
+		err := rows.Scan(&info.ID, &info.Host, &info.Pid, &info.StartedAt, &info.HeartbeatAt, &info.TaskID, &info.Alive)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, info)
+	}
+	return workers, rows.Err()
+}
+
+// RequeueOrphans 把执行的worker已超过timeout没有心跳的运行中任务放回待执行，释放其并发组；
+// 领取后不到timeout的任务不算，以免worker还没来得及登记心跳
+func (list *pgTaskList) RequeueOrphans(ctx context.Context, timeout time.Duration) ([]string, error) {
+	sql := `
+	with orphans as (
+		select t.id, coalesce(t.performed_by, '') as performed_by
+		from tasks t
+		where t.performed_at is not null
+		and t.finished_at is null
+		and t.cancelled_at is null
+		and t.performed_at < $2
+		and not exists (
+			select 1 from workers w where w.id = t.performed_by and w.heartbeat_at >= $3
+		)
+		for update skip locked
+	)
+	update tasks t
+	set performed_at = null, performed_by = null, scheduled_at = $1
+	from orphans
+	where t.id = orphans.id
+	returning orphans.id, orphans.performed_by
+	`
+	now := time.Now()
+	cutoff := now.Add(-timeout)
+	rows, err := list.conn.Query(ctx, sql, list.timeNowStr(), cutoff.In(list.location).Format("2006-01-02 15:04:05"), cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requeued := map[int]string{}
+	for rows.Next() {
+		var (
+			id          int
+			performedBy string
+		)
+		if err := rows.Scan(&id, &performedBy); err != nil {
+			return nil, err
+		}
+		requeued[id] = performedBy
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(requeued) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(requeued))
+	for id, performedBy := range requeued {
+		list.log(ctx, id, "requeued: "+performedBy+" stopped heartbeating")
+		ids = append(ids, strconv.Itoa(id))
+	}
+	sort.Strings(ids)
+
+	// 失联的worker如果还在运行，让它中止
+	list.conn.Exec(context.Background(), "select pg_notify('"+tasksChannel+"', $1)", "abort")
+	list.notifyNew()
+	return ids, nil
+}
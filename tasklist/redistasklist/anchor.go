@@ -0,0 +1,125 @@
+package redistasklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// ListAnchors 列出命名空间中所有生效的锚点
+func (list *redisTaskList) ListAnchors(ctx context.Context, namespace string) (common.AnchorSet, error) {
+	set := common.AnchorSet{Anchors: []common.Anchor{}}
+
+	names, err := list.client.SMembers(ctx, list.anchorNamesKey(namespace)).Result()
+	if err != nil {
+		return set, err
+	}
+	sort.Strings(names)
+
+	cmds := make([]*redis.StringCmd, len(names))
+	_, err = list.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, name := range names {
+			cmds[i] = pipe.LIndex(ctx, list.anchorKey(namespace, name), -1)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return set, err
+	}
+
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			continue
+		}
+		var anchor common.Anchor
+		if err := json.Unmarshal([]byte(cmd.Val()), &anchor); err != nil {
+			return set, err
+		}
+		if anchor.Revision > set.Revision {
+			set.Revision = anchor.Revision
+		}
+		if !anchor.Deleted {
+			set.Anchors = append(set.Anchors, anchor)
+		}
+	}
+	return set, nil
+}
+
+// AnchorHistory 列出锚点的所有版本
+func (list *redisTaskList) AnchorHistory(ctx context.Context, namespace string, name string) ([]common.Anchor, error) {
+	raw, err := list.client.LRange(ctx, list.anchorKey(namespace, name), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, common.ErrNotFound
+	}
+
+	anchors := make([]common.Anchor, 0, len(raw))
+	for i := len(raw) - 1; i >= 0; i-- {
+		var anchor common.Anchor
+		if err := json.Unmarshal([]byte(raw[i]), &anchor); err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors, nil
+}
+
+// PutAnchor 新增或修改锚点
+func (list *redisTaskList) PutAnchor(ctx context.Context, namespace string, name string, content string) (common.Anchor, error) {
+	return list.appendAnchor(ctx, namespace, name, content, false)
+}
+
+// DeleteAnchor 删除锚点，保留历史版本
+func (list *redisTaskList) DeleteAnchor(ctx context.Context, namespace string, name string) error {
+	_, err := list.appendAnchor(ctx, namespace, name, "", true)
+	return err
+}
+
+// AnchorsChanged 监听锚点变化
+func (list *redisTaskList) AnchorsChanged() chan struct{} {
+	return list.anchorSignal
+}
+
+// appendAnchor 为锚点追加一个版本，并通知其他服务器
+func (list *redisTaskList) appendAnchor(ctx context.Context, namespace string, name string, content string, deleted bool) (common.Anchor, error) {
+	anchor := common.Anchor{Name: name, Content: content, Deleted: deleted}
+
+	flag := "0"
+	if deleted {
+		flag = "1"
+	}
+	keys := []string{list.anchorKey(namespace, name), list.anchorNamesKey(namespace), list.key("anchor_seq")}
+	raw, err := anchorScript.Run(ctx, list.client, keys, name, content, flag, formatTime(time.Now()), list.key("channel")).Text()
+	if errors.Is(err, redis.Nil) {
+		return anchor, common.ErrNotFound
+	}
+	if err != nil {
+		return anchor, err
+	}
+
+	err = json.Unmarshal([]byte(raw), &anchor)
+	return anchor, err
+}
+
+// anchorKey 锚点各版本的列表，默认命名空间沿用原来的key
+func (list *redisTaskList) anchorKey(namespace string, name string) string {
+	if namespace == "" || namespace == common.DefaultNamespace {
+		return list.key("anchor", name)
+	}
+	return list.key("anchor", namespace+"/"+name)
+}
+
+// anchorNamesKey 命名空间中所有锚点名的集合
+func (list *redisTaskList) anchorNamesKey(namespace string) string {
+	if namespace == "" || namespace == common.DefaultNamespace {
+		return list.key("anchor_names")
+	}
+	return list.key("anchor_names", namespace)
+}
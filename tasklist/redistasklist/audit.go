@@ -0,0 +1,74 @@
+package redistasklist
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// Audit 追加一条审计记录
+func (list *redisTaskList) Audit(ctx context.Context, entry common.AuditEntry) error {
+	id, err := list.client.Incr(ctx, list.key("audit_seq")).Result()
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return list.client.ZAdd(ctx, list.key("audit"), redis.Z{Score: float64(id), Member: raw}).Err()
+}
+
+// AuditLog 查询审计记录，新的在前
+func (list *redisTaskList) AuditLog(ctx context.Context, opts common.AuditOptions) ([]common.AuditEntry, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+
+	entries := []common.AuditEntry{}
+	skipped := 0
+	const page = 500
+	for start := int64(0); ; start += page {
+		raw, err := list.client.ZRevRange(ctx, list.key("audit"), start, start+page-1).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, str := range raw {
+			var entry common.AuditEntry
+			if err := json.Unmarshal([]byte(str), &entry); err != nil {
+				return nil, err
+			}
+			// 按id倒序，早于since之后的都不用再看
+			if !opts.Since.IsZero() && entry.At.Before(opts.Since) {
+				return entries, nil
+			}
+			if !auditMatches(entry, opts) {
+				continue
+			}
+			if skipped < opts.Offset {
+				skipped++
+				continue
+			}
+			entries = append(entries, entry)
+			if len(entries) >= opts.Limit {
+				return entries, nil
+			}
+		}
+
+		if len(raw) < page {
+			return entries, nil
+		}
+	}
+}
+
+// auditMatches 审计记录是否符合条件
+func auditMatches(entry common.AuditEntry, opts common.AuditOptions) bool {
+	return (opts.Caller == "" || entry.Caller == opts.Caller) &&
+		(opts.Action == "" || entry.Action == opts.Action) &&
+		(opts.Target == "" || entry.Target == opts.Target)
+}
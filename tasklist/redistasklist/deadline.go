@@ -0,0 +1,57 @@
+package redistasklist
+
+import (
+	"context"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// overdueBatchSize 每次脚本检查的任务数
+const overdueBatchSize = 100
+
+// MarkOverdue 把过了截止时间仍未开始或仍在运行的任务标为逾期，返回这次新标记的任务，
+// 以及之前标记但还没通知到的未结束的任务；检查过的任务移出deadlines，已结束的任务只移出不标记，任务照常执行
+func (list *redisTaskList) MarkOverdue(ctx context.Context, now time.Time) ([]common.TaskInfo, error) {
+	marked := map[string]bool{}
+	for {
+		res, err := overdueScript.Run(ctx, list.client, []string{list.key("deadlines"), list.key("overdue"), list.key("overdue_unnotified")}, list.prefix, now.UnixMilli(), formatTime(now), overdueBatchSize).Slice()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range res[1:] {
+			marked[id.(string)] = true
+		}
+		if checked, _ := res[0].(int64); checked < overdueBatchSize {
+			break
+		}
+	}
+
+	ids, err := list.client.ZRange(ctx, list.key("overdue_unnotified"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := list.hashes(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]common.TaskInfo, 0, len(ids))
+	for i, id := range ids {
+		info := taskInfo(id, hashes[i])
+		if len(hashes[i]) == 0 || info.FinishedAt != nil || info.CancelledAt != nil {
+			// 没通知到就已结束的任务不再通知
+			list.client.ZRem(ctx, list.key("overdue_unnotified"), id)
+			continue
+		}
+		if marked[id] {
+			list.log(ctx, id, "overdue, deadline was "+info.Deadline.In(list.location).Format("2006-01-02 15:04:05"))
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// OverdueNotified 记下逾期通知已送达
+func (list *redisTaskList) OverdueNotified(ctx context.Context, id string) error {
+	return list.client.ZRem(ctx, list.key("overdue_unnotified"), id).Err()
+}
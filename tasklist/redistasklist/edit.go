@@ -0,0 +1,187 @@
+package redistasklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// editRetries 任务在读出和写入之间被其他客户端改动时重试的次数
+const editRetries = 3
+
+// Edit 修改尚未被领取的任务，修改前的版本追加到revisions:<id>；
+// 用WATCH保证读出到写入之间任务没有被领取，被领取时事务失败，重试时即会发现任务已不是pending
+func (list *redisTaskList) Edit(ctx context.Context, namespace string, id string, editor string, edit func(common.RawTask) (common.RawTask, error)) error {
+	key := list.key("task", id)
+	var revision int
+
+	txf := func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 || namespace != "" && namespaceOf(fields["namespace"]) != namespace {
+			return common.ErrNotFound
+		}
+		if stateOf(fields) != common.StatePending {
+			return fmt.Errorf("%w: task %s has been claimed or ended", common.ErrConflict, id)
+		}
+
+		info := taskInfo(id, fields)
+		cur := common.RawTask{
+			Namespace:   info.Namespace,
+			Description: fields["description"],
+			Concurrency: info.Concurrency,
+			Params:      info.Params,
+			Labels:      info.Labels,
+			Window:      info.Window,
+		}
+		if info.ScheduledAt != nil {
+			cur.ScheduledAt = info.ScheduledAt.In(list.location).Format("2006-01-02 15:04:05")
+		}
+		if info.Deadline != nil {
+			cur.Deadline = info.Deadline.In(list.location).Format("2006-01-02 15:04:05")
+		}
+
+		next, err := edit(cur)
+		if err != nil {
+			return err
+		}
+		at, err := list.parseScheduledAt(next.ScheduledAt)
+		if err != nil {
+			return err
+		}
+		deadline, err := list.parseDeadline(next.Deadline)
+		if err != nil {
+			return err
+		}
+
+		revision = info.Revision
+		prev, err := json.Marshal(common.TaskRevision{
+			Revision:    revision,
+			Description: cur.Description,
+			ScheduledAt: info.ScheduledAt,
+			Concurrency: cur.Concurrency,
+			Params:      cur.Params,
+			Labels:      cur.Labels,
+			Window:      cur.Window,
+			Deadline:    info.Deadline,
+			ReplacedAt:  time.Now(),
+			ReplacedBy:  editor,
+		})
+		if err != nil {
+			return err
+		}
+
+		set := []any{"description", next.Description, "scheduled_at", formatTime(at), "revision", revision + 1}
+		del := []string{}
+		if next.Window != "" {
+			set = append(set, "window", next.Window)
+		} else {
+			del = append(del, "window")
+		}
+		// 截止时间改了就重新判断是否逾期
+		deadlineChanged := next.Deadline != cur.Deadline
+		if deadline != nil {
+			set = append(set, "deadline", formatTime(*deadline), "deadline_ts", deadline.UnixMilli())
+		} else {
+			del = append(del, "deadline", "deadline_ts")
+		}
+		if deadlineChanged {
+			del = append(del, "overdue_at")
+		}
+		for field, value := range map[string]any{"concurrency": next.Concurrency, "params": next.Params, "labels": next.Labels} {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			if string(raw) == "null" || string(raw) == "[]" || string(raw) == "{}" {
+				del = append(del, field)
+			} else {
+				set = append(set, field, string(raw))
+			}
+		}
+
+		idNum, _ := strconv.Atoi(id)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.RPush(ctx, list.key("revisions", id), prev)
+			pipe.HSet(ctx, key, set...)
+			if len(del) > 0 {
+				pipe.HDel(ctx, key, del...)
+			}
+			for k, v := range cur.Labels {
+				pipe.ZRem(ctx, list.labelKey(k, v), id)
+			}
+			for k, v := range next.Labels {
+				pipe.ZAdd(ctx, list.labelKey(k, v), redis.Z{Score: float64(idNum), Member: id})
+			}
+			pipe.ZAdd(ctx, list.key("scheduled"), redis.Z{Score: float64(at.UnixMilli()), Member: id})
+			if deadlineChanged {
+				pipe.ZRem(ctx, list.key("overdue"), id)
+				pipe.ZRem(ctx, list.key("overdue_unnotified"), id)
+				if deadline != nil {
+					pipe.ZAdd(ctx, list.key("deadlines"), redis.Z{Score: float64(deadline.UnixMilli()), Member: id})
+				} else {
+					pipe.ZRem(ctx, list.key("deadlines"), id)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < editRetries; i++ {
+		err = list.client.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	list.log(ctx, id, fmt.Sprintf("edited by %s, revision %d", editor, revision+1))
+	// 计划时间可能提前了
+	list.publish("new")
+	return nil
+}
+
+// Revisions 列出任务被修改前的各个版本
+func (list *redisTaskList) Revisions(ctx context.Context, namespace string, id string) ([]common.TaskRevision, error) {
+	exists, err := list.client.Exists(ctx, list.key("task", id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, common.ErrNotFound
+	}
+	if err := list.inNamespace(ctx, namespace, id); err != nil {
+		return nil, err
+	}
+
+	raw, err := list.client.LRange(ctx, list.key("revisions", id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseRevisions(raw)
+}
+
+// parseRevisions 解析修改前的版本列表
+func parseRevisions(raw []string) ([]common.TaskRevision, error) {
+	revisions := make([]common.TaskRevision, 0, len(raw))
+	for _, str := range raw {
+		var rev common.TaskRevision
+		if err := json.Unmarshal([]byte(str), &rev); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, nil
+}
@@ -0,0 +1,208 @@
+package redistasklist
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// exportBatchSize 导出时每批读出的任务数
+const exportBatchSize = 500
+
+// Export 按id从小到大分批读出符合条件的任务的完整记录，交给write
+func (list *redisTaskList) Export(ctx context.Context, opts common.ListOptions, write func([]common.TaskRecord) error) error {
+	key, store := list.filter(opts)
+	if store != nil {
+		// 导出期间保留交集
+		key = list.tmpKey()
+
+		_, err := list.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZInterStore(ctx, key, store)
+			pipe.Expire(ctx, key, time.Hour)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		defer list.client.Del(context.Background(), key)
+	}
+
+	min := "-inf"
+	for {
+		ids, err := list.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: min, Max: "+inf", Count: exportBatchSize}).Result()
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		records, err := list.records(ctx, ids)
+		if err != nil {
+			return err
+		}
+		if len(records) > 0 {
+			if err := write(records); err != nil {
+				return err
+			}
+		}
+		min = "(" + ids[len(ids)-1]
+	}
+}
+
+// Import 写入导出的任务，分配新的id，返回的id与records一一对应；
+// 状态由各时间字段决定，运行中的任务应先改为未开始
+func (list *redisTaskList) Import(ctx context.Context, records []common.TaskRecord) ([]string, error) {
+	if len(records) == 0 {
+		return []string{}, nil
+	}
+
+	last, err := list.client.IncrBy(ctx, list.key("seq"), int64(len(records))).Result()
+	if err != nil {
+		return nil, err
+	}
+	first := int(last) - len(records) + 1
+
+	ids := make([]string, 0, len(records))
+	now := time.Now()
+	_, err = list.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, record := range records {
+			id := strconv.Itoa(first + i)
+			ids = append(ids, id)
+
+			fields, err := importFields(record, now)
+			if err != nil {
+				return err
+			}
+			pipe.HSet(ctx, list.key("task", id), fields)
+
+			score := redis.Z{Score: float64(first + i), Member: id}
+			pipe.ZAdd(ctx, list.key("tasks"), score)
+			pipe.ZAdd(ctx, list.key("ns", fields["namespace"]), score)
+			for k, v := range record.Labels {
+				pipe.ZAdd(ctx, list.labelKey(k, v), score)
+			}
+			state := stateOf(fields)
+			pipe.ZAdd(ctx, list.key("state", state), score)
+			switch state {
+			case common.StatePending:
+				at := now
+				if record.ScheduledAt != nil {
+					at = *record.ScheduledAt
+				}
+				pipe.ZAdd(ctx, list.key("scheduled"), redis.Z{Score: float64(at.UnixMilli()), Member: id})
+			case common.StateDone, common.StateError, common.StateCancelled:
+				pipe.ZAdd(ctx, list.key("ended"), redis.Z{Score: float64(endedAt(record.TaskInfo).UnixMilli()), Member: id})
+			}
+			if record.OverdueAt != nil {
+				pipe.ZAdd(ctx, list.key("overdue"), score)
+			} else if record.Deadline != nil && state == common.StatePending {
+				pipe.ZAdd(ctx, list.key("deadlines"), redis.Z{Score: float64(record.Deadline.UnixMilli()), Member: id})
+			}
+
+			logs := make([]common.LogLine, 0, len(record.Logs)+1)
+			logs = append(logs, record.Logs...)
+			logs = append(logs, common.LogLine{At: now, Message: "imported from task " + record.ID})
+			lines := make([]any, 0, len(logs))
+			for _, line := range logs {
+				raw, err := json.Marshal(line)
+				if err != nil {
+					return err
+				}
+				lines = append(lines, raw)
+			}
+			pipe.RPush(ctx, list.key("logs", id), lines...)
+
+			if len(record.Checkpoints) > 0 {
+				pipe.HSet(ctx, list.key("checkpoints", id), record.Checkpoints)
+			}
+			if len(record.Revisions) > 0 {
+				revisions := make([]any, 0, len(record.Revisions))
+				for _, rev := range record.Revisions {
+					raw, err := json.Marshal(rev)
+					if err != nil {
+						return err
+					}
+					revisions = append(revisions, raw)
+				}
+				pipe.RPush(ctx, list.key("revisions", id), revisions...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list.publish("new")
+	return ids, nil
+}
+
+// importFields 由导出的记录生成任务的hash，没有创建时间的按now
+func importFields(record common.TaskRecord, now time.Time) (map[string]string, error) {
+	createdAt := now
+	if record.CreatedAt != nil {
+		createdAt = *record.CreatedAt
+	}
+	scheduledAt := now
+	if record.ScheduledAt != nil {
+		scheduledAt = *record.ScheduledAt
+	}
+	fields := map[string]string{
+		"namespace":    namespaceOr(record.Namespace),
+		"description":  record.Description,
+		"created_at":   formatTime(createdAt),
+		"scheduled_at": formatTime(scheduledAt),
+		"revision":     strconv.Itoa(record.Revision),
+	}
+
+	for field, at := range map[string]*time.Time{"performed_at": record.PerformedAt, "finished_at": record.FinishedAt, "cancelled_at": record.CancelledAt} {
+		if at != nil {
+			fields[field] = formatTime(*at)
+		}
+	}
+	if record.FinishedAt != nil || record.CancelledAt != nil {
+		fields["ended_ts"] = strconv.FormatInt(endedAt(record.TaskInfo).UnixMilli(), 10)
+	}
+	if record.Error != "" {
+		fields["error"] = record.Error
+	}
+	if record.PerformedBy != "" {
+		fields["performed_by"] = record.PerformedBy
+	}
+	if record.Window != "" {
+		fields["window"] = record.Window
+	}
+	if record.Deadline != nil {
+		fields["deadline"] = formatTime(*record.Deadline)
+		fields["deadline_ts"] = strconv.FormatInt(record.Deadline.UnixMilli(), 10)
+	}
+	if record.OverdueAt != nil {
+		fields["overdue_at"] = formatTime(*record.OverdueAt)
+	}
+	if record.AnchorRevision != nil {
+		fields["anchor_revision"] = strconv.Itoa(*record.AnchorRevision)
+	}
+
+	for field, value := range map[string]any{"concurrency": record.Concurrency, "params": record.Params, "labels": record.Labels} {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if string(raw) != "null" && string(raw) != "[]" && string(raw) != "{}" {
+			fields[field] = string(raw)
+		}
+	}
+	return fields, nil
+}
+
+// endedAt 任务的结束时间，取消优先
+func endedAt(info common.TaskInfo) time.Time {
+	if info.CancelledAt != nil {
+		return *info.CancelledAt
+	}
+	if info.FinishedAt != nil {
+		return *info.FinishedAt
+	}
+	return time.Now()
+}
@@ -0,0 +1,116 @@
+package redistasklist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// leaderTTL 领导者的锁的有效期，每隔三分之一续期一次；领导者崩溃后其他服务器最迟这么久后接替
+var leaderTTL = 15 * time.Second
+
+// Elect 持有leader:<name>这个锁即为领导者，阻塞直到当选或ctx结束
+func (list *redisTaskList) Elect(ctx context.Context, name, candidate string) (common.Leadership, error) {
+	token := make([]byte, 16)
+	rand.Read(token)
+
+	leader := &redisLeader{
+		list:  list,
+		name:  name,
+		key:   list.key("leader", name),
+		token: candidate + "/" + hex.EncodeToString(token),
+		ttl:   leaderTTL,
+		lost:  make(chan struct{}),
+	}
+
+	for {
+		locked, err := list.client.SetNX(ctx, leader.key, leader.token, leader.ttl).Result()
+		if locked {
+			go leader.renew()
+			return leader, nil
+		}
+		if err != nil && ctx.Err() == nil {
+			list.errorf("elect %s: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(leader.ttl / 3):
+		}
+	}
+}
+
+// redisLeader 持有锁的领导者
+type redisLeader struct {
+	list  *redisTaskList
+	name  string
+	key   string
+	token string
+	ttl   time.Duration
+
+	lock  sync.Mutex
+	ended bool
+	lost  chan struct{}
+}
+
+// Lost 失去或让出领导权时关闭
+func (leader *redisLeader) Lost() chan struct{} {
+	return leader.lost
+}
+
+// renew 定期续期，锁被他人持有或到期前没能续上即失去领导权
+func (leader *redisLeader) renew() {
+	ticker := time.NewTicker(leader.ttl / 3)
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-leader.lost:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), leader.ttl/3)
+		renewed, err := renewScript.Run(ctx, leader.list.client, []string{leader.key}, leader.token, leader.ttl.Milliseconds()).Int()
+		cancel()
+		if err == nil && renewed == 1 {
+			renewedAt = time.Now()
+			continue
+		}
+		// redis暂时不可用时，锁到期前还可以重试
+		if err != nil && time.Since(renewedAt) < leader.ttl {
+			leader.list.errorf("renew leader %s: %v", leader.name, err)
+			continue
+		}
+
+		leader.list.errorf("leader %s is lost", leader.name)
+		leader.end()
+		return
+	}
+}
+
+// end 通知失去领导权
+func (leader *redisLeader) end() bool {
+	leader.lock.Lock()
+	defer leader.lock.Unlock()
+	if leader.ended {
+		return false
+	}
+	leader.ended = true
+	close(leader.lost)
+	return true
+}
+
+// Resign 释放锁，其他服务器随即可以当选
+func (leader *redisLeader) Resign(ctx context.Context) error {
+	if !leader.end() {
+		return nil
+	}
+	return unlockScript.Run(ctx, leader.list.client, []string{leader.key}, leader.token).Err()
+}
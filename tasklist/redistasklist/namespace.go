@@ -0,0 +1,38 @@
+package redistasklist
+
+import (
+	"context"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// inNamespace namespace不为空时任务须在其中，否则视为不存在；不存在的任务交给之后的操作判断
+func (list *redisTaskList) inNamespace(ctx context.Context, namespace string, id string) error {
+	if namespace == "" {
+		return nil
+	}
+	fields, err := list.client.HMGet(ctx, list.key("task", id), "created_at", "namespace").Result()
+	if err != nil {
+		return err
+	}
+	if fields[0] != nil && namespaceOf(fields[1]) != namespace {
+		return common.ErrNotFound
+	}
+	return nil
+}
+
+// namespaceOf 任务hash中的命名空间，有命名空间之前的任务没有这个字段
+func namespaceOf(field any) string {
+	if ns, ok := field.(string); ok && ns != "" {
+		return ns
+	}
+	return common.DefaultNamespace
+}
+
+// namespaceOr 为空时即默认的命名空间
+func namespaceOr(namespace string) string {
+	if namespace == "" {
+		return common.DefaultNamespace
+	}
+	return namespace
+}
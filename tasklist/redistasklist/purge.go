@@ -0,0 +1,180 @@
+package redistasklist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// purgeLockTTL 清理锁的有效期，持有者崩溃后其他服务器最迟这么久后可以接手
+const purgeLockTTL = 10 * time.Minute
+
+// Purge 按保留策略删除已结束的任务，删除前交给archive归档；其他服务器正在清理时直接返回
+func (list *redisTaskList) Purge(ctx context.Context, policy common.PurgePolicy, archive func([]common.TaskRecord) error) (int, error) {
+	cutoffs, latest := purgeCutoffs(policy)
+	if latest == 0 {
+		return 0, nil
+	}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 500
+	}
+
+	lockKey := list.key("purge_lock")
+	token := make([]byte, 16)
+	rand.Read(token)
+	locked, err := list.client.SetNX(ctx, lockKey, hex.EncodeToString(token), purgeLockTTL).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !locked {
+		list.debugf("purge is running on another server")
+		return 0, nil
+	}
+	defer unlockScript.Run(context.Background(), list.client, []string{lockKey}, hex.EncodeToString(token))
+
+	purged := 0
+	offset := int64(0)
+	for {
+		ids, err := list.client.ZRangeByScore(ctx, list.key("ended"), &redis.ZRangeBy{
+			Min:    "-inf",
+			Max:    "(" + strconv.FormatInt(latest, 10),
+			Offset: offset,
+			Count:  int64(policy.BatchSize),
+		}).Result()
+		if err != nil || len(ids) == 0 {
+			return purged, err
+		}
+
+		records, err := list.records(ctx, ids)
+		if err != nil {
+			return purged, err
+		}
+
+		expired := make([]common.TaskRecord, 0, len(records))
+		for _, record := range records {
+			if purgeable(record.TaskInfo, cutoffs) {
+				expired = append(expired, record)
+			}
+		}
+		// 未过期的任务留在原处，下一批跳过它们
+		offset += int64(len(ids) - len(expired))
+		if len(expired) == 0 {
+			continue
+		}
+
+		if archive != nil {
+			if err := archive(expired); err != nil {
+				return purged, err
+			}
+		}
+
+		keys := list.stateKeys(list.key("deadlines"), list.key("overdue"), list.key("overdue_unnotified"), list.key("tasks"))
+		args := []any{list.prefix}
+		for _, record := range expired {
+			keys = append(keys, list.key("task", record.ID), list.key("logs", record.ID), list.key("checkpoints", record.ID), list.key("revisions", record.ID))
+			args = append(args, record.ID)
+		}
+		n, err := removeScript.Run(ctx, list.client, keys, args...).Int()
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+	}
+}
+
+// purgeCutoffs 由保留策略算出各状态的截止时间(毫秒)，""对应所有状态；latest为最晚的截止时间，0表示不清理
+func purgeCutoffs(policy common.PurgePolicy) (map[string]int64, int64) {
+	now := time.Now()
+	cutoffs := map[string]int64{}
+	latest := int64(0)
+
+	add := func(state string, maxAge time.Duration) {
+		if maxAge <= 0 {
+			return
+		}
+		cutoff := now.Add(-maxAge).UnixMilli()
+		cutoffs[state] = cutoff
+		if cutoff > latest {
+			latest = cutoff
+		}
+	}
+
+	add("", policy.MaxAge)
+	for state, maxAge := range policy.StateMaxAge {
+		add(state, maxAge)
+	}
+	return cutoffs, latest
+}
+
+// purgeable 任务是否已超过保留时间
+func purgeable(info common.TaskInfo, cutoffs map[string]int64) bool {
+	endedAt := info.CancelledAt
+	if endedAt == nil {
+		endedAt = info.FinishedAt
+	}
+	if endedAt == nil {
+		return false
+	}
+
+	ended := endedAt.UnixMilli()
+	for _, state := range []string{"", info.State} {
+		if cutoff, ok := cutoffs[state]; ok && ended < cutoff {
+			return true
+		}
+	}
+	return false
+}
+
+// records 读出任务的完整记录，不存在的任务跳过
+func (list *redisTaskList) records(ctx context.Context, ids []string) ([]common.TaskRecord, error) {
+	hashes, err := list.hashes(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]*redis.StringSliceCmd, len(ids))
+	checkpoints := make([]*redis.MapStringStringCmd, len(ids))
+	revisions := make([]*redis.StringSliceCmd, len(ids))
+	_, err = list.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			logs[i] = pipe.LRange(ctx, list.key("logs", id), 0, -1)
+			checkpoints[i] = pipe.HGetAll(ctx, list.key("checkpoints", id))
+			revisions[i] = pipe.LRange(ctx, list.key("revisions", id), 0, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]common.TaskRecord, 0, len(ids))
+	for i, id := range ids {
+		if len(hashes[i]) == 0 {
+			continue
+		}
+		lines, err := parseLogs(logs[i].Val())
+		if err != nil {
+			return nil, err
+		}
+		revs, err := parseRevisions(revisions[i].Val())
+		if err != nil {
+			return nil, err
+		}
+		record := common.TaskRecord{
+			TaskInfo:    taskInfo(id, hashes[i]),
+			Description: hashes[i]["description"],
+			Logs:        lines,
+			Revisions:   revs,
+		}
+		if len(checkpoints[i].Val()) > 0 {
+			record.Checkpoints = checkpoints[i].Val()
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
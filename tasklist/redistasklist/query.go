@@ -0,0 +1,302 @@
+package redistasklist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// List 列出任务，新的在前
+func (list *redisTaskList) List(ctx context.Context, opts common.ListOptions) ([]common.TaskInfo, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+
+	ids, err := list.matching(ctx, opts, int64(opts.Offset), int64(opts.Offset+opts.Limit-1))
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, err := list.hashes(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]common.TaskInfo, 0, len(ids))
+	for i, id := range ids {
+		if len(hashes[i]) > 0 {
+			infos = append(infos, taskInfo(id, hashes[i]))
+		}
+	}
+	return infos, nil
+}
+
+// CancelMatching 取消符合条件的未结束任务，运行中的任务会被中止，返回被取消的任务id
+func (list *redisTaskList) CancelMatching(ctx context.Context, opts common.ListOptions) ([]string, error) {
+	states := []string{common.StatePending, common.StateRunning}
+	if opts.State != "" {
+		states = []string{opts.State}
+	}
+
+	cancelled := []string{}
+	for _, state := range states {
+		opts.State = state
+		ids, err := list.matching(ctx, opts, 0, -1)
+		if err != nil {
+			return cancelled, err
+		}
+		for _, id := range ids {
+			now := time.Now()
+			err := cancelScript.Run(ctx, list.client, list.stateKeys(list.key("task", id)), list.prefix, id, formatTime(now), now.UnixMilli()).Err()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				return cancelled, err
+			}
+			cancelled = append(cancelled, id)
+		}
+	}
+
+	if len(cancelled) > 0 {
+		list.publish("abort")
+	}
+	return cancelled, nil
+}
+
+// Count 统计符合条件的任务数
+func (list *redisTaskList) Count(ctx context.Context, opts common.ListOptions) (int, error) {
+	key, store := list.filter(opts)
+	if store == nil {
+		n, err := list.client.ZCard(ctx, key).Result()
+		return int(n), err
+	}
+
+	tmp := list.tmpKey()
+	var n *redis.IntCmd
+	_, err := list.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		n = pipe.ZInterStore(ctx, tmp, store)
+		pipe.Del(ctx, tmp)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Val()), nil
+}
+
+// filter 按条件选出任务集合，只有状态条件时直接用key，否则store为各集合的交集，分数取任务id
+func (list *redisTaskList) filter(opts common.ListOptions) (string, *redis.ZStore) {
+	key := list.key("tasks")
+	if opts.State != "" {
+		key = list.key("state", opts.State)
+	}
+	if len(opts.Labels) == 0 && !opts.Overdue && opts.Namespace == "" {
+		return key, nil
+	}
+
+	store := &redis.ZStore{Keys: []string{key}, Weights: []float64{1}}
+	for k, v := range opts.Labels {
+		store.Keys = append(store.Keys, list.labelKey(k, v))
+		store.Weights = append(store.Weights, 0)
+	}
+	if opts.Overdue {
+		store.Keys = append(store.Keys, list.key("overdue"))
+		store.Weights = append(store.Weights, 0)
+	}
+	if opts.Namespace != "" {
+		store.Keys = append(store.Keys, list.key("ns", opts.Namespace))
+		store.Weights = append(store.Weights, 0)
+	}
+	return key, store
+}
+
+// tmpKey 存放交集的临时key
+func (list *redisTaskList) tmpKey() string {
+	token := make([]byte, 8)
+	rand.Read(token)
+	return list.key("tmp", hex.EncodeToString(token))
+}
+
+// matching 按条件筛选任务id，新的在前，start和stop同ZREVRANGE
+func (list *redisTaskList) matching(ctx context.Context, opts common.ListOptions, start, stop int64) ([]string, error) {
+	key, store := list.filter(opts)
+	if store == nil {
+		return list.client.ZRevRange(ctx, key, start, stop).Result()
+	}
+
+	tmp := list.tmpKey()
+
+	var ids *redis.StringSliceCmd
+	_, err := list.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZInterStore(ctx, tmp, store)
+		ids = pipe.ZRevRange(ctx, tmp, start, stop)
+		pipe.Del(ctx, tmp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids.Val(), nil
+}
+
+// labelKey 带有某个标签的任务集合
+func (list *redisTaskList) labelKey(k, v string) string {
+	return list.key("label", k+"="+v)
+}
+
+// Status 查看任务状态
+func (list *redisTaskList) Status(ctx context.Context, namespace string, id string) (common.TaskInfo, error) {
+	fields, err := list.client.HGetAll(ctx, list.key("task", id)).Result()
+	if err != nil {
+		return common.TaskInfo{}, err
+	}
+	if len(fields) == 0 || namespace != "" && namespaceOf(fields["namespace"]) != namespace {
+		return common.TaskInfo{}, common.ErrNotFound
+	}
+
+	info := taskInfo(id, fields)
+	checkpoints, err := list.client.HGetAll(ctx, list.key("checkpoints", id)).Result()
+	if err != nil {
+		return common.TaskInfo{}, err
+	}
+	if len(checkpoints) > 0 {
+		info.Checkpoints = checkpoints
+	}
+	return info, nil
+}
+
+// Rerun 重新执行已结束或已取消的任务，已过的截止时间一并清除，免得重跑后立即又逾期
+func (list *redisTaskList) Rerun(ctx context.Context, namespace string, id string) error {
+	if err := list.inNamespace(ctx, namespace, id); err != nil {
+		return err
+	}
+	at, err := list.parseScheduledAt("")
+	if err != nil {
+		return err
+	}
+
+	keys := list.stateKeys(list.key("task", id), list.key("checkpoints", id), list.key("overdue"), list.key("overdue_unnotified"), list.key("deadlines"))
+	res, err := rerunScript.Run(ctx, list.client, keys, list.prefix, id, formatTime(at), at.UnixMilli()).Text()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case "missing":
+		return common.ErrNotFound
+	case "conflict":
+		return fmt.Errorf("%w: task %s is pending or running", common.ErrConflict, id)
+	}
+
+	list.log(ctx, id, "rerun")
+	list.publish("new")
+	return nil
+}
+
+// Logs 查看任务日志
+func (list *redisTaskList) Logs(ctx context.Context, namespace string, id string) ([]common.LogLine, error) {
+	if err := list.inNamespace(ctx, namespace, id); err != nil {
+		return nil, err
+	}
+	raw, err := list.client.LRange(ctx, list.key("logs", id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseLogs(raw)
+}
+
+// hashes 批量读出任务的hash，不存在的任务为空
+func (list *redisTaskList) hashes(ctx context.Context, ids []string) ([]map[string]string, error) {
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	_, err := list.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, list.key("task", id))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	hashes := make([]map[string]string, len(ids))
+	for i, cmd := range cmds {
+		hashes[i] = cmd.Val()
+	}
+	return hashes, nil
+}
+
+// taskInfo 由任务的hash生成TaskInfo
+func taskInfo(id string, fields map[string]string) common.TaskInfo {
+	info := common.TaskInfo{
+		ID:          id,
+		Namespace:   namespaceOf(fields["namespace"]),
+		State:       stateOf(fields),
+		CreatedAt:   parseTime(fields["created_at"]),
+		ScheduledAt: parseTime(fields["scheduled_at"]),
+		PerformedAt: parseTime(fields["performed_at"]),
+		FinishedAt:  parseTime(fields["finished_at"]),
+		CancelledAt: parseTime(fields["cancelled_at"]),
+		Error:       fields["error"],
+		PerformedBy: fields["performed_by"],
+		Window:      fields["window"],
+		Deadline:    parseTime(fields["deadline"]),
+		OverdueAt:   parseTime(fields["overdue_at"]),
+		Concurrency: []common.ConcurrencyGroup{},
+	}
+
+	if revision, err := strconv.Atoi(fields["anchor_revision"]); err == nil {
+		info.AnchorRevision = &revision
+	}
+	info.Revision, _ = strconv.Atoi(fields["revision"])
+	if groups := fields["concurrency"]; groups != "" {
+		json.Unmarshal([]byte(groups), &info.Concurrency)
+		sort.Slice(info.Concurrency, func(i, j int) bool {
+			return info.Concurrency[i].Key < info.Concurrency[j].Key
+		})
+	}
+	if params := fields["params"]; params != "" {
+		json.Unmarshal([]byte(params), &info.Params)
+	}
+	if labels := fields["labels"]; labels != "" {
+		json.Unmarshal([]byte(labels), &info.Labels)
+	}
+	return info
+}
+
+// stateOf 由各时间字段推出任务状态，与restateLua一致
+func stateOf(fields map[string]string) string {
+	switch {
+	case fields["cancelled_at"] != "":
+		return common.StateCancelled
+	case fields["finished_at"] != "" && fields["error"] != "":
+		return common.StateError
+	case fields["finished_at"] != "":
+		return common.StateDone
+	case fields["performed_at"] != "":
+		return common.StateRunning
+	default:
+		return common.StatePending
+	}
+}
+
+// parseLogs 解析日志列表
+func parseLogs(raw []string) ([]common.LogLine, error) {
+	lines := make([]common.LogLine, 0, len(raw))
+	for _, str := range raw {
+		var line common.LogLine
+		if err := json.Unmarshal([]byte(str), &line); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
@@ -0,0 +1,321 @@
+package redistasklist
+
+import "github.com/redis/go-redis/v9"
+
+// 脚本用到的key尽量都在KEYS中给出；只有脚本执行中才知道的key（领取到的任务、并发组、标签、命名空间）
+// 由ARGV中的前缀拼出，部署在Redis Cluster上时前缀须带hash tag（如{clams}:）让它们落在同一个slot
+
+// claimPageSize 领取时每次从到期的任务中取出检查的个数
+const claimPageSize = 100
+
+// restateLua 由任务的时间字段推出状态，并同步各个索引：状态集合、调度队列、结束时间、租约和并发组
+// KEYS[1..5]: 各状态的集合, KEYS[6]: ended, KEYS[7]: scheduled, KEYS[8]: leases，见stateKeys
+const restateLua = `
+local states = {'pending', 'running', 'done', 'error', 'cancelled'}
+
+local function restate(p, id)
+	local f = redis.call('HMGET', p .. 'task:' .. id, 'performed_at', 'finished_at', 'cancelled_at', 'error', 'concurrency', 'ended_ts')
+	local state
+	if f[3] then
+		state = 'cancelled'
+	elseif f[2] and f[4] then
+		state = 'error'
+	elseif f[2] then
+		state = 'done'
+	elseif f[1] then
+		state = 'running'
+	else
+		state = 'pending'
+	end
+
+	for i, s in ipairs(states) do
+		if s == state then
+			redis.call('ZADD', KEYS[i], id, id)
+		else
+			redis.call('ZREM', KEYS[i], id)
+		end
+	end
+
+	if state == 'done' or state == 'error' or state == 'cancelled' then
+		redis.call('ZADD', KEYS[6], tonumber(f[6]), id)
+	else
+		redis.call('ZREM', KEYS[6], id)
+	end
+	if state ~= 'pending' then
+		redis.call('ZREM', KEYS[7], id)
+	end
+	if state ~= 'running' then
+		redis.call('ZREM', KEYS[8], id)
+	end
+
+	if f[5] then
+		for _, g in ipairs(cjson.decode(f[5])) do
+			if state == 'running' then
+				redis.call('SADD', p .. 'running:' .. g.key, id)
+			else
+				redis.call('SREM', p .. 'running:' .. g.key, id)
+			end
+		end
+	end
+	return state
+end
+`
+
+// stateKeys restateLua所需的KEYS，more接在后面
+func (list *redisTaskList) stateKeys(more ...string) []string {
+	return append([]string{
+		list.key("state", "pending"),
+		list.key("state", "running"),
+		list.key("state", "done"),
+		list.key("state", "error"),
+		list.key("state", "cancelled"),
+		list.key("ended"),
+		list.key("scheduled"),
+		list.key("leases"),
+	}, more...)
+}
+
+// claimScript 先放回租约已过期的任务，再领取一个已到时间的任务；并发组已满的跳过，逐页往后找，
+// 不会因为排在前面的任务都在等并发组而领不到后面的任务；领取时加上租约
+// KEYS: stateKeys, ARGV: prefix, now(ms), now, workerID, lease expiry(ms), page size
+var claimScript = redis.NewScript(restateLua + `
+local p = ARGV[1]
+
+-- 租约过期说明执行的服务器已经崩溃或失联，任务放回待执行并释放并发组
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[8], '-inf', ARGV[2], 'LIMIT', 0, 50)) do
+	redis.call('ZREM', KEYS[8], id)
+	local key = p .. 'task:' .. id
+	local f = redis.call('HMGET', key, 'performed_at', 'finished_at', 'cancelled_at', 'performed_by')
+	if f[1] and not f[2] and not f[3] then
+		redis.call('HDEL', key, 'performed_at', 'performed_by')
+		redis.call('HSET', key, 'scheduled_at', ARGV[3])
+		redis.call('ZADD', KEYS[7], ARGV[2], id)
+		redis.call('RPUSH', p .. 'logs:' .. id, cjson.encode({at = ARGV[3], message = 'requeued: lease of ' .. (f[4] or 'worker') .. ' expired'}))
+		restate(p, id)
+	end
+end
+
+local size = tonumber(ARGV[6])
+local full = {}
+local offset = 0
+while true do
+	local ids = redis.call('ZRANGEBYSCORE', KEYS[7], '-inf', ARGV[2], 'LIMIT', offset, size)
+	for _, id in ipairs(ids) do
+		local key = p .. 'task:' .. id
+		local conc = redis.call('HGET', key, 'concurrency')
+		local free = true
+		if conc then
+			for _, g in ipairs(cjson.decode(conc)) do
+				if full[g.key] == nil then
+					full[g.key] = redis.call('SCARD', p .. 'running:' .. g.key) >= g.limit
+				end
+				if full[g.key] then
+					free = false
+					break
+				end
+			end
+		end
+		if free then
+			redis.call('HSET', key, 'performed_at', ARGV[3], 'performed_by', ARGV[4])
+			redis.call('ZADD', KEYS[8], ARGV[5], id)
+			restate(p, id)
+			local task = redis.call('HMGET', key, 'description', 'params', 'window', 'namespace')
+			return {id, task[1] or '', task[2] or '', task[3] or '', conc or '', task[4] or 'default'}
+		end
+	end
+	if #ids < size then
+		return false
+	end
+	offset = offset + size
+end
+`)
+
+// finishScript 标记任务结束，err为空表示成功；任务已不归该worker时不做改变
+// KEYS: stateKeys, task, ARGV: prefix, id, now, now(ms), err, workerID
+var finishScript = redis.NewScript(restateLua + `
+local p, id, key = ARGV[1], ARGV[2], KEYS[9]
+if redis.call('HGET', key, 'performed_by') ~= ARGV[6] then
+	return false
+end
+redis.call('HSET', key, 'finished_at', ARGV[3], 'ended_ts', ARGV[4])
+if ARGV[5] ~= '' then
+	redis.call('HSET', key, 'error', ARGV[5])
+else
+	redis.call('HDEL', key, 'error')
+end
+return restate(p, id)
+`)
+
+// cancelScript 取消未结束的任务
+// KEYS: stateKeys, task, ARGV: prefix, id, now, now(ms)
+var cancelScript = redis.NewScript(restateLua + `
+local p, id, key = ARGV[1], ARGV[2], KEYS[9]
+local f = redis.call('HMGET', key, 'created_at', 'finished_at', 'cancelled_at')
+if not f[1] or f[2] or f[3] then
+	return false
+end
+redis.call('HSET', key, 'cancelled_at', ARGV[3], 'ended_ts', ARGV[4])
+return restate(p, id)
+`)
+
+// rerunScript 重新执行已结束或已取消的任务，成功结束的任务清除检查点，已过的截止时间一并清除
+// KEYS: stateKeys, task, checkpoints, overdue, overdue_unnotified, deadlines, ARGV: prefix, id, scheduled_at, scheduled(ms)
+var rerunScript = redis.NewScript(restateLua + `
+local p, id, key = ARGV[1], ARGV[2], KEYS[9]
+local f = redis.call('HMGET', key, 'created_at', 'finished_at', 'cancelled_at', 'error')
+if not f[1] then
+	return 'missing'
+end
+if not f[2] and not f[3] then
+	return 'conflict'
+end
+if f[2] and not f[3] and not f[4] then
+	redis.call('DEL', KEYS[10])
+end
+redis.call('HDEL', key, 'performed_at', 'performed_by', 'finished_at', 'cancelled_at', 'error', 'ended_ts', 'overdue_at')
+redis.call('HSET', key, 'scheduled_at', ARGV[3])
+redis.call('ZADD', KEYS[7], ARGV[4], id)
+redis.call('ZREM', KEYS[11], id)
+redis.call('ZREM', KEYS[12], id)
+local deadline = redis.call('HGET', key, 'deadline_ts')
+if deadline and tonumber(deadline) <= tonumber(ARGV[4]) then
+	redis.call('HDEL', key, 'deadline', 'deadline_ts')
+	redis.call('ZREM', KEYS[13], id)
+elseif deadline then
+	redis.call('ZADD', KEYS[13], deadline, id)
+end
+return restate(p, id)
+`)
+
+// deferScript 放回运行中的任务并推迟执行，已结束、已取消或已不归该worker的任务不变
+// KEYS: stateKeys, task, ARGV: prefix, id, scheduled_at, scheduled(ms), workerID
+var deferScript = redis.NewScript(restateLua + `
+local p, id, key = ARGV[1], ARGV[2], KEYS[9]
+local f = redis.call('HMGET', key, 'performed_at', 'finished_at', 'cancelled_at', 'performed_by')
+if not f[1] or f[2] or f[3] or f[4] ~= ARGV[5] then
+	return false
+end
+redis.call('HDEL', key, 'performed_at', 'performed_by')
+redis.call('HSET', key, 'scheduled_at', ARGV[3])
+redis.call('ZADD', KEYS[7], ARGV[4], id)
+return restate(p, id)
+`)
+
+// renewLeaseScript 延长仍在租约中的任务的租约，返回已失去租约的id
+// KEYS: leases, ARGV: lease expiry(ms), id...
+var renewLeaseScript = redis.NewScript(`
+local lost = {}
+for i = 2, #ARGV do
+	if redis.call('ZSCORE', KEYS[1], ARGV[i]) then
+		redis.call('ZADD', KEYS[1], ARGV[1], ARGV[i])
+	else
+		table.insert(lost, ARGV[i])
+	end
+end
+return lost
+`)
+
+// overdueScript 检查已过截止时间的任务，未结束的标为逾期并记入待通知，返回{检查的个数, 标记的id...}
+// KEYS: deadlines, overdue, overdue_unnotified, ARGV: prefix, now(ms), now, limit
+var overdueScript = redis.NewScript(`
+local p = ARGV[1]
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'LIMIT', 0, tonumber(ARGV[4]))
+local res = {#ids}
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	local key = p .. 'task:' .. id
+	local f = redis.call('HMGET', key, 'created_at', 'finished_at', 'cancelled_at', 'overdue_at')
+	if f[1] and not f[2] and not f[3] and not f[4] then
+		redis.call('HSET', key, 'overdue_at', ARGV[3])
+		redis.call('ZADD', KEYS[2], id, id)
+		redis.call('ZADD', KEYS[3], id, id)
+		table.insert(res, id)
+	end
+end
+return res
+`)
+
+// removeScript 删除已结束的任务及其日志、检查点和修改前的版本
+// KEYS: stateKeys, deadlines, overdue, overdue_unnotified, tasks, 每个任务的task、logs、checkpoints、revisions
+// ARGV: prefix, id...
+var removeScript = redis.NewScript(`
+local p = ARGV[1]
+local removed = 0
+for i = 2, #ARGV do
+	local id = ARGV[i]
+	local base = 12 + (i - 2) * 4
+	local key = KEYS[base + 1]
+	for k = 1, 12 do
+		redis.call('ZREM', KEYS[k], id)
+	end
+	local ns = redis.call('HGET', key, 'namespace') or 'default'
+	redis.call('ZREM', p .. 'ns:' .. ns, id)
+	local labels = redis.call('HGET', key, 'labels')
+	if labels then
+		for k, v in pairs(cjson.decode(labels)) do
+			redis.call('ZREM', p .. 'label:' .. k .. '=' .. v, id)
+		end
+	end
+	removed = removed + redis.call('DEL', key)
+	redis.call('DEL', KEYS[base + 2], KEYS[base + 3], KEYS[base + 4])
+end
+return removed
+`)
+
+// anchorScript 为锚点追加一个版本，删除不存在的锚点时返回false
+// KEYS: 锚点, 命名空间中所有锚点名, anchor_seq, ARGV: name, content, deleted(0/1), created_at, channel
+var anchorScript = redis.NewScript(`
+local name, key = ARGV[1], KEYS[1]
+local deleted = ARGV[3] == '1'
+local last = redis.call('LINDEX', key, -1)
+local version = 0
+if last then
+	local decoded = cjson.decode(last)
+	version = decoded.version
+	if deleted and decoded.deleted then
+		return false
+	end
+elseif deleted then
+	return false
+end
+
+local anchor = cjson.encode({
+	name = name,
+	version = version + 1,
+	revision = redis.call('INCR', KEYS[3]),
+	content = ARGV[2],
+	deleted = deleted,
+	created_at = ARGV[4],
+})
+redis.call('RPUSH', key, anchor)
+redis.call('SADD', KEYS[2], name)
+redis.call('PUBLISH', ARGV[5], 'anchors')
+return anchor
+`)
+
+// backfillNamespaceScript 有命名空间之前的任务都归入默认的命名空间，只执行一次
+// KEYS: ns_backfilled, ns:default, tasks
+var backfillNamespaceScript = redis.NewScript(`
+if redis.call('SETNX', KEYS[1], '1') == 1 then
+	redis.call('ZUNIONSTORE', KEYS[2], 2, KEYS[2], KEYS[3])
+end
+return true
+`)
+
+// unlockScript 释放自己持有的锁
+// KEYS: lock, ARGV: token
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// renewScript 延长自己持有的锁
+// KEYS: lock, ARGV: token, ttl ms
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
@@ -0,0 +1,122 @@
+package redistasklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// redisTask 代表一个任务
+type redisTask struct {
+	list        *redisTaskList
+	id          string
+	workerID    string
+	namespace   string
+	description string
+	params      map[string]string
+	window      string
+	concurrency []common.ConcurrencyGroup
+	aborted     chan struct{}
+}
+
+// ID 返回任务id
+func (t *redisTask) ID() string {
+	return t.id
+}
+
+// Namespace 返回任务所在的命名空间
+func (t *redisTask) Namespace() string {
+	return t.namespace
+}
+
+// Description 返回任务脚本
+func (t *redisTask) Description() string {
+	return t.description
+}
+
+// Params 返回任务参数
+func (t *redisTask) Params() map[string]string {
+	return t.params
+}
+
+// Window 返回任务允许执行的时间段
+func (t *redisTask) Window() string {
+	return t.window
+}
+
+// Concurrency 返回任务所属的并发组
+func (t *redisTask) Concurrency() []common.ConcurrencyGroup {
+	return t.concurrency
+}
+
+// Aborted 监听中止
+func (t *redisTask) Aborted() chan struct{} {
+	return t.aborted
+}
+
+// Done 标记任务结束
+func (t *redisTask) Done(ctx context.Context) error {
+	return t.finish(ctx, "", "done")
+}
+
+// Error 标记任务错误
+func (t *redisTask) Error(ctx context.Context, err error) error {
+	return t.finish(ctx, err.Error(), "error: "+err.Error())
+}
+
+// finish 记录结束时间，释放并发组并通知等待中的任务；
+// 租约已被收回的任务可能已由其他worker领取，这时不做改变，也不记日志
+func (t *redisTask) finish(ctx context.Context, message string, logLine string) error {
+	t.list.forget(t.id)
+
+	now := time.Now()
+	err := finishScript.Run(ctx, t.list.client, t.list.stateKeys(t.list.key("task", t.id)), t.list.prefix, t.id, formatTime(now), now.UnixMilli(), message, t.workerID).Err()
+	if errors.Is(err, redis.Nil) {
+		t.list.debugf("task %s is no longer claimed by %s, %s ignored", t.id, t.workerID, logLine)
+		return nil
+	}
+	t.list.publish("new")
+	t.list.log(ctx, t.id, logLine)
+	return err
+}
+
+// Defer 放回未开始的任务并推迟到until，释放所占的并发组；任务已被取消或已不归该worker时不再放回
+func (t *redisTask) Defer(ctx context.Context, until time.Time, reason string) error {
+	t.list.forget(t.id)
+
+	err := deferScript.Run(ctx, t.list.client, t.list.stateKeys(t.list.key("task", t.id)), t.list.prefix, t.id, formatTime(until), until.UnixMilli(), t.workerID).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	t.list.publish("new")
+	t.list.log(ctx, t.id, fmt.Sprintf("deferred until %s: %s", until.In(t.list.location).Format("2006-01-02 15:04:05"), reason))
+	return err
+}
+
+// UseAnchors 记录任务所用锚点的版本
+func (t *redisTask) UseAnchors(ctx context.Context, revision int) error {
+	return t.list.client.HSet(ctx, t.list.key("task", t.id), "anchor_revision", revision).Err()
+}
+
+// Log 记录任务日志
+func (t *redisTask) Log(ctx context.Context, message string) error {
+	return t.list.log(ctx, t.id, message)
+}
+
+// Checkpoint 读出任务的检查点
+func (t *redisTask) Checkpoint(ctx context.Context, key string) (string, error) {
+	value, err := t.list.client.HGet(ctx, t.list.key("checkpoints", t.id), key).Result()
+	if errors.Is(err, redis.Nil) || (err == nil && value == "") {
+		return "", common.ErrNotFound
+	}
+	return value, err
+}
+
+// SaveCheckpoint 保存任务的检查点
+func (t *redisTask) SaveCheckpoint(ctx context.Context, key string, value string) error {
+	return t.list.client.HSet(ctx, t.list.key("checkpoints", t.id), key, value).Err()
+}
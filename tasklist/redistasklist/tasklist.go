@@ -0,0 +1,555 @@
+package redistasklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// defaultPrefix 所有key的默认前缀
+const defaultPrefix = "clams:"
+
+// leaseTTL 运行中的任务的租约，每隔三分之一续期一次；服务器崩溃后其任务最迟这么久后放回待执行
+var leaseTTL = 30 * time.Second
+
+// Init 初始化redisTaskList
+func Init(ctx context.Context, cfg map[string]any) (*redisTaskList, error) {
+	url, _ := cfg["url"].(string)
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, _ := cfg["prefix"].(string)
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		return nil, err
+	}
+
+	list := &redisTaskList{
+		ctx:          ctx,
+		client:       redis.NewClient(opts),
+		prefix:       prefix,
+		location:     loc,
+		wake:         make(chan struct{}),
+		running:      make(map[string]*redisTask),
+		abortSignal:  make(chan struct{}, 1),
+		anchorSignal: make(chan struct{}, 1),
+	}
+	if err := list.client.Ping(ctx).Err(); err != nil {
+		list.client.Close()
+		return nil, err
+	}
+	if err := backfillNamespaceScript.Run(ctx, list.client, []string{list.key("ns_backfilled"), list.key("ns", "default"), list.key("tasks")}).Err(); err != nil {
+		list.client.Close()
+		return nil, err
+	}
+
+	go list.subscribe()
+	go list.listenChanForAbort()
+	go list.renewLeases()
+
+	return list, nil
+}
+
+// redisTaskList 可从redis读写任务
+//
+// 任务存为hash，按状态分别放在有序集合中；待执行的任务按计划时间放在scheduled中，
+// 领取、结束、取消等状态变化都由lua脚本原子完成，多个服务器可共用同一个redis
+type redisTaskList struct {
+	ctx          context.Context
+	client       *redis.Client
+	prefix       string
+	location     *time.Location
+	abortSignal  chan struct{}
+	anchorSignal chan struct{}
+	listening    atomic.Bool
+
+	wakeLock sync.Mutex
+	wake     chan struct{}
+
+	runningLock sync.Mutex
+	running     map[string]*redisTask
+}
+
+// debugf 打印调试信息
+func (list *redisTaskList) debugf(str string, v ...any) {
+	log.Debug().Str("mod", "tasklist").Msgf(str, v...)
+}
+
+// errorf 打印错误信息
+func (list *redisTaskList) errorf(str string, v ...any) {
+	log.Error().Str("mod", "tasklist").Msgf(str, v...)
+}
+
+// key 拼出带前缀的key
+func (list *redisTaskList) key(parts ...string) string {
+	return list.prefix + strings.Join(parts, ":")
+}
+
+// subscribe 订阅任务变化，连接断开后由客户端重连并重新订阅
+func (list *redisTaskList) subscribe() {
+	pubsub := list.client.Subscribe(list.ctx, list.key("channel"))
+	defer pubsub.Close()
+
+	for {
+		msg, err := pubsub.Receive(list.ctx)
+		if err != nil {
+			list.listening.Store(false)
+			if list.ctx.Err() != nil {
+				return
+			}
+			list.errorf("subscribe: %v", err)
+
+			select {
+			case <-list.ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind != "subscribe" {
+				continue
+			}
+			list.listening.Store(true)
+
+			// 断开期间可能错过了通知
+			list.wakeAll()
+			for _, ch := range []chan struct{}{list.abortSignal, list.anchorSignal} {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		case *redis.Message:
+			switch msg.Payload {
+			case "abort":
+				select {
+				case list.abortSignal <- struct{}{}:
+				default:
+				}
+			case "anchors":
+				select {
+				case list.anchorSignal <- struct{}{}:
+				default:
+				}
+			default:
+				list.wakeAll()
+			}
+		}
+	}
+}
+
+// publish 通知其他服务器
+func (list *redisTaskList) publish(payload string) {
+	list.client.Publish(context.Background(), list.key("channel"), payload)
+}
+
+// waiting 返回下次有任务变化时会关闭的通道
+func (list *redisTaskList) waiting() chan struct{} {
+	list.wakeLock.Lock()
+	defer list.wakeLock.Unlock()
+	return list.wake
+}
+
+// wakeAll 唤醒所有等待任务的worker
+func (list *redisTaskList) wakeAll() {
+	list.wakeLock.Lock()
+	defer list.wakeLock.Unlock()
+	close(list.wake)
+	list.wake = make(chan struct{})
+}
+
+// Ping 检查与redis的连接，以及是否正在订阅任务变化
+func (list *redisTaskList) Ping(ctx context.Context) error {
+	if err := list.client.Ping(ctx).Err(); err != nil {
+		return err
+	}
+	if !list.listening.Load() {
+		return errors.New("not subscribed to " + list.key("channel"))
+	}
+	return nil
+}
+
+// listenChanForAbort 监听任务中止
+func (list *redisTaskList) listenChanForAbort() {
+	for {
+		select {
+		case <-list.ctx.Done():
+			return
+		case <-list.abortSignal:
+			if err := list.abortTasks(); err != nil {
+				list.errorf("loop abortSignal: %v", err)
+			}
+		}
+	}
+}
+
+// abortTasks 中止运行中已被取消的任务
+func (list *redisTaskList) abortTasks() error {
+	list.runningLock.Lock()
+	ids := make([]string, 0, len(list.running))
+	for id := range list.running {
+		ids = append(ids, id)
+	}
+	list.runningLock.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	cmds := make([]*redis.BoolCmd, len(ids))
+	_, err := list.client.Pipelined(list.ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HExists(list.ctx, list.key("task", id), "cancelled_at")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if cmds[i].Val() {
+			if t := list.forget(id); t != nil {
+				close(t.aborted)
+			}
+		}
+	}
+	return nil
+}
+
+// renewLeases 定期延长本服务器上运行中的任务的租约，租约已被收回的任务中止执行
+func (list *redisTaskList) renewLeases() {
+	ticker := time.NewTicker(leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-list.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		list.runningLock.Lock()
+		args := []any{time.Now().Add(leaseTTL).UnixMilli()}
+		for id := range list.running {
+			args = append(args, id)
+		}
+		list.runningLock.Unlock()
+		if len(args) == 1 {
+			continue
+		}
+
+		lost, err := renewLeaseScript.Run(list.ctx, list.client, []string{list.key("leases")}, args...).StringSlice()
+		if err != nil {
+			list.errorf("renew leases: %v", err)
+			continue
+		}
+		for _, id := range lost {
+			if t := list.forget(id); t != nil {
+				list.errorf("lease of task %s expired, aborting", id)
+				close(t.aborted)
+			}
+		}
+	}
+}
+
+// forget 不再跟踪任务
+func (list *redisTaskList) forget(id string) *redisTask {
+	list.runningLock.Lock()
+	defer list.runningLock.Unlock()
+
+	t := list.running[id]
+	delete(list.running, id)
+	return t
+}
+
+// Read 返回一个任务，没有可执行的任务时等到有新任务或下一个计划时间
+func (list *redisTaskList) Read(ctx context.Context, workerID string) (common.Task, error) {
+	for {
+		wake := list.waiting()
+
+		t, err := list.claim(ctx, workerID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// 避免redis不可用时空转
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+			}
+			return nil, err
+		}
+		if t != nil {
+			return t, nil
+		}
+
+		timer := time.NewTimer(list.untilNext(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// claim 原子地领取一个到期的任务，没有时返回nil
+func (list *redisTaskList) claim(ctx context.Context, workerID string) (*redisTask, error) {
+	now := time.Now()
+	res, err := claimScript.Run(ctx, list.client, list.stateKeys(), list.prefix, now.UnixMilli(), formatTime(now), workerID, now.Add(leaseTTL).UnixMilli(), claimPageSize).StringSlice()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := &redisTask{
+		list:        list,
+		id:          res[0],
+		workerID:    workerID,
+		namespace:   res[5],
+		description: res[1],
+		aborted:     make(chan struct{}),
+	}
+	if res[2] != "" {
+		if err := json.Unmarshal([]byte(res[2]), &t.params); err != nil {
+			return nil, err
+		}
+	}
+	t.window = res[3]
+	if res[4] != "" {
+		if err := json.Unmarshal([]byte(res[4]), &t.concurrency); err != nil {
+			return nil, err
+		}
+	}
+
+	list.runningLock.Lock()
+	list.running[t.id] = t
+	list.runningLock.Unlock()
+	return t, nil
+}
+
+// untilNext 距离下一个未到期任务的时间，最多等一分钟；并发组满员的任务要等其他任务结束时的通知
+func (list *redisTaskList) untilNext(ctx context.Context) time.Duration {
+	wait := time.Minute
+	next, err := list.client.ZRangeByScoreWithScores(ctx, list.key("scheduled"), &redis.ZRangeBy{
+		Min:   "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max:   "+inf",
+		Count: 1,
+	}).Result()
+	if err != nil || len(next) == 0 {
+		return wait
+	}
+
+	if d := time.Until(time.UnixMilli(int64(next[0].Score))); d < wait {
+		wait = d
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// Close 断开redis任务列表
+func (list *redisTaskList) Close(ctx context.Context) error {
+	return list.client.Close()
+}
+
+// Peek 查看任务
+func (list *redisTaskList) Peek(ctx context.Context, namespace string, id string) (common.RawTask, error) {
+	fields, err := list.client.HMGet(ctx, list.key("task", id), "description", "namespace").Result()
+	if err != nil {
+		return common.RawTask{}, err
+	}
+	desc, ok := fields[0].(string)
+	if !ok {
+		return common.RawTask{}, common.ErrNotFound
+	}
+	ns := namespaceOf(fields[1])
+	if namespace != "" && ns != namespace {
+		return common.RawTask{}, common.ErrNotFound
+	}
+	return common.RawTask{Namespace: ns, Description: desc}, nil
+}
+
+// Delete 取消任务，运行中的任务会被中止
+func (list *redisTaskList) Delete(ctx context.Context, namespace string, id string) error {
+	if _, err := strconv.Atoi(id); err != nil {
+		return err
+	}
+	if err := list.inNamespace(ctx, namespace, id); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err := cancelScript.Run(ctx, list.client, list.stateKeys(list.key("task", id)), list.prefix, id, formatTime(now), now.UnixMilli()).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	list.publish("abort")
+	return nil
+}
+
+// Write 往redis写入一个任务
+func (list *redisTaskList) Write(ctx context.Context, rawTask common.RawTask) (string, error) {
+	ids, err := list.WriteBatch(ctx, []common.RawTask{rawTask})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// WriteBatch 在一个事务中写入多个任务，返回的id与rawTasks一一对应
+func (list *redisTaskList) WriteBatch(ctx context.Context, rawTasks []common.RawTask) ([]string, error) {
+	if len(rawTasks) == 0 {
+		return []string{}, nil
+	}
+
+	scheduled := make([]time.Time, len(rawTasks))
+	deadlines := make([]*time.Time, len(rawTasks))
+	for i, rawTask := range rawTasks {
+		at, err := list.parseScheduledAt(rawTask.ScheduledAt)
+		if err != nil {
+			return nil, err
+		}
+		scheduled[i] = at
+		if deadlines[i], err = list.parseDeadline(rawTask.Deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	last, err := list.client.IncrBy(ctx, list.key("seq"), int64(len(rawTasks))).Result()
+	if err != nil {
+		return nil, err
+	}
+	first := int(last) - len(rawTasks) + 1
+
+	ids := make([]string, 0, len(rawTasks))
+	now := formatTime(time.Now())
+	_, err = list.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, rawTask := range rawTasks {
+			id := strconv.Itoa(first + i)
+			ids = append(ids, id)
+
+			ns := namespaceOr(rawTask.Namespace)
+			fields := []any{"namespace", ns, "description", rawTask.Description, "created_at", now, "scheduled_at", formatTime(scheduled[i])}
+			if len(rawTask.Concurrency) > 0 {
+				groups, err := json.Marshal(rawTask.Concurrency)
+				if err != nil {
+					return err
+				}
+				fields = append(fields, "concurrency", string(groups))
+			}
+			if len(rawTask.Params) > 0 {
+				params, err := json.Marshal(rawTask.Params)
+				if err != nil {
+					return err
+				}
+				fields = append(fields, "params", string(params))
+			}
+			if len(rawTask.Labels) > 0 {
+				labels, err := json.Marshal(rawTask.Labels)
+				if err != nil {
+					return err
+				}
+				fields = append(fields, "labels", string(labels))
+			}
+			if rawTask.Window != "" {
+				fields = append(fields, "window", rawTask.Window)
+			}
+			if deadline := deadlines[i]; deadline != nil {
+				fields = append(fields, "deadline", formatTime(*deadline), "deadline_ts", deadline.UnixMilli())
+				pipe.ZAdd(ctx, list.key("deadlines"), redis.Z{Score: float64(deadline.UnixMilli()), Member: id})
+			}
+
+			score := redis.Z{Score: float64(first + i), Member: id}
+			for k, v := range rawTask.Labels {
+				pipe.ZAdd(ctx, list.labelKey(k, v), score)
+			}
+			pipe.HSet(ctx, list.key("task", id), fields...)
+			pipe.ZAdd(ctx, list.key("tasks"), score)
+			pipe.ZAdd(ctx, list.key("ns", ns), score)
+			pipe.ZAdd(ctx, list.key("state", common.StatePending), score)
+			pipe.ZAdd(ctx, list.key("scheduled"), redis.Z{Score: float64(scheduled[i].UnixMilli()), Member: id})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list.publish("new")
+	return ids, nil
+}
+
+// parseScheduledAt 解析计划时间，与pg一样按本地时区理解，为空时为当前时间
+func (list *redisTaskList) parseScheduledAt(str string) (time.Time, error) {
+	if str == "" {
+		return time.Now(), nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", str, list.location)
+}
+
+// parseDeadline 解析截止时间，格式同计划时间，为空时没有截止时间
+func (list *redisTaskList) parseDeadline(str string) (*time.Time, error) {
+	if str == "" {
+		return nil, nil
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04:05", str, list.location)
+	if err != nil {
+		return nil, err
+	}
+	return &at, nil
+}
+
+// log 记录任务日志
+func (list *redisTaskList) log(ctx context.Context, id string, message string) error {
+	line, err := json.Marshal(common.LogLine{At: time.Now(), Message: message})
+	if err != nil {
+		return err
+	}
+	return list.client.RPush(ctx, list.key("logs", id), line).Err()
+}
+
+// formatTime 存入redis的时间格式
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseTime 读出redis中的时间，没有时为nil
+func parseTime(str string) *time.Time {
+	if str == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,785 @@
+package redistasklist
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// newTestList 连接CLAMS_TEST_REDIS_URL指定的redis，未指定时使用内存中的miniredis
+func newTestList(t *testing.T) *redisTaskList {
+	t.Helper()
+
+	url := os.Getenv("CLAMS_TEST_REDIS_URL")
+	if url == "" {
+		url = "redis://" + miniredis.RunT(t).Addr()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	prefix := "clams-test:" + time.Now().Format("150405.000000") + ":"
+	list, err := Init(ctx, map[string]any{"url": url, "prefix": prefix})
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		keys, _ := list.client.Keys(context.Background(), prefix+"*").Result()
+		if len(keys) > 0 {
+			list.client.Del(context.Background(), keys...)
+		}
+		cancel()
+		list.Close(context.Background())
+	})
+	return list
+}
+
+// readWithin 在timeout内领取一个任务
+func readWithin(t *testing.T, list *redisTaskList, timeout time.Duration) common.Task {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	task, err := list.Read(ctx, "worker")
+	if err != nil {
+		return nil
+	}
+	return task
+}
+
+func TestRedisTaskLifecycle(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	id, err := list.Write(ctx, common.RawTask{Description: "input: {}", Params: map[string]string{"table": "t1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := readWithin(t, list, time.Second)
+	if task == nil || task.ID() != id || task.Description() != "input: {}" || task.Params()["table"] != "t1" {
+		t.Fatalf("unexpected task %v", task)
+	}
+	if readWithin(t, list, 100*time.Millisecond) != nil {
+		t.Fatal("a task should be claimed only once")
+	}
+
+	if err := task.Error(ctx, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := list.Status(ctx, "", id)
+	if err != nil || info.State != common.StateError || info.Error != "boom" || info.PerformedBy != "worker" || info.Params["table"] != "t1" {
+		t.Fatalf("unexpected status %+v %v", info, err)
+	}
+
+	if err := list.Rerun(ctx, "", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Rerun(ctx, "", id); !errors.Is(err, common.ErrConflict) {
+		t.Fatalf("rerun of a pending task should conflict, got %v", err)
+	}
+	if err := list.Rerun(ctx, "", "404"); !errors.Is(err, common.ErrNotFound) {
+		t.Fatalf("rerun of a missing task should be not found, got %v", err)
+	}
+
+	task = readWithin(t, list, time.Second)
+	if task == nil {
+		t.Fatal("rerun task should be claimable")
+	}
+	task.Done(ctx)
+
+	infos, err := list.List(ctx, common.ListOptions{State: common.StateDone})
+	if err != nil || len(infos) != 1 || infos[0].ID != id {
+		t.Fatalf("unexpected list %+v %v", infos, err)
+	}
+
+	logs, err := list.Logs(ctx, "", id)
+	if err != nil || len(logs) != 3 || logs[0].Message != "error: boom" || logs[2].Message != "done" {
+		t.Fatalf("unexpected logs %+v %v", logs, err)
+	}
+}
+
+func TestRedisScheduledAndConcurrency(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	later := time.Now().Add(time.Hour).In(list.location).Format("2006-01-02 15:04:05")
+	group := []common.ConcurrencyGroup{{Key: "db", Limit: 1}}
+	ids, err := list.WriteBatch(ctx, []common.RawTask{
+		{Description: "later", ScheduledAt: later},
+		{Description: "first", Concurrency: group},
+		{Description: "second", Concurrency: group},
+	})
+	if err != nil || len(ids) != 3 {
+		t.Fatalf("unexpected ids %v %v", ids, err)
+	}
+
+	first := readWithin(t, list, time.Second)
+	if first == nil || first.Description() != "first" {
+		t.Fatalf("unexpected task %v", first)
+	}
+	if task := readWithin(t, list, 100*time.Millisecond); task != nil {
+		t.Fatalf("task %s should wait for the concurrency group or its schedule", task.Description())
+	}
+
+	waiting := make(chan common.Task)
+	go func() {
+		waiting <- readWithin(t, list, 2*time.Second)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	first.Done(ctx)
+
+	second := <-waiting
+	if second == nil || second.Description() != "second" {
+		t.Fatalf("finishing a task should wake up the waiting one, got %v", second)
+	}
+
+	// 失败的任务同样释放名额
+	third, err := list.Write(ctx, common.RawTask{Description: "third", Concurrency: group})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task := readWithin(t, list, 100*time.Millisecond); task != nil {
+		t.Fatalf("task %s should wait for the concurrency group", task.Description())
+	}
+	second.Error(ctx, errors.New("boom"))
+	if task := readWithin(t, list, time.Second); task == nil || task.ID() != third {
+		t.Fatalf("failing a task should release its slot, got %v", task)
+	}
+}
+
+func TestRedisCancelAbortsRunningTask(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	id, _ := list.Write(ctx, common.RawTask{Description: "input: {}"})
+	task := readWithin(t, list, time.Second)
+	if task == nil {
+		t.Fatal("task should be claimable")
+	}
+
+	if err := list.Delete(ctx, "", id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-task.Aborted():
+	case <-time.After(2 * time.Second):
+		t.Fatal("running task should be aborted")
+	}
+
+	info, _ := list.Status(ctx, "", id)
+	if info.State != common.StateCancelled {
+		t.Fatalf("unexpected state %s", info.State)
+	}
+}
+
+func TestRedisAnchors(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	if err := list.DeleteAnchor(ctx, "", "db"); !errors.Is(err, common.ErrNotFound) {
+		t.Fatalf("deleting a missing anchor should be not found, got %v", err)
+	}
+
+	list.PutAnchor(ctx, "", "db", "a")
+	second, err := list.PutAnchor(ctx, "", "db", "b")
+	if err != nil || second.Version != 2 || second.Revision != 2 {
+		t.Fatalf("unexpected anchor %+v %v", second, err)
+	}
+	list.PutAnchor(ctx, "", "mq", "c")
+	list.DeleteAnchor(ctx, "", "mq")
+
+	set, err := list.ListAnchors(ctx, "")
+	if err != nil || set.Revision != 4 || len(set.Anchors) != 1 || set.Anchors[0].Content != "b" {
+		t.Fatalf("unexpected anchors %+v %v", set, err)
+	}
+
+	history, err := list.AnchorHistory(ctx, "", "db")
+	if err != nil || len(history) != 2 || history[0].Version != 2 {
+		t.Fatalf("unexpected history %+v %v", history, err)
+	}
+}
+
+func TestRedisPurge(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	ids, _ := list.WriteBatch(ctx, []common.RawTask{{Description: "old"}, {Description: "pending"}})
+	list.Delete(ctx, "", ids[0])
+	time.Sleep(10 * time.Millisecond)
+
+	var archived []common.TaskRecord
+	purged, err := list.Purge(ctx, common.PurgePolicy{MaxAge: time.Millisecond}, func(records []common.TaskRecord) error {
+		archived = append(archived, records...)
+		return nil
+	})
+	if err != nil || purged != 1 {
+		t.Fatalf("unexpected purge %d %v", purged, err)
+	}
+	if len(archived) != 1 || archived[0].ID != ids[0] || archived[0].Description != "old" {
+		t.Fatalf("unexpected archive %+v", archived)
+	}
+
+	if _, err := list.Status(ctx, "", ids[0]); !errors.Is(err, common.ErrNotFound) {
+		t.Fatalf("purged task should be gone, got %v", err)
+	}
+	if _, err := list.Status(ctx, "", ids[1]); err != nil {
+		t.Fatalf("pending task should be kept, got %v", err)
+	}
+}
+
+func TestRedisAuditLog(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	for _, entry := range []common.AuditEntry{
+		{At: start.Add(-time.Hour), Caller: "ci", Action: "task.create", Target: "1"},
+		{At: start, Caller: "ops", Action: "task.cancel", Target: "1"},
+		{At: start, Caller: "ci", Action: "task.create", Target: "2"},
+	} {
+		if err := list.Audit(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := list.AuditLog(ctx, common.AuditOptions{Caller: "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Target != "2" || entries[1].Target != "1" {
+		t.Fatalf("entries of caller should be listed newest first, got %+v", entries)
+	}
+
+	entries, _ = list.AuditLog(ctx, common.AuditOptions{Since: start.Add(-time.Minute)})
+	if len(entries) != 2 {
+		t.Errorf("entries before since should be left out, got %+v", entries)
+	}
+
+	entries, _ = list.AuditLog(ctx, common.AuditOptions{Limit: 1, Offset: 1})
+	if len(entries) != 1 || entries[0].Action != "task.cancel" {
+		t.Errorf("offset and limit should page the entries, got %+v", entries)
+	}
+}
+
+func TestRedisElect(t *testing.T) {
+	list := newTestList(t)
+	defer func(ttl time.Duration) { leaderTTL = ttl }(leaderTTL)
+	leaderTTL = 300 * time.Millisecond
+
+	a, err := list.Elect(context.Background(), "clams", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := list.Elect(ctx, "clams", "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("only one candidate should be elected, got %v", err)
+	}
+
+	// 续期使锁一直有效
+	select {
+	case <-a.Lost():
+		t.Fatal("leader should keep renewing")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if err := a.Resign(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := list.Elect(context.Background(), "clams", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 锁被删除后续期失败
+	list.client.Del(context.Background(), list.key("leader", "clams"))
+	select {
+	case <-b.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("leader without lock should be lost")
+	}
+}
+
+func TestRedisLabels(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	ids, err := list.WriteBatch(ctx, []common.RawTask{
+		{Description: "a", Labels: map[string]string{"target": "ch-prod", "team": "data"}},
+		{Description: "b", Labels: map[string]string{"target": "ch-prod"}},
+		{Description: "c", Labels: map[string]string{"target": "ch-test", "team": "data"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := list.List(ctx, common.ListOptions{Labels: map[string]string{"target": "ch-prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != ids[1] || infos[1].ID != ids[0] || infos[1].Labels["team"] != "data" {
+		t.Fatalf("tasks with the label should be listed newest first, got %+v", infos)
+	}
+
+	infos, _ = list.List(ctx, common.ListOptions{State: common.StatePending, Labels: map[string]string{"target": "ch-prod", "team": "data"}})
+	if len(infos) != 1 || infos[0].ID != ids[0] {
+		t.Fatalf("tasks should have all the labels, got %+v", infos)
+	}
+
+	cancelled, err := list.CancelMatching(ctx, common.ListOptions{Labels: map[string]string{"target": "ch-prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cancelled) != 2 {
+		t.Fatalf("pending tasks with the label should be cancelled, got %v", cancelled)
+	}
+	if info, _ := list.Status(ctx, "", ids[2]); info.State != common.StatePending {
+		t.Fatalf("task without the label should be kept, got %s", info.State)
+	}
+
+	// 清理后不再留在标签索引中
+	time.Sleep(10 * time.Millisecond)
+	if _, err := list.Purge(ctx, common.PurgePolicy{MaxAge: time.Millisecond}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := list.client.ZCard(ctx, list.labelKey("target", "ch-prod")).Result(); n != 0 {
+		t.Errorf("purged tasks should leave the label index, %d left", n)
+	}
+}
+
+func TestRedisEdit(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	id, err := list.Write(ctx, common.RawTask{
+		Description: "old",
+		ScheduledAt: "2099-01-01 00:00:00",
+		Labels:      map[string]string{"target": "ch-test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = list.Edit(ctx, "", id, "ops", func(cur common.RawTask) (common.RawTask, error) {
+		if cur.Description != "old" || cur.ScheduledAt != "2099-01-01 00:00:00" || cur.Labels["target"] != "ch-test" {
+			t.Errorf("current task should be given, got %+v", cur)
+		}
+		return common.RawTask{Description: "new", Labels: map[string]string{"target": "ch-prod"}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, _ := list.Status(ctx, "", id)
+	if info.Revision != 1 || info.Labels["target"] != "ch-prod" {
+		t.Fatalf("task should be edited, got %+v", info)
+	}
+	if infos, _ := list.List(ctx, common.ListOptions{Labels: map[string]string{"target": "ch-test"}}); len(infos) != 0 {
+		t.Errorf("old label should be unindexed, got %+v", infos)
+	}
+
+	revisions, err := list.Revisions(ctx, "", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Revision != 0 || revisions[0].Description != "old" || revisions[0].ReplacedBy != "ops" {
+		t.Fatalf("previous revision should be kept, got %+v", revisions)
+	}
+
+	// 计划时间改为现在，可以被领取，领取后不能再修改
+	task := readWithin(t, list, time.Second)
+	if task == nil || task.Description() != "new" {
+		t.Fatalf("edited task should be claimed, got %v", task)
+	}
+	err = list.Edit(ctx, "", id, "ops", func(cur common.RawTask) (common.RawTask, error) { return cur, nil })
+	if !errors.Is(err, common.ErrConflict) {
+		t.Fatalf("claimed task should not be edited, got %v", err)
+	}
+}
+
+func TestRedisExportImport(t *testing.T) {
+	src, dst := newTestList(t), newTestList(t)
+	ctx := context.Background()
+
+	if _, err := src.Write(ctx, common.RawTask{Description: "done", Params: map[string]string{"table": "t1"}}); err != nil {
+		t.Fatal(err)
+	}
+	task := readWithin(t, src, time.Second)
+	task.SaveCheckpoint(ctx, "offset", "42")
+	task.Log(ctx, "working")
+	task.Done(ctx)
+
+	pending, _ := src.Write(ctx, common.RawTask{Description: "old", ScheduledAt: "2099-01-01 00:00:00", Labels: map[string]string{"team": "data"}})
+	src.Edit(ctx, "", pending, "ops", func(cur common.RawTask) (common.RawTask, error) {
+		cur.Description = "new"
+		return cur, nil
+	})
+
+	var records []common.TaskRecord
+	err := src.Export(ctx, common.ListOptions{}, func(batch []common.TaskRecord) error {
+		records = append(records, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Checkpoints["offset"] != "42" || len(records[1].Revisions) != 1 {
+		t.Fatalf("records should be exported oldest first with checkpoints and revisions, got %+v", records)
+	}
+
+	var labelled []common.TaskRecord
+	src.Export(ctx, common.ListOptions{Labels: map[string]string{"team": "data"}}, func(batch []common.TaskRecord) error {
+		labelled = append(labelled, batch...)
+		return nil
+	})
+	if len(labelled) != 1 || labelled[0].ID != pending {
+		t.Errorf("export should be filtered by labels, got %+v", labelled)
+	}
+
+	ids, err := dst.Import(ctx, records)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("import should succeed, got %v %v", ids, err)
+	}
+
+	done, _ := dst.Status(ctx, "", ids[0])
+	if done.State != common.StateDone || done.Params["table"] != "t1" || done.Checkpoints["offset"] != "42" {
+		t.Errorf("done task should be restored, got %+v", done)
+	}
+	logs, _ := dst.Logs(ctx, "", ids[0])
+	if len(logs) != len(records[0].Logs)+1 || logs[len(logs)-1].Message != "imported from task "+records[0].ID {
+		t.Errorf("logs should be restored with a note, got %+v", logs)
+	}
+	if infos, _ := dst.List(ctx, common.ListOptions{State: common.StatePending, Labels: map[string]string{"team": "data"}}); len(infos) != 1 || infos[0].Revision != 1 {
+		t.Errorf("pending task should be indexed by state and labels, got %+v", infos)
+	}
+	if revisions, _ := dst.Revisions(ctx, "", ids[1]); len(revisions) != 1 || revisions[0].Description != "old" {
+		t.Errorf("revisions should be restored, got %+v", revisions)
+	}
+	if peek, _ := dst.Peek(ctx, "", ids[1]); peek.Description != "new" {
+		t.Errorf("description should be restored, got %+v", peek)
+	}
+	if n, _ := dst.Purge(ctx, common.PurgePolicy{MaxAge: time.Nanosecond}, nil); n != 1 {
+		t.Errorf("imported done task should be purgeable, got %d", n)
+	}
+}
+
+func TestRedisDefer(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	id, err := list.Write(ctx, common.RawTask{
+		Description: "input: {}",
+		Window:      "* 0-5 * * *",
+		Concurrency: []common.ConcurrencyGroup{{Key: "mysql-prod", Limit: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := readWithin(t, list, time.Second)
+	if task == nil || task.Window() != "* 0-5 * * *" || len(task.Concurrency()) != 1 {
+		t.Fatalf("task should be claimed with its window and groups, got %v", task)
+	}
+
+	until := time.Now().Add(time.Hour)
+	if err := task.Defer(ctx, until, "outside window"); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := list.Status(ctx, "", id)
+	if info.State != common.StatePending || info.PerformedBy != "" || info.ScheduledAt.UnixMilli() != until.UnixMilli() {
+		t.Fatalf("deferred task should be pending until later, got %+v", info)
+	}
+	if running, _ := list.client.SCard(ctx, list.key("running", "mysql-prod")).Result(); running != 0 {
+		t.Errorf("deferred task should release its concurrency group, got %d", running)
+	}
+	if readWithin(t, list, 100*time.Millisecond) != nil {
+		t.Error("deferred task should not be claimed before its time")
+	}
+	logs, _ := list.Logs(ctx, "", id)
+	if len(logs) == 0 || !strings.HasPrefix(logs[len(logs)-1].Message, "deferred until") {
+		t.Errorf("deferral should be logged, got %+v", logs)
+	}
+}
+
+func TestRedisMarkOverdue(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	layout := "2006-01-02 15:04:05"
+	now := time.Now().In(list.location)
+	past := now.Add(-time.Minute).Format(layout)
+	future := now.Add(time.Hour).Format(layout)
+
+	// 截止前已结束的任务不算逾期
+	doneID, _ := list.Write(ctx, common.RawTask{Description: "input: {}", Deadline: past})
+	task := readWithin(t, list, time.Second)
+	if task == nil || task.ID() != doneID {
+		t.Fatalf("task %s should be claimed, got %v", doneID, task)
+	}
+	task.Done(ctx)
+
+	lateID, _ := list.Write(ctx, common.RawTask{Description: "input: {}", Deadline: past, ScheduledAt: future})
+	list.Write(ctx, common.RawTask{Description: "input: {}", Deadline: future, ScheduledAt: future})
+	list.Write(ctx, common.RawTask{Description: "input: {}", ScheduledAt: future})
+
+	infos, err := list.MarkOverdue(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].ID != lateID || infos[0].OverdueAt == nil || infos[0].State != common.StatePending {
+		t.Fatalf("only the pending task past its deadline should be marked, got %+v", infos)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now()); len(infos) != 1 || infos[0].ID != lateID {
+		t.Errorf("overdue task should be returned until notified, got %+v", infos)
+	}
+	if err := list.OverdueNotified(ctx, lateID); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now()); len(infos) != 0 {
+		t.Errorf("overdue task should be marked and notified once, got %+v", infos)
+	}
+	if n, _ := list.Count(ctx, common.ListOptions{Overdue: true}); n != 1 {
+		t.Errorf("count of overdue tasks should be 1, got %d", n)
+	}
+	if infos, _ := list.List(ctx, common.ListOptions{State: common.StatePending, Overdue: true}); len(infos) != 1 || infos[0].ID != lateID {
+		t.Errorf("overdue filter should list the late task, got %+v", infos)
+	}
+	logs, _ := list.Logs(ctx, "", lateID)
+	if len(logs) == 0 || !strings.HasPrefix(logs[len(logs)-1].Message, "overdue") {
+		t.Errorf("overdue should be logged, got %+v", logs)
+	}
+	if logs, _ := list.Logs(ctx, "", lateID); len(logs) > 1 && strings.HasPrefix(logs[len(logs)-2].Message, "overdue") {
+		t.Errorf("overdue should be logged once, got %+v", logs)
+	}
+
+	// 推后截止时间后不再逾期，到时再次检查
+	err = list.Edit(ctx, "", lateID, "ops", func(cur common.RawTask) (common.RawTask, error) {
+		cur.Deadline = future
+		return cur, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := list.Status(ctx, "", lateID); info.OverdueAt != nil || info.Deadline == nil {
+		t.Errorf("new deadline should clear overdue, got %+v", info)
+	}
+	infos, _ = list.MarkOverdue(ctx, time.Now().Add(2*time.Hour))
+	if len(infos) != 2 {
+		t.Errorf("both tasks should be overdue later, got %+v", infos)
+	}
+	if n, _ := list.Count(ctx, common.ListOptions{}); n != 4 {
+		t.Errorf("count should cover all tasks, got %d", n)
+	}
+}
+
+func TestRedisNamespaces(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+
+	ids, err := list.WriteBatch(ctx, []common.RawTask{{Description: "a"}, {Namespace: "team-a", Description: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := list.List(ctx, common.ListOptions{Namespace: "team-a"})
+	if err != nil || len(infos) != 1 || infos[0].ID != ids[1] || infos[0].Namespace != "team-a" {
+		t.Fatalf("only tasks in the namespace should be listed, got %+v %v", infos, err)
+	}
+	if n, _ := list.Count(ctx, common.ListOptions{Namespace: common.DefaultNamespace}); n != 1 {
+		t.Errorf("tasks written without a namespace should be in the default one, got %d", n)
+	}
+
+	if _, err := list.Status(ctx, "team-a", ids[0]); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("task in another namespace should be not found, got %v", err)
+	}
+	if err := list.Delete(ctx, "team-a", ids[0]); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("task in another namespace should not be cancelled, got %v", err)
+	}
+	if raw, err := list.Peek(ctx, "", ids[1]); err != nil || raw.Namespace != "team-a" {
+		t.Errorf("peek should return the namespace, got %+v %v", raw, err)
+	}
+
+	task := readWithin(t, list, time.Second)
+	if task == nil || task.Namespace() != common.DefaultNamespace {
+		t.Fatalf("claimed task should know its namespace, got %v", task)
+	}
+	task.Done(ctx)
+
+	// 锚点按命名空间隔离
+	list.PutAnchor(ctx, "", "db", "default")
+	list.PutAnchor(ctx, "team-a", "db", "team-a")
+	set, err := list.ListAnchors(ctx, "team-a")
+	if err != nil || len(set.Anchors) != 1 || set.Anchors[0].Content != "team-a" {
+		t.Fatalf("anchors should be isolated, got %+v %v", set, err)
+	}
+	if set, _ := list.ListAnchors(ctx, common.DefaultNamespace); len(set.Anchors) != 1 || set.Anchors[0].Content != "default" {
+		t.Errorf("default anchors should be kept apart, got %+v", set)
+	}
+}
+
+func TestRedisRequeueExpiredLease(t *testing.T) {
+	defer func(ttl time.Duration) { leaseTTL = ttl }(leaseTTL)
+	leaseTTL = 300 * time.Millisecond
+
+	alive := newTestList(t)
+	ctx := context.Background()
+	group := []common.ConcurrencyGroup{{Key: "db", Limit: 1}}
+	orphanID, _ := alive.Write(ctx, common.RawTask{Description: "orphan", Concurrency: group})
+	waitingID, _ := alive.Write(ctx, common.RawTask{Description: "waiting", Concurrency: group})
+
+	// 另一台服务器领取任务后崩溃，不再续租
+	deadCtx, crash := context.WithCancel(context.Background())
+	dead, err := Init(deadCtx, map[string]any{"url": "redis://" + alive.client.Options().Addr, "prefix": alive.prefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close(ctx)
+	if task, err := dead.claim(ctx, "dead-worker"); err != nil || task == nil || task.ID() != orphanID {
+		t.Fatalf("orphan should be claimed first, got %v %v", task, err)
+	}
+	crash()
+
+	if readWithin(t, alive, 100*time.Millisecond) != nil {
+		t.Fatal("group should be full while the lease is valid")
+	}
+
+	time.Sleep(leaseTTL)
+	claimed := readWithin(t, alive, time.Second)
+	if claimed == nil || claimed.ID() != waitingID {
+		t.Fatalf("expired lease should release the group, got %v", claimed)
+	}
+	orphan, _ := alive.Status(ctx, "", orphanID)
+	if orphan.State != common.StatePending || orphan.PerformedBy != "" {
+		t.Errorf("orphan should be pending again, got %+v", orphan)
+	}
+	logs, _ := alive.Logs(ctx, "", orphanID)
+	if len(logs) == 0 || logs[len(logs)-1].Message != "requeued: lease of dead-worker expired" {
+		t.Errorf("requeue should be logged, got %+v", logs)
+	}
+
+	// 存活的服务器续租，它的任务不会被放回
+	time.Sleep(2 * leaseTTL)
+	if readWithin(t, alive, 100*time.Millisecond) != nil {
+		t.Error("renewed lease should keep the group taken")
+	}
+	if info, _ := alive.Status(ctx, "", waitingID); info.State != common.StateRunning {
+		t.Errorf("task with a renewed lease should keep running, got %+v", info)
+	}
+	if running, _ := alive.client.SMembers(ctx, alive.key("running", "db")).Result(); len(running) != 1 || running[0] != waitingID {
+		t.Errorf("only the claimed task should hold the group, got %v", running)
+	}
+
+	claimed.Done(ctx)
+	if n, _ := alive.client.ZCard(ctx, alive.key("leases")).Result(); n != 0 {
+		t.Errorf("finished task should drop its lease, got %d", n)
+	}
+}
+
+func TestRedisClaimBehindBlockedTasks(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	group := []common.ConcurrencyGroup{{Key: "db", Limit: 1}}
+	earlier := time.Now().In(list.location).Add(-time.Minute).Format("2006-01-02 15:04:05")
+
+	busy := make([]common.RawTask, 2*claimPageSize+1)
+	for i := range busy {
+		busy[i] = common.RawTask{Description: "busy", Concurrency: group, ScheduledAt: earlier}
+	}
+	if _, err := list.WriteBatch(ctx, busy); err != nil {
+		t.Fatal(err)
+	}
+	if task := readWithin(t, list, time.Second); task == nil || task.Description() != "busy" {
+		t.Fatalf("first task of the group should be claimed, got %v", task)
+	}
+	freeID, _ := list.Write(ctx, common.RawTask{Description: "free"})
+
+	task := readWithin(t, list, time.Second)
+	if task == nil || task.ID() != freeID {
+		t.Fatalf("task behind those waiting for a full group should be claimed, got %v", task)
+	}
+	if readWithin(t, list, 100*time.Millisecond) != nil {
+		t.Error("tasks of the full group should keep waiting")
+	}
+}
+
+func TestRedisStaleOwnerCanNotFinish(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	group := []common.ConcurrencyGroup{{Key: "db", Limit: 1}}
+	id, _ := list.Write(ctx, common.RawTask{Description: "input: {}", Concurrency: group})
+
+	stale, err := list.claim(ctx, "stale-worker")
+	if err != nil || stale == nil {
+		t.Fatalf("task should be claimed, got %v %v", stale, err)
+	}
+	// 租约过期后任务被放回并由另一个worker领取
+	list.client.ZAdd(ctx, list.key("leases"), redis.Z{Score: 0, Member: id})
+	owner, err := list.claim(ctx, "new-worker")
+	if err != nil || owner == nil || owner.ID() != id {
+		t.Fatalf("requeued task should be claimed again, got %v %v", owner, err)
+	}
+
+	stale.Done(ctx)
+	stale.Error(ctx, errors.New("aborted"))
+	stale.Defer(ctx, time.Now().Add(time.Hour), "window closed")
+	info, _ := list.Status(ctx, "", id)
+	if info.State != common.StateRunning || info.PerformedBy != "new-worker" {
+		t.Errorf("stale worker should not change the run of the new owner, got %+v", info)
+	}
+	if n, _ := list.client.ZScore(ctx, list.key("leases"), id).Result(); n == 0 {
+		t.Error("lease of the new owner should be kept")
+	}
+	if running, _ := list.client.SMembers(ctx, list.key("running", "db")).Result(); len(running) != 1 || running[0] != id {
+		t.Errorf("group slot of the new owner should be kept, got %v", running)
+	}
+	logs, _ := list.Logs(ctx, "", id)
+	for _, line := range logs {
+		if line.Message == "done" || strings.HasPrefix(line.Message, "error") || strings.HasPrefix(line.Message, "deferred") {
+			t.Errorf("stale worker should not log on the task, got %+v", logs)
+		}
+	}
+
+	owner.Done(ctx)
+	if info, _ := list.Status(ctx, "", id); info.State != common.StateDone {
+		t.Errorf("owner should finish the task, got %+v", info)
+	}
+}
+
+func TestRedisRerunClearsPastDeadline(t *testing.T) {
+	list := newTestList(t)
+	ctx := context.Background()
+	layout := "2006-01-02 15:04:05"
+	now := time.Now().In(list.location)
+
+	lateID, _ := list.Write(ctx, common.RawTask{Description: "input: {}", Deadline: now.Add(-time.Minute).Format(layout)})
+	laterID, _ := list.Write(ctx, common.RawTask{Description: "input: {}", Deadline: now.Add(time.Hour).Format(layout)})
+	for range []string{lateID, laterID} {
+		task := readWithin(t, list, time.Second)
+		if task == nil {
+			t.Fatal("tasks should be claimed")
+		}
+		task.Error(ctx, errors.New("boom"))
+	}
+
+	for _, id := range []string{lateID, laterID} {
+		if err := list.Rerun(ctx, "", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, _ := list.Status(ctx, "", lateID); info.Deadline != nil {
+		t.Errorf("rerun should clear a past deadline, got %+v", info)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now()); len(infos) != 0 {
+		t.Errorf("rerun task should not be overdue right away, got %+v", infos)
+	}
+	if infos, _ := list.MarkOverdue(ctx, time.Now().Add(2*time.Hour)); len(infos) != 1 || infos[0].ID != laterID {
+		t.Errorf("rerun should keep a deadline still ahead, got %+v", infos)
+	}
+}
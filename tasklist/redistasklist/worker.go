@@ -0,0 +1,73 @@
+package redistasklist
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/turnon/clams/tasklist/common"
+)
+
+// workerRetention 超过这么久没有心跳的worker会被清除
+const workerRetention = 24 * time.Hour
+
+// Heartbeat 登记worker及其当前任务
+func (list *redisTaskList) Heartbeat(ctx context.Context, info common.WorkerInfo) error {
+	now := time.Now()
+	info.HeartbeatAt = &now
+	info.Alive = false
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := list.client.HSet(ctx, list.key("workers"), info.ID, raw).Err(); err != nil {
+		return err
+	}
+
+	workers, err := list.Workers(ctx, workerRetention)
+	if err != nil {
+		return err
+	}
+	for _, w := range workers {
+		if !w.Alive {
+			list.client.HDel(ctx, list.key("workers"), w.ID)
+		}
+	}
+	return nil
+}
+
+// UnregisterWorker 注销worker
+func (list *redisTaskList) UnregisterWorker(ctx context.Context, id string) error {
+	return list.client.HDel(ctx, list.key("workers"), id).Err()
+}
+
+// Workers 列出worker，timeout内有心跳的为存活
+func (list *redisTaskList) Workers(ctx context.Context, timeout time.Duration) ([]common.WorkerInfo, error) {
+	raw, err := list.client.HGetAll(ctx, list.key("workers")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now().Add(-timeout)
+	workers := make([]common.WorkerInfo, 0, len(raw))
+	for _, str := range raw {
+		var info common.WorkerInfo
+		if err := json.Unmarshal([]byte(str), &info); err != nil {
+			return nil, err
+		}
+		info.Alive = info.HeartbeatAt != nil && !info.HeartbeatAt.Before(since)
+		workers = append(workers, info)
+	}
+
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+	return workers, nil
+}
+
+// RequeueOrphans redis中运行的任务靠租约放回，领取时已放回租约过期的任务，这里不再处理
+func (list *redisTaskList) RequeueOrphans(ctx context.Context, timeout time.Duration) ([]string, error) {
+	return nil, nil
+}
@@ -6,11 +6,15 @@ import (
 
 	"github.com/turnon/clams/tasklist/common"
 	"github.com/turnon/clams/tasklist/pgtasklist"
+	"github.com/turnon/clams/tasklist/redistasklist"
 )
 
 func NewTaskList(ctx context.Context, cfg map[string]any) (common.Tasklist, error) {
 	if cfg["type"] == "pg" {
 		return pgtasklist.Init(ctx, cfg)
 	}
+	if cfg["type"] == "redis" {
+		return redistasklist.Init(ctx, cfg)
+	}
 	return nil, errors.New("no tasklist config")
 }